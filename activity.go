@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activity tracks the pages and downloads in flight, for the status dumps
+// of SIGUSR1 and the status command.
+var activity = &activityRegistry{
+	pages:     map[*pageActivity]bool{},
+	downloads: map[*downloadActivity]bool{},
+}
+
+type activityRegistry struct {
+	mu        sync.Mutex
+	pages     map[*pageActivity]bool
+	downloads map[*downloadActivity]bool
+}
+
+type pageActivity struct {
+	Url      string
+	Started  time.Time
+	Progress *Progress
+	inflight int64
+}
+
+type downloadActivity struct {
+	Url     string
+	Started time.Time
+	page    *pageActivity
+	// ctx, index and src are for the ImageProgress events.
+	ctx   context.Context
+	index int
+	src   string
+	bytes int64
+	// total is the Content-Length, or 0 when unknown.
+	total int64
+	// last is the UnixNano of the last read.
+	last int64
+}
+
+type activityKey struct{}
+
+// startPage registers a scrape of url until the returned func is called.
+func (r *activityRegistry) startPage(ctx context.Context, url string, progress *Progress) (context.Context, func()) {
+	page := &pageActivity{Url: url, Started: time.Now(), Progress: progress}
+	r.mu.Lock()
+	r.pages[page] = true
+	r.mu.Unlock()
+	return context.WithValue(ctx, activityKey{}, page), func() {
+		r.mu.Lock()
+		delete(r.pages, page)
+		r.mu.Unlock()
+	}
+}
+
+// startDownload registers a download of image i, src, by the page of ctx
+// until the returned func is called. Bodies read through countBody add to
+// it.
+func (r *activityRegistry) startDownload(ctx context.Context, i int, src string) (context.Context, func()) {
+	page, _ := ctx.Value(activityKey{}).(*pageActivity)
+	d := &downloadActivity{Url: displaySrc(src), Started: time.Now(), page: page, ctx: ctx, index: i, src: src, last: time.Now().UnixNano()}
+	if page != nil {
+		atomic.AddInt64(&page.inflight, 1)
+	}
+	r.mu.Lock()
+	r.downloads[d] = true
+	r.mu.Unlock()
+	return context.WithValue(ctx, activityKey{}, d), func() {
+		if page != nil {
+			atomic.AddInt64(&page.inflight, -1)
+		}
+		r.mu.Lock()
+		delete(r.downloads, d)
+		r.mu.Unlock()
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	d *downloadActivity
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if 0 < n {
+		bytes := atomic.AddInt64(&c.d.bytes, int64(n))
+		atomic.StoreInt64(&c.d.last, time.Now().UnixNano())
+		emit(c.d.ctx, ImageProgress{Url: pageUrl(c.d.ctx), Index: c.d.index, Src: c.d.src, Bytes: bytes, Total: atomic.LoadInt64(&c.d.total)})
+	}
+	return n, err
+}
+
+// countBody counts what is read of body into the download of ctx.
+func countBody(ctx context.Context, body io.Reader) io.Reader {
+	if d, ok := ctx.Value(activityKey{}).(*downloadActivity); ok {
+		return countingReader{body, d}
+	}
+	return body
+}
+
+// setDownloadTotal records the size of the download of ctx, if known.
+func setDownloadTotal(ctx context.Context, total int64) {
+	if d, ok := ctx.Value(activityKey{}).(*downloadActivity); ok && 0 < total {
+		atomic.StoreInt64(&d.total, total)
+	}
+}
+
+// Write prints every page in flight with its progress and queue, then
+// every download in flight, oldest first.
+func (r *activityRegistry) Write(w io.Writer) {
+	r.mu.Lock()
+	pages := make([]*pageActivity, 0, len(r.pages))
+	for page := range r.pages {
+		pages = append(pages, page)
+	}
+	downloads := make([]*downloadActivity, 0, len(r.downloads))
+	for d := range r.downloads {
+		downloads = append(downloads, d)
+	}
+	r.mu.Unlock()
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Started.Before(pages[j].Started) })
+	sort.Slice(downloads, func(i, j int) bool { return downloads[i].Started.Before(downloads[j].Started) })
+
+	now := time.Now()
+	fmt.Fprintln(w, len(pages), "pages,", len(downloads), "downloads in flight")
+	for _, page := range pages {
+		p := page.Progress.Snapshot()
+		inflight := atomic.LoadInt64(&page.inflight)
+		queued := p.Total - p.Done - p.Failed - inflight
+		if queued < 0 {
+			queued = 0
+		}
+		fmt.Fprintf(w, "  %s  %d/%d images, %d failed, %d downloading, %d queued, %s, %s\n",
+			page.Url, p.Done, p.Total, p.Failed, inflight, queued, formatBytes(p.Bytes),
+			now.Sub(page.Started).Round(time.Second))
+	}
+	for _, d := range downloads {
+		fmt.Fprintf(w, "  ↓ %s  %s, %s\n", d.Url, d.size(),
+			now.Sub(d.Started).Round(100*time.Millisecond))
+	}
+}
+
+// size formats the bytes read so far, out of the total when known.
+func (d *downloadActivity) size() string {
+	s := formatBytes(atomic.LoadInt64(&d.bytes))
+	if total := atomic.LoadInt64(&d.total); 0 < total {
+		s += " of " + formatBytes(total)
+	}
+	return s
+}
@@ -0,0 +1,4 @@
+package main
+
+// dumpActivityOnSignal does nothing, Windows has no SIGUSR1.
+func dumpActivityOnSignal() {}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Api takes the images of a page from a JSON API instead of its HTML.
+// ApiUrl may refer to the groups of ApiUrlPattern, matched against the
+// page URL, as $1 or ${name}. ApiImagesPath and ApiTitlePath are JSON
+// paths as in extract; without ApiTitlePath the HTML is still fetched for
+// the title.
+type Api struct {
+	ApiUrl        string `toml:"api_url"`
+	ApiUrlPattern string `toml:"api_url_pattern"`
+	ApiImagesPath string `toml:"api_images_path"`
+	ApiTitlePath  string `toml:"api_title_path"`
+
+	apiUrlPattern *regexp.Regexp
+	apiImagesPath []pathStep
+	apiTitlePath  []pathStep
+}
+
+func (a *Api) compile() error {
+	if a.ApiUrl == "" {
+		if a.ApiUrlPattern != "" || a.ApiImagesPath != "" || a.ApiTitlePath != "" {
+			return errors.New("api_url is required by api_url_pattern, api_images_path and api_title_path")
+		}
+		return nil
+	}
+	if a.ApiImagesPath == "" {
+		return errors.New("api_images_path is required by api_url")
+	}
+	if a.ApiUrlPattern != "" {
+		re, err := regexp.Compile(a.ApiUrlPattern)
+		if err != nil {
+			return fmt.Errorf("api_url_pattern: %v", err)
+		}
+		a.apiUrlPattern = re
+	}
+	path, err := parseJsonPath(a.ApiImagesPath)
+	if err != nil {
+		return fmt.Errorf("api_images_path: %v", err)
+	}
+	a.apiImagesPath = path
+	if a.ApiTitlePath != "" {
+		path, err := parseJsonPath(a.ApiTitlePath)
+		if err != nil {
+			return fmt.Errorf("api_title_path: %v", err)
+		}
+		a.apiTitlePath = path
+	}
+	return nil
+}
+
+// apiUrl expands ApiUrl for the page URL rawurl and resolves it against it.
+func (a *Api) apiUrl(rawurl string) (string, error) {
+	target := a.ApiUrl
+	if a.apiUrlPattern != nil {
+		m := a.apiUrlPattern.FindStringSubmatchIndex(rawurl)
+		if m == nil {
+			return "", errors.New("api_url_pattern " + strconv.Quote(a.ApiUrlPattern) + " does not match " + rawurl)
+		}
+		target = string(a.apiUrlPattern.ExpandString(nil, a.ApiUrl, rawurl, m))
+	}
+	base, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("api_url: %v", err)
+	}
+	return u.String(), nil
+}
+
+// apiResult is what the API of a page answered: the image URLs, resolved
+// against the API URL, and the title when ApiTitlePath is set.
+type apiResult struct {
+	Url   string
+	Srcs  []string
+	Title string
+}
+
+func fetchApi(ctx context.Context, page *Page, client *http.Client, rawurl string) (*apiResult, error) {
+	target, err := page.apiUrl(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	debugln(res.Proto, res.Status, target)
+	logRedirect(target, res)
+	if 400 <= res.StatusCode {
+		return nil, &StatusError{Url: target, Code: res.StatusCode, Status: res.Status}
+	}
+
+	var v interface{}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("api_url %s: invalid JSON: %v", target, err)
+	}
+	result := &apiResult{Url: res.Request.URL.String()}
+	var srcs []string
+	for _, value := range evaluate(v, page.apiImagesPath) {
+		if s, ok := value.(string); ok {
+			srcs = append(srcs, s)
+		}
+	}
+	result.Srcs = resolveSrcs(res.Request.URL, srcs)
+	if len(result.Srcs) == 0 {
+		return nil, errors.New("api_images_path " + strconv.Quote(page.ApiImagesPath) + " found no image URLs in " + target)
+	}
+	if page.apiTitlePath != nil {
+		for _, value := range evaluate(v, page.apiTitlePath) {
+			if s, ok := value.(string); ok && s != "" {
+				result.Title = sanitize(s)
+				break
+			}
+		}
+		if result.Title == "" {
+			return nil, fmt.Errorf("%w: api_title_path %q found nothing in %s", errNoTitle, page.ApiTitlePath, target)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"filippo.io/age"
+	"golang.org/x/text/unicode/norm"
+	"io"
+	"time"
+)
+
+// EntryMeta is what an archive entry carries besides its data. Method is
+// zip.Deflate or zip.Store; zip-aes entries are always deflated.
+type EntryMeta struct {
+	Modified time.Time
+	Method   uint16
+	Comment  string
+}
+
+// ZipBuilder streams an archive into any writer, an HTTP response as
+// well as a spooled Body, encrypted as its ArchiveOptions say. Entries
+// go out as they are added; nothing is kept but the central directory.
+type ZipBuilder struct {
+	ctx      context.Context
+	zip      *zip.Writer
+	sealed   io.WriteCloser
+	password string
+}
+
+// NewZipBuilder starts an archive written to w. The archives of scrapes
+// are built the same way.
+func NewZipBuilder(w io.Writer, opts ArchiveOptions) (*ZipBuilder, error) {
+	return newZipBuilder(context.Background(), w, opts)
+}
+
+// newZipBuilder is NewZipBuilder spooling the zip-aes entries it
+// compresses like the bodies of ctx.
+func newZipBuilder(ctx context.Context, w io.Writer, opts ArchiveOptions) (*ZipBuilder, error) {
+	b := &ZipBuilder{ctx: ctx, password: opts.Password}
+	if 0 < len(opts.Recipients) {
+		sealed, err := age.Encrypt(w, opts.Recipients...)
+		if err != nil {
+			return nil, err
+		}
+		b.sealed, w = sealed, sealed
+	}
+	b.zip = zip.NewWriter(w)
+	// zip-aes leaves comments in plain text, and they name the source
+	// URL, so encrypted entries go without them.
+	if opts.Password == "" {
+		if err := b.zip.SetComment(opts.Comment); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Add writes r as the entry name.
+func (b *ZipBuilder) Add(name string, r io.Reader, meta EntryMeta) error {
+	// Names are NFC and flagged as UTF-8 even when archive/zip would not
+	// need to, so no extractor falls back to CP437.
+	header := &zip.FileHeader{
+		Name:     norm.NFC.String(name),
+		Comment:  meta.Comment,
+		Method:   meta.Method,
+		Modified: meta.Modified.UTC(),
+		Flags:    zipUTF8Flag,
+		NonUTF8:  false,
+	}
+	if b.password != "" {
+		header.Comment = ""
+		return createAesEntry(b.ctx, b.zip, header, r, b.password)
+	}
+	w, err := b.zip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Close finishes the archive, leaving the writer open.
+func (b *ZipBuilder) Close() error {
+	if err := b.zip.Close(); err != nil {
+		return err
+	}
+	if b.sealed != nil {
+		return b.sealed.Close()
+	}
+	return nil
+}
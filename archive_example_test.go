@@ -0,0 +1,50 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+// Archives stream straight into an HTTP response, without touching disk.
+func ExampleNewZipBuilder() {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="gallery.zip"`)
+		builder, err := NewZipBuilder(w, ArchiveOptions{Comment: "https://example.com/gallery"})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		modified := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+		for i, image := range []string{"first image", "second image"} {
+			name := fmt.Sprintf("%d-image.jpg", i)
+			if err := builder.Add(name, strings.NewReader(image), EntryMeta{Modified: modified, Method: zip.Deflate}); err != nil {
+				return
+			}
+		}
+		builder.Close()
+	})
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/gallery.zip", nil))
+
+	body := res.Body.Bytes()
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(r.Comment)
+	for _, f := range r.File {
+		fmt.Println(f.Name, f.Modified.UTC().Format(time.RFC3339))
+	}
+	// Output:
+	// https://example.com/gallery
+	// 0-image.jpg 2024-03-09T12:00:00Z
+	// 1-image.jpg 2024-03-09T12:00:00Z
+}
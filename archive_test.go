@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang.org/x/text/unicode/norm"
+	"io"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, body *Body) []byte {
+	t.Helper()
+	b, err := io.ReadAll(body.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// readZip opens the archive in body with the standard library reader.
+func readZip(t *testing.T, body *Body) *zip.Reader {
+	t.Helper()
+	b := readAll(t, body)
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestZipEntryNamesRoundTrip(t *testing.T) {
+	names := []string{
+		"0-plain.jpg",
+		// が and パ decomposed, as macOS file names come.
+		norm.NFD.String("1-がパ.jpg"),
+		"2-写真 (1).png",
+		"folder/3-ü.gif",
+	}
+	var images []*Image
+	for i, name := range names {
+		images = append(images, &Image{Name: name, Bytes: newBody([]byte("image")), Index: i})
+	}
+	body, err := createZip(context.Background(), images, time.Now(), ArchiveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	r := readZip(t, body)
+	if len(r.File) != len(names) {
+		t.Fatalf("%d entries, want %d", len(r.File), len(names))
+	}
+	for i, f := range r.File {
+		want := norm.NFC.String(names[i])
+		if f.Name != want {
+			t.Errorf("entry %d: name %q, want %q", i, f.Name, want)
+		}
+		if !norm.NFC.IsNormalString(f.Name) {
+			t.Errorf("entry %q is not NFC", f.Name)
+		}
+		if f.Flags&zipUTF8Flag == 0 || f.NonUTF8 {
+			t.Errorf("entry %q is not flagged as UTF-8 (flags %#x)", f.Name, f.Flags)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(b) != "image" {
+			t.Errorf("entry %q reads %q, %v", f.Name, b, err)
+		}
+	}
+}
+
+func TestManifestAsciiNames(t *testing.T) {
+	images := []*Image{{Name: norm.NFD.String("0-Café.jpg"), Bytes: newBody([]byte("image"))}}
+	entry, err := newManifest(&Result{Url: "https://example.com/"}, images, nil, true, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m manifest
+	if err := json.NewDecoder(entry.Bytes.Reader()).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Images[0]; got.Name != "0-Café.jpg" || got.AsciiName != "0-Cafe.jpg" {
+		t.Errorf("name %q, ascii_name %q", got.Name, got.AsciiName)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultAutoConfidence = 0.5
+
+var (
+	decorationPattern = regexp.MustCompile(`(?i)(logo|icon|avatar|sprite|banner|emoji|badge|button|spacer|pixel|ads?[/_.-])`)
+	sequencePattern   = regexp.MustCompile(`\d{2,}[^/]*$`)
+	srcsetWidth       = regexp.MustCompile(`(\d+)w`)
+)
+
+// elementSignature describes el as tag plus sorted classes, e.g.
+// "div.item.page".
+func elementSignature(el *goquery.Selection) string {
+	sig := goquery.NodeName(el)
+	class, _ := el.Attr("class")
+	classes := strings.Fields(class)
+	sort.Strings(classes)
+	for _, c := range classes {
+		sig += "." + c
+	}
+	return sig
+}
+
+// imageCluster returns the selector shared by images in the same kind of
+// container: the signatures of two ancestors and of the img itself.
+func imageCluster(img *goquery.Selection) string {
+	parts := []string{elementSignature(img)}
+	for parent := img.Parent(); len(parts) < 3 && parent.Length() != 0; parent = parent.Parent() {
+		name := goquery.NodeName(parent)
+		if name == "body" || name == "html" {
+			break
+		}
+		parts = append([]string{elementSignature(parent)}, parts...)
+	}
+	return strings.Join(parts, " > ")
+}
+
+func dimension(img *goquery.Selection, name string) int {
+	v, _ := img.Attr(name)
+	n, _ := strconv.Atoi(strings.TrimSuffix(v, "px"))
+	return n
+}
+
+// imageScore guesses how likely img is a gallery image rather than a logo,
+// icon or ad.
+func imageScore(img *goquery.Selection, src string) float64 {
+	score := 1.0
+
+	width, height := dimension(img, "width"), dimension(img, "height")
+	switch {
+	case 300 <= width && 300 <= height:
+		score += 2
+	case (0 < width && width < 100) || (0 < height && height < 100):
+		score -= 2
+	}
+
+	if srcset, ok := img.Attr("srcset"); ok {
+		for _, m := range srcsetWidth.FindAllStringSubmatch(srcset, -1) {
+			if w, _ := strconv.Atoi(m[1]); 600 <= w {
+				score += 2
+				break
+			}
+		}
+	}
+
+	if decorationPattern.MatchString(src) {
+		score -= 3
+	}
+	if sequencePattern.MatchString(src) {
+		score++
+	}
+	if strings.HasSuffix(strings.ToLower(src), ".gif") || strings.HasSuffix(strings.ToLower(src), ".svg") {
+		score -= 0.5
+	}
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+type cluster struct {
+	Selector string
+	Count    int
+	Score    float64
+}
+
+// detectImageSelector picks the dominant cluster of similar images in doc
+// and returns a selector matching it.
+func (s *Scraper) detectImageSelector(doc *goquery.Document) (string, error) {
+	clusters := make(map[string]*cluster)
+	total := 0.0
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		src, _ := img.Attr("src")
+		if src == "" {
+			return
+		}
+		key := imageCluster(img)
+		c, ok := clusters[key]
+		if !ok {
+			c = &cluster{Selector: key}
+			clusters[key] = c
+		}
+		c.Count++
+		c.Score += imageScore(img, src)
+	})
+
+	var best *cluster
+	for _, c := range clusters {
+		// Galleries repeat the same container, so reward repetition.
+		if 3 <= c.Count {
+			c.Score *= 1.5
+		}
+		total += c.Score
+		if best == nil || best.Score < c.Score || (best.Score == c.Score && best.Selector < c.Selector) {
+			best = c
+		}
+	}
+	if best == nil || total == 0 {
+		return "", errors.New("No images found to detect a gallery from; set image_selector")
+	}
+
+	threshold := s.Config.AutoConfidence
+	if threshold <= 0 {
+		threshold = defaultAutoConfidence
+	}
+	confidence := best.Score / total
+	if confidence < threshold {
+		return "", fmt.Errorf(
+			"Could not detect the gallery: best guess %q matches %d images with confidence %.2f (below %.2f); set image_selector",
+			best.Selector, best.Count, confidence, threshold)
+	}
+	log.Printf("Detected image_selector = %q (%d images, confidence %.2f)", best.Selector, best.Count, confidence)
+	return best.Selector, nil
+}
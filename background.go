@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"github.com/PuerkitoBio/goquery"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	cssComment     = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssRulePattern = regexp.MustCompile(`([^{}]+)\{([^{}]*)\}`)
+	// backgroundPattern finds the value of background and background-image
+	// declarations; urlPattern then finds each url() within it.
+	backgroundPattern = regexp.MustCompile(`(?i)background(?:-image)?\s*:([^;]*)`)
+	urlPattern        = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)`)
+)
+
+// backgroundUrls returns the url() values of the background declarations
+// in a style attribute or rule body.
+func backgroundUrls(style string) []string {
+	var urls []string
+	for _, decl := range backgroundPattern.FindAllStringSubmatch(style, -1) {
+		for _, m := range urlPattern.FindAllStringSubmatch(decl[1], -1) {
+			u := m[1] + m[2] + m[3]
+			if u != "" {
+				urls = append(urls, u)
+			}
+		}
+	}
+	return urls
+}
+
+type cssRule struct {
+	Selector string
+	Urls     []string
+}
+
+// parseStylesheet returns the rules of css that set a background image,
+// with their URLs resolved against base.
+func parseStylesheet(css string, base *url.URL) []cssRule {
+	css = cssComment.ReplaceAllString(css, "")
+	var rules []cssRule
+	for _, m := range cssRulePattern.FindAllStringSubmatch(css, -1) {
+		selector := strings.TrimSpace(m[1])
+		if selector == "" || strings.HasPrefix(selector, "@") {
+			continue
+		}
+		urls := resolveSrcs(base, backgroundUrls(m[2]))
+		if 0 < len(urls) {
+			rules = append(rules, cssRule{Selector: selector, Urls: urls})
+		}
+	}
+	return rules
+}
+
+func fetchStylesheet(ctx context.Context, client *http.Client, href string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", href, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	return string(body), err
+}
+
+// stylesheetRules collects background rules from the <style> elements and
+// linked stylesheets of doc.
+func (s *Scraper) stylesheetRules(ctx context.Context, client *http.Client, doc *goquery.Document) []cssRule {
+	var rules []cssRule
+	doc.Find("style").Each(func(i int, el *goquery.Selection) {
+		rules = append(rules, parseStylesheet(el.Text(), doc.Url)...)
+	})
+	doc.Find(`link[rel~="stylesheet"][href]`).Each(func(i int, el *goquery.Selection) {
+		href, _ := el.Attr("href")
+		hrefs := resolveSrcs(doc.Url, []string{href})
+		if len(hrefs) == 0 {
+			return
+		}
+		if allowlist := allowlistOf(ctx); !allowlist.allows(hrefs[0]) {
+			allowlist.skip(ctx, "stylesheet", hrefs[0])
+			return
+		}
+		css, err := fetchStylesheet(ctx, client, hrefs[0])
+		if err != nil {
+			log.Println("WARNING: stylesheet", hrefs[0], err)
+			return
+		}
+		base, _ := url.Parse(hrefs[0])
+		rules = append(rules, parseStylesheet(css, base)...)
+	})
+	return rules
+}
+
+// backgroundSrcs is imageSrcs for galleries drawn with CSS backgrounds:
+// elements without a src contribute their inline background images, or
+// failing that those of the stylesheet rules they match.
+func (s *Scraper) backgroundSrcs(ctx context.Context, client *http.Client, doc *goquery.Document, selector string) []string {
+	var srcs []string
+	var rules []cssRule
+	loaded := false
+
+	find(doc, selector).Each(func(i int, el *goquery.Selection) {
+		if src, _ := el.Attr("src"); src != "" {
+			srcs = append(srcs, src)
+			return
+		}
+		style, _ := el.Attr("style")
+		if urls := backgroundUrls(style); 0 < len(urls) {
+			srcs = append(srcs, urls...)
+			return
+		}
+		if !loaded {
+			rules = s.stylesheetRules(ctx, client, doc)
+			loaded = true
+		}
+		// Later rules win, as in the cascade.
+		for j := len(rules) - 1; 0 <= j; j-- {
+			if el.Is(rules[j].Selector) {
+				srcs = append(srcs, rules[j].Urls...)
+				return
+			}
+		}
+	})
+	return srcs
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func readUrlFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// batch scrapes every URL of urlFile with the page whose host_pattern
+// matches it, giving up on the rest once deadline has passed.
+func batch(scraper *Scraper, urlFile string, deadline time.Duration) error {
+	urls, err := readUrlFile(urlFile)
+	if err != nil {
+		return usageError(err)
+	}
+	urls = scraper.Config.dedupeUrls(urls)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if 0 < deadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	var summary cycleSummary
+	for i, url := range urls {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Println("Deadline of", deadline, "exceeded,", len(urls)-i, "pages not scraped")
+			summary.Failed += len(urls) - i
+			summary.TimedOut += len(urls) - i
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		page, err := scraper.Config.MatchPage(url)
+		if err != nil {
+			log.Println("Failed", url, err)
+			summary.Failed++
+			scraper.Report.Add(&Result{Url: url}, err)
+			continue
+		}
+		log.Println("→", url, "as", page.label())
+		result, err := scraper.scrape(ctx, page, url, nil)
+		if err != nil {
+			log.Println("Failed", url, err)
+		}
+		summary.Add(result, err)
+	}
+	if 0 < scraper.Sample {
+		log.Println("Sample run done, at most", scraper.Sample, "images per page:", summary.String())
+	} else {
+		log.Println("Done:", summary.String())
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+	return summary.Err()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bench scrapes a gallery of synthetic images from an in-process server
+// and reports throughput and memory use, as a guard against performance
+// regressions. It is left out of the usage on purpose.
+func bench(args []string) error {
+	flags := newFlagSet("bench")
+	images := flags.Int("images", 1000, "images in the fixture gallery")
+	sizeFlag := flags.String("size", "500KB", "size of each image")
+	pages := flags.Int("pages", 1, "times to scrape the gallery")
+	verbose := flags.Bool("verbose", false, "keep the log of the scrapes")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	var size Size
+	if err := size.UnmarshalText([]byte(*sizeFlag)); err != nil {
+		return usageError(err)
+	}
+	if *images < 1 || size.Bytes < 4 || *pages < 1 {
+		return usageError(errors.New("--images, --size and --pages must be positive"))
+	}
+
+	server := httptest.NewServer(fixtureHandler(*images, size.Bytes))
+	defer server.Close()
+
+	dir, err := os.MkdirTemp("", "scrape-go-bench")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+
+	config := &Config{Pages: []Page{{
+		Name:          "bench",
+		HostPattern:   "127.0.0.1",
+		TitleSelector: "h1",
+		ImageSelector: "img",
+	}}}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	scraper := &Scraper{Config: config, Unattended: true}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var saved int64
+	for i := 0; i < *pages; i++ {
+		result, err := scraper.scrape(context.Background(), &config.Pages[0], server.URL+"/gallery", nil)
+		if err != nil {
+			return err
+		}
+		saved += result.Bytes
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	downloaded := int64(*images) * int64(*pages)
+	seconds := elapsed.Seconds()
+	fmt.Println("images:    ", downloaded, "of", formatBytes(size.Bytes))
+	fmt.Println("elapsed:   ", elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput: %.1f images/s, %s/s\n", float64(downloaded)/seconds, formatBytes(int64(float64(saved)/seconds)))
+	fmt.Println("peak RSS:  ", peakRss())
+	fmt.Println("allocated: ", formatBytes(int64(after.TotalAlloc-before.TotalAlloc)), "in", after.Mallocs-before.Mallocs, "allocations")
+	fmt.Println("heap:      ", formatBytes(int64(after.HeapSys)), "obtained from the OS")
+	fmt.Println("GC cycles: ", after.NumGC-before.NumGC)
+	return nil
+}
+
+// fixtureHandler serves /gallery, a page of n images, and the images,
+// each size bytes of incompressible data behind a JPEG signature.
+func fixtureHandler(n int, size int64) http.Handler {
+	image := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(image)
+	copy(image, "\xff\xd8\xff\xe0")
+
+	var page strings.Builder
+	page.WriteString("<html><body><h1>Bench</h1>")
+	for i := 0; i < n; i++ {
+		page.WriteString(`<img src="/img/` + strconv.Itoa(i) + `.jpg">`)
+	}
+	page.WriteString("</body></html>")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gallery", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page.String())
+	})
+	mux.HandleFunc("/img/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.Write(image)
+	})
+	return mux
+}
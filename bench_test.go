@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	benchImages = 100
+	benchSize   = 500 << 10
+)
+
+// quiet discards the log for the rest of tb.
+func quiet(tb testing.TB) {
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// inTempDir runs the rest of tb in a directory of its own, for the
+// downloads/ the scrapes write.
+func inTempDir(tb testing.TB) {
+	wd, err := os.Getwd()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chdir(tb.TempDir()); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { os.Chdir(wd) })
+}
+
+func benchConfig(b *testing.B) *Config {
+	config := &Config{Pages: []Page{{
+		Name:          "bench",
+		HostPattern:   "127.0.0.1",
+		TitleSelector: "h1",
+		ImageSelector: "img",
+	}}}
+	if err := config.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	return config
+}
+
+func BenchmarkCreateZip(b *testing.B) {
+	image := make([]byte, benchSize)
+	copy(image, "\xff\xd8\xff\xe0")
+	images := make([]*Image, benchImages)
+	for i := range images {
+		images[i] = &Image{Name: strconv.Itoa(i) + "-" + strconv.Itoa(i) + ".jpg", Bytes: newBody(image), Index: i}
+	}
+	scraped := time.Now()
+	b.SetBytes(benchImages * benchSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zip, err := createZip(context.Background(), images, scraped, ArchiveOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		zip.Close()
+	}
+}
+
+func BenchmarkDownloadImages(b *testing.B) {
+	quiet(b)
+	server := httptest.NewServer(fixtureHandler(benchImages, benchSize))
+	defer server.Close()
+	config := benchConfig(b)
+	scraper := &Scraper{Config: config, Unattended: true}
+	srcs := make([]string, benchImages)
+	for i := range srcs {
+		srcs[i] = server.URL + "/img/" + strconv.Itoa(i) + ".jpg"
+	}
+	b.SetBytes(benchImages * benchSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		images, errs := scraper.downloadImages(context.Background(), &config.Pages[0], server.Client(), srcs)
+		if len(errs) != 0 {
+			b.Fatal(errs[0])
+		}
+		closeImages(images)
+	}
+}
+
+func BenchmarkScrape(b *testing.B) {
+	quiet(b)
+	inTempDir(b)
+	server := httptest.NewServer(fixtureHandler(benchImages, benchSize))
+	defer server.Close()
+	config := benchConfig(b)
+	scraper := &Scraper{Config: config, Unattended: true}
+	b.SetBytes(benchImages * benchSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := scraper.scrape(context.Background(), &config.Pages[0], server.URL+"/gallery", nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.Images != benchImages {
+			b.Fatalf("%d images, want %d", result.Images, benchImages)
+		}
+	}
+}
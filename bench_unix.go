@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakRss is the peak resident set size of the process.
+func peakRss() string {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return err.Error()
+	}
+	// Linux reports kilobytes, macOS bytes.
+	rss := int64(usage.Maxrss)
+	if runtime.GOOS != "darwin" {
+		rss <<= 10
+	}
+	return formatBytes(rss)
+}
@@ -0,0 +1,7 @@
+package main
+
+// peakRss is the peak resident set size of the process, which Windows
+// does not report through syscall.
+func peakRss() string {
+	return "unavailable"
+}
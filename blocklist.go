@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// adHosts is the preset enabled by block_ad_hosts: ad networks, trackers
+// and avatar services that generic selectors tend to sweep in.
+var adHosts = []string{
+	"*.doubleclick.net",
+	"pagead2.*",
+	"*.googlesyndication.com",
+	"*.googleadservices.com",
+	"*.google-analytics.com",
+	"*.adnxs.com",
+	"*.amazon-adsystem.com",
+	"*.criteo.com",
+	"*.criteo.net",
+	"*.taboola.com",
+	"*.outbrain.com",
+	"*.scorecardresearch.com",
+	"*.moatads.com",
+	"pixel.*",
+	"gravatar.com",
+	"*.gravatar.com",
+}
+
+// Blocklist drops image srcs whose host matches one of BlockedHosts, glob
+// patterns as in host_pattern, or of the adHosts preset when BlockAdHosts
+// is set.
+//
+// AllowedHosts, when set, are the only hosts a scrape fetches images,
+// frames, stylesheets and redirects from; the rest are skipped with a
+// warning. AllowSameSite allows the site of the page URL as well, its
+// host and every other subdomain of its registrable domain, and alone
+// allows that site only.
+type Blocklist struct {
+	BlockedHosts  []string `toml:"blocked_hosts"`
+	BlockAdHosts  bool     `toml:"block_ad_hosts"`
+	AllowedHosts  []string `toml:"allowed_hosts"`
+	AllowSameSite bool     `toml:"allow_same_site"`
+}
+
+func (b Blocklist) validate() error {
+	for _, pattern := range b.BlockedHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("blocked_hosts: %q: %v", pattern, err)
+		}
+	}
+	for _, pattern := range b.AllowedHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("allowed_hosts: %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// blockedHosts are the patterns applying to page, its own added to the
+// global ones.
+func (s *Scraper) blockedHosts(page *Page) []string {
+	if s.NoBlocklist {
+		return nil
+	}
+	patterns := append(s.Config.BlockedHosts[:len(s.Config.BlockedHosts):len(s.Config.BlockedHosts)], page.BlockedHosts...)
+	if s.Config.BlockAdHosts || page.BlockAdHosts {
+		patterns = append(patterns, adHosts...)
+	}
+	return patterns
+}
+
+// blockSrcs drops the srcs of page on a blocked host and counts them in
+// result.
+func (s *Scraper) blockSrcs(ctx context.Context, page *Page, srcs []string, result *Result) []string {
+	patterns := s.blockedHosts(page)
+	allowlist := allowlistOf(ctx)
+	if len(patterns) == 0 && allowlist == nil {
+		return srcs
+	}
+	kept := srcs[:0:0]
+	for _, src := range srcs {
+		if matchHost(src, patterns) {
+			debugln("Blocked", src)
+			result.Blocked++
+			continue
+		}
+		if !allowlist.allows(src) {
+			allowlist.skip(ctx, "image", src)
+			continue
+		}
+		kept = append(kept, src)
+	}
+	if 0 < result.Blocked {
+		logln(ctx, "Blocked", result.Blocked, "images")
+	}
+	return kept
+}
+
+// matchHost reports whether the host of src matches one of patterns.
+func matchHost(src string, patterns []string) bool {
+	u, err := url.Parse(src)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAllowlist holds the hosts the scrape of a page may fetch from, and
+// counts the URLs it turned away.
+type hostAllowlist struct {
+	patterns []string
+	// site is the registrable domain of the page with allow_same_site.
+	site    string
+	skipped int64
+}
+
+// hostAllowlist is the allowlist of a scrape of rawurl with page, nil
+// when any host will do.
+func (s *Scraper) hostAllowlist(page *Page, rawurl string) *hostAllowlist {
+	patterns := append(s.Config.AllowedHosts[:len(s.Config.AllowedHosts):len(s.Config.AllowedHosts)], page.AllowedHosts...)
+	sameSite := s.Config.AllowSameSite || page.AllowSameSite
+	if len(patterns) == 0 && !sameSite {
+		return nil
+	}
+	a := &hostAllowlist{patterns: patterns}
+	if u, err := url.Parse(rawurl); err == nil && sameSite {
+		a.site = site(u.Hostname())
+	}
+	return a
+}
+
+// site is the registrable domain of host, such as example.co.uk for
+// cdn.example.co.uk, or host itself for IP addresses and the like.
+func site(host string) string {
+	host = strings.ToLower(host)
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
+
+// allows reports whether rawurl may be fetched. URLs without a host, such
+// as data URIs, always may.
+func (a *hostAllowlist) allows(rawurl string) bool {
+	if a == nil {
+		return true
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return true
+	}
+	if a.site != "" && site(u.Hostname()) == a.site {
+		return true
+	}
+	return matchHost(rawurl, a.patterns)
+}
+
+// skip warns about the off-site rawurl, a what, and counts it.
+func (a *hostAllowlist) skip(ctx context.Context, what, rawurl string) {
+	atomic.AddInt64(&a.skipped, 1)
+	logln(ctx, "WARNING: off-site", what, displaySrc(rawurl), "skipped, its host is not in allowed_hosts")
+}
+
+// Skipped is the number of URLs turned away so far.
+func (a *hostAllowlist) Skipped() int {
+	if a == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&a.skipped))
+}
+
+type allowlistKey struct{}
+
+func withAllowlist(ctx context.Context, a *hostAllowlist) context.Context {
+	return context.WithValue(ctx, allowlistKey{}, a)
+}
+
+// allowlistOf is the allowlist of the scrape of ctx, nil when none.
+func allowlistOf(ctx context.Context) *hostAllowlist {
+	a, _ := ctx.Value(allowlistKey{}).(*hostAllowlist)
+	return a
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultRangeMaxMisses = 5
+	// maxBraceUrls bounds the URLs the ranges of a URL expand to, and
+	// how far an open range is probed.
+	maxBraceUrls = 10000
+)
+
+// bracePattern is a range such as {001..120}, or {1..} without an end.
+var bracePattern = regexp.MustCompile(`\{(\d+)\.\.(\d*)\}`)
+
+// braceRange is one {start..end} of a URL. End is -1 for open ranges.
+type braceRange struct {
+	Start int
+	End   int
+	Width int
+}
+
+func (r braceRange) format(n int) string {
+	s := strconv.Itoa(n)
+	if len(s) < r.Width {
+		s = strings.Repeat("0", r.Width-len(s)) + s
+	}
+	return s
+}
+
+// braceUrl is a URL with ranges, whose parts surround them.
+type braceUrl struct {
+	parts  []string
+	ranges []braceRange
+}
+
+// parseBraces returns the ranges of rawurl, or nil for a plain URL.
+// Numbers are zero-padded to the width of a literal with leading zeros.
+// Ranges expanding to more than maxBraceUrls URLs are rejected.
+func parseBraces(rawurl string) (*braceUrl, error) {
+	matches := bracePattern.FindAllStringSubmatchIndex(rawurl, -1)
+	if matches == nil {
+		return nil, nil
+	}
+	b := &braceUrl{}
+	last := 0
+	total := 1
+	for _, m := range matches {
+		start, end := rawurl[m[2]:m[3]], rawurl[m[4]:m[5]]
+		r := braceRange{End: -1}
+		var err error
+		if r.Start, err = strconv.Atoi(start); err != nil {
+			return nil, errors.New("Range {" + start + ".." + end + "} is out of range")
+		}
+		if end != "" {
+			if r.End, err = strconv.Atoi(end); err != nil {
+				return nil, errors.New("Range {" + start + ".." + end + "} is out of range")
+			}
+			if r.End < r.Start {
+				return nil, errors.New("Range {" + start + ".." + end + "} ends before it starts")
+			}
+			if maxBraceUrls/total <= r.End-r.Start {
+				return nil, errors.New("Ranges expand to more than " + strconv.Itoa(maxBraceUrls) + " URLs")
+			}
+			total *= r.End - r.Start + 1
+		} else if 1 < len(matches) {
+			return nil, errors.New("An open range {" + start + "..} must be the only range of the URL")
+		}
+		if (1 < len(start) && start[0] == '0') || (1 < len(end) && end[0] == '0') {
+			r.Width = len(start)
+			if r.Width < len(end) {
+				r.Width = len(end)
+			}
+		}
+		b.parts = append(b.parts, rawurl[last:m[0]])
+		b.ranges = append(b.ranges, r)
+		last = m[1]
+	}
+	b.parts = append(b.parts, rawurl[last:])
+	return b, nil
+}
+
+func (b *braceUrl) open() bool {
+	return len(b.ranges) == 1 && b.ranges[0].End < 0
+}
+
+// url fills in the ranges with ns.
+func (b *braceUrl) url(ns []int) string {
+	var s strings.Builder
+	for i, r := range b.ranges {
+		s.WriteString(b.parts[i])
+		s.WriteString(r.format(ns[i]))
+	}
+	s.WriteString(b.parts[len(b.parts)-1])
+	return s.String()
+}
+
+// expand lists every URL of closed ranges, the last range varying fastest.
+func (b *braceUrl) expand() []string {
+	var urls []string
+	ns := make([]int, len(b.ranges))
+	var walk func(i int)
+	walk = func(i int) {
+		if i == len(b.ranges) {
+			urls = append(urls, b.url(ns))
+			return
+		}
+		for n := b.ranges[i].Start; n <= b.ranges[i].End; n++ {
+			ns[i] = n
+			walk(i + 1)
+		}
+	}
+	walk(0)
+	return urls
+}
+
+// title names the archive of a range URL after the directory holding the
+// ranges.
+func (b *braceUrl) title() string {
+	prefix := b.parts[0]
+	if i := strings.LastIndexByte(prefix, '/'); 0 <= i {
+		prefix = prefix[:i+1]
+	}
+	return urlTitle(prefix)
+}
+
+// braceSrcs lists the images of b. An open range is probed with HEAD
+// requests until RangeMaxMisses (default 5) URLs in a row are missing,
+// or maxBraceUrls URLs are.
+func (s *Scraper) braceSrcs(ctx context.Context, page *Page, client *http.Client, b *braceUrl) ([]string, error) {
+	if !b.open() {
+		return b.expand(), nil
+	}
+	limit := page.RangeMaxMisses
+	if limit <= 0 {
+		limit = defaultRangeMaxMisses
+	}
+	var srcs []string
+	misses := 0
+	start := b.ranges[0].Start
+	for n := start; misses < limit; n++ {
+		if n-start == maxBraceUrls {
+			logln(ctx, "WARNING: stopped probing", b.url([]int{start})+"... after", maxBraceUrls, "URLs")
+			break
+		}
+		src := b.url([]int{n})
+		found, err := probe(ctx, client, src)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			misses++
+			continue
+		}
+		// Gaps shorter than the limit are kept and fail or skip as usual.
+		for i := misses; 0 < i; i-- {
+			srcs = append(srcs, b.url([]int{n - i}))
+		}
+		misses = 0
+		srcs = append(srcs, src)
+	}
+	logln(ctx, "Found", len(srcs), "images in", b.url([]int{start})+"...")
+	return srcs, nil
+}
+
+// probe reports whether src exists. Servers rejecting HEAD are taken at
+// their word that it does.
+func probe(ctx context.Context, client *http.Client, src string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", src, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	debugln(res.Proto, res.Status, src)
+	return res.StatusCode != http.StatusNotFound && res.StatusCode != http.StatusGone, nil
+}
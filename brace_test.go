@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseBracesLimit(t *testing.T) {
+	for _, test := range []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/{1..10000}.jpg", true},
+		{"https://example.com/{1..10001}.jpg", false},
+		{"https://example.com/{1..100}/{1..100}.jpg", true},
+		{"https://example.com/{1..100}/{1..101}.jpg", false},
+		{"https://example.com/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}/{1..2}.jpg", false},
+		{"https://example.com/{0..9223372036854775807}.jpg", false},
+		{"https://example.com/{1..99999999999999999999}.jpg", false},
+	} {
+		b, err := parseBraces(test.url)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.url, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: expands to %d URLs", test.url, len(b.expand()))
+		}
+	}
+}
+
+// An open range stops at maxBraceUrls on a server that has every number.
+func TestBraceSrcsOpenLimit(t *testing.T) {
+	quiet(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	b, err := parseBraces(server.URL + "/{1..}.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scraper := &Scraper{Config: &Config{}}
+	srcs, err := scraper.braceSrcs(context.Background(), &Page{}, server.Client(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srcs) != maxBraceUrls {
+		t.Errorf("%d images, want %d", len(srcs), maxBraceUrls)
+	}
+	if last := srcs[len(srcs)-1]; !strings.HasSuffix(last, "/10000.jpg") {
+		t.Errorf("last image %s", last)
+	}
+}
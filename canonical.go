@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"github.com/PuerkitoBio/goquery"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// defaultStripParams are the tracking parameters strip_params removes
+// when not set.
+var defaultStripParams = []string{"utm_*", "fbclid", "gclid", "mc_cid", "mc_eid"}
+
+func (c *Config) stripParams() []string {
+	if c.StripParams != nil {
+		return c.StripParams
+	}
+	return defaultStripParams
+}
+
+func stripped(patterns []string, param string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, param); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanUrl is raw without its fragment and the query parameters matching
+// strip_params. It is what gets fetched.
+func (c *Config) cleanUrl(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	// The query is only rewritten when something goes, as some sites
+	// mind how it is encoded.
+	query := u.Query()
+	dropped := false
+	for param := range query {
+		if stripped(c.stripParams(), param) {
+			query.Del(param)
+			dropped = true
+		}
+	}
+	if dropped {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// urlKey is the canonical form of raw that tells two URLs of the same
+// gallery apart from two galleries: cleanUrl, over https, with the host
+// in lower case, without www. or a default port, and with the query
+// sorted. It keys deduplication and the state kept across runs.
+func (c *Config) urlKey(raw string) string {
+	u, err := url.Parse(c.cleanUrl(raw))
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	u.Host = host
+	u.RawQuery = u.Query().Encode()
+	return canonicalUrl(u)
+}
+
+// dedupeUrls drops the URLs with the key of an earlier one, logging
+// which were collapsed into which, and cleans the rest.
+func (c *Config) dedupeUrls(urls []string) []string {
+	first := make(map[string]string)
+	var unique []string
+	for _, raw := range urls {
+		key := c.urlKey(raw)
+		if kept, ok := first[key]; ok {
+			log.Println("Duplicate", raw, "of", kept+", skipped")
+			continue
+		}
+		first[key] = raw
+		unique = append(unique, c.cleanUrl(raw))
+	}
+	return unique
+}
+
+// CanonicalLink makes the <link rel="canonical"> of a document the
+// identity of its page: the URL its state is kept under, its archive is
+// looked up by, and its manifest records, while images still resolve
+// against the URL fetched. Only canonicals on the page's own host or on
+// one of CanonicalHosts count. IgnoreCanonical turns this off for sites
+// whose canonicals are wrong.
+type CanonicalLink struct {
+	IgnoreCanonical bool     `toml:"ignore_canonical"`
+	CanonicalHosts  []string `toml:"canonical_hosts"`
+}
+
+// canonicalLink returns the href of the first <link rel="canonical"> in
+// doc, resolved against the document URL, or nil when there is none.
+func canonicalLink(doc *goquery.Document) *url.URL {
+	var target *url.URL
+	doc.Find("link[rel][href]").EachWithBreak(func(i int, el *goquery.Selection) bool {
+		rel, _ := el.Attr("rel")
+		canonical := false
+		for _, r := range strings.Fields(rel) {
+			canonical = canonical || strings.EqualFold(r, "canonical")
+		}
+		href, _ := el.Attr("href")
+		href = strings.TrimSpace(href)
+		if !canonical || href == "" {
+			return true
+		}
+		u, err := doc.Url.Parse(href)
+		if err != nil {
+			return true
+		}
+		target = u
+		return false
+	})
+	return target
+}
+
+func (s *Scraper) allowCanonical(page *Page, from *url.URL, to *url.URL) bool {
+	if to.Scheme != "http" && to.Scheme != "https" {
+		return false
+	}
+	trim := func(host string) string { return strings.TrimPrefix(strings.ToLower(host), "www.") }
+	if trim(from.Hostname()) == trim(to.Hostname()) {
+		return true
+	}
+	hosts := page.CanonicalHosts
+	if len(hosts) == 0 {
+		hosts = s.Config.CanonicalHosts
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(host, to.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonical is the canonical URL doc of page declares, cleaned, or ""
+// when it has none, names url itself, or does not count.
+func (s *Scraper) canonical(ctx context.Context, page *Page, url string, doc *goquery.Document) string {
+	if doc == nil || s.Config.IgnoreCanonical || page.IgnoreCanonical {
+		return ""
+	}
+	target := canonicalLink(doc)
+	if target == nil {
+		return ""
+	}
+	if !s.allowCanonical(page, doc.Url, target) {
+		logln(ctx, "Ignore canonical", target, "of", url)
+		return ""
+	}
+	canonical := s.Config.cleanUrl(target.String())
+	if s.Config.urlKey(canonical) == s.Config.urlKey(url) {
+		return ""
+	}
+	logln(ctx, "Canonical of", url, "is", canonical)
+	return canonical
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cassette records every HTTP exchange of a run into the file Record, or
+// answers every request of a run from the exchanges in the file Replay.
+type Cassette struct {
+	Record string
+	Replay string
+
+	mu           sync.Mutex
+	interactions []interaction
+	// replays holds the unplayed interactions by request; the last of
+	// each is played again once the others are used up.
+	replays map[string][]interaction
+}
+
+// interaction is one recorded exchange. Body is stored as base64.
+type interaction struct {
+	Method  string      `json:"method"`
+	Url     string      `json:"url"`
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    []byte      `json:"body"`
+}
+
+func interactionKey(method, url string) string {
+	return method + " " + url
+}
+
+// cassetteFlags adds --record and --replay to flags.
+func cassetteFlags(flags *flag.FlagSet) *Cassette {
+	c := &Cassette{}
+	flags.StringVar(&c.Record, "record", "", "save every HTTP request and response of the run to this cassette file")
+	flags.StringVar(&c.Replay, "replay", "", "answer every HTTP request from this cassette file, failing on unrecorded ones")
+	return c
+}
+
+// load reads the cassette to replay, if any.
+func (c *Cassette) load() error {
+	if c == nil || c.Replay == "" {
+		return nil
+	}
+	if c.Record != "" {
+		return errors.New("--record and --replay exclude each other")
+	}
+	data, err := os.ReadFile(c.Replay)
+	if err != nil {
+		return err
+	}
+	var interactions []interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return errors.New("cassette " + c.Replay + ": " + err.Error())
+	}
+	c.replays = make(map[string][]interaction)
+	for _, i := range interactions {
+		key := interactionKey(i.Method, i.Url)
+		c.replays[key] = append(c.replays[key], i)
+	}
+	return nil
+}
+
+// Save writes the recorded cassette, if recording.
+func (c *Cassette) Save() error {
+	if c == nil || c.Record == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Record), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.Record, data, 0644)
+}
+
+func (s *Scraper) cassetteTransport(base http.RoundTripper) http.RoundTripper {
+	c := s.Cassette
+	switch {
+	case c == nil:
+		return base
+	case c.replays != nil:
+		return replayTransport{c}
+	case c.Record != "":
+		return &recordTransport{base: base, cassette: c}
+	}
+	return base
+}
+
+type recordTransport struct {
+	base     http.RoundTripper
+	cassette *Cassette
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	c := t.cassette
+	c.mu.Lock()
+	c.interactions = append(c.interactions, interaction{
+		Method:  req.Method,
+		Url:     req.URL.String(),
+		Status:  res.StatusCode,
+		Headers: res.Header.Clone(),
+		Body:    body,
+	})
+	c.mu.Unlock()
+	return res, nil
+}
+
+type replayTransport struct {
+	cassette *Cassette
+}
+
+func (t replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.cassette
+	key := interactionKey(req.Method, req.URL.String())
+	c.mu.Lock()
+	queue := c.replays[key]
+	if len(queue) == 0 {
+		c.mu.Unlock()
+		return nil, errors.New("Not in cassette " + c.Replay + ": " + key)
+	}
+	i := queue[0]
+	if 1 < len(queue) {
+		c.replays[key] = queue[1:]
+	}
+	c.mu.Unlock()
+	return &http.Response{
+		Status:        http.StatusText(i.Status),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        i.Headers.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(i.Body)),
+		ContentLength: int64(len(i.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// replayScraper scrapes from the cassette testdata/cassettes/name.json in
+// a directory of its own, with the config of the replayed site.
+func replayScraper(t *testing.T, name string) (*Scraper, *Page) {
+	t.Helper()
+	cassette := &Cassette{Replay: filepath.Join("testdata", "cassettes", name+".json")}
+	cassette.Replay, _ = filepath.Abs(cassette.Replay)
+	if err := cassette.load(); err != nil {
+		t.Fatal(err)
+	}
+	quiet(t)
+	inTempDir(t)
+	config := &Config{Pages: []Page{{
+		Name:          "gallery",
+		HostPattern:   "gallery.example",
+		TitleSelector: "h1.title",
+		ImageSelector: "div.gallery img",
+		MergeSelector: "a.part",
+	}}}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return &Scraper{Config: config, Cassette: cassette, Unattended: true}, &config.Pages[0]
+}
+
+func TestReplayGallery(t *testing.T) {
+	scraper, page := replayScraper(t, "gallery")
+	result, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Title != "Harbour_at_Dawn" || result.Images != 3 || result.Failed != 0 {
+		t.Fatalf("result = %q, %d images, %d failed", result.Title, result.Images, result.Failed)
+	}
+
+	entries, m, err := readArchive(context.Background(), result.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := "0-1.jpg 1-2.jpg 2-3.jpg"; strings.Join(names, " ") != want {
+		t.Errorf("entries = %v, want %s", names, want)
+	}
+	if m == nil || m.Url != "https://gallery.example/g/42" || len(m.Images) != 3 {
+		t.Fatalf("manifest = %+v", m)
+	}
+	if part := m.Images[2].Part; part != "https://gallery.example/g/42?p=2" {
+		t.Errorf("part of the third image = %q", part)
+	}
+}
+
+func TestReplayMissingPage(t *testing.T) {
+	scraper, page := replayScraper(t, "errors")
+	_, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/gone", nil)
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != 404 {
+		t.Fatalf("err = %v, want a 404", err)
+	}
+}
+
+func TestReplayFailedImages(t *testing.T) {
+	scraper, page := replayScraper(t, "errors")
+	result, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Images != 1 || result.Failed != 2 {
+		t.Fatalf("%d images, %d failed, want 1 and 2", result.Images, result.Failed)
+	}
+	errs := strings.Join(result.Errors, "\n")
+	if !strings.Contains(errs, "Not Found https://gallery.example/i/7/2.jpg") {
+		t.Errorf("errors do not mention the missing image:\n%s", errs)
+	}
+	if !strings.Contains(errs, "Not in cassette") {
+		t.Errorf("errors do not mention the unrecorded image:\n%s", errs)
+	}
+}
+
+func TestReplayUnrecordedPage(t *testing.T) {
+	scraper, page := replayScraper(t, "gallery")
+	_, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/43", nil)
+	if err == nil || !strings.Contains(err.Error(), "Not in cassette") {
+		t.Fatalf("err = %v, want an unrecorded request", err)
+	}
+}
+
+// A page saved with failed images is not unchanged the next time.
+func TestReplayConditionalFailedImages(t *testing.T) {
+	gallery := &Cassette{Replay: filepath.Join("testdata", "cassettes", "gallery.json")}
+	gallery.Replay, _ = filepath.Abs(gallery.Replay)
+	scraper, page := replayScraper(t, "errors")
+	scraper.Conditional = true
+	result, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed == 0 {
+		t.Fatal("no image failed")
+	}
+	if _, err := os.Stat(pageState); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("validators saved with %d images failed: %v", result.Failed, err)
+	}
+
+	if err := gallery.load(); err != nil {
+		t.Fatal(err)
+	}
+	scraper.Cassette = gallery
+	if _, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/42", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pageState); err != nil {
+		t.Errorf("validators not saved with every image: %v", err)
+	}
+}
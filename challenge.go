@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"strings"
+)
+
+// ChallengeError is a page an anti-bot gate answered in place of the site.
+type ChallengeError struct {
+	Url    string
+	Vendor string
+}
+
+func (e *ChallengeError) Error() string {
+	return "Blocked by anti-bot challenge (" + e.Vendor + ") at " + e.Url +
+		"; import the cookies of a browser that passed it with cookie_file"
+}
+
+// cloudflareMarkers are found in the markup of Cloudflare challenges.
+var cloudflareMarkers = [][]byte{
+	[]byte("Just a moment..."),
+	[]byte("Just a moment…"),
+	[]byte("/cdn-cgi/challenge-platform/"),
+	[]byte("cf_chl_opt"),
+	[]byte("cf-browser-verification"),
+}
+
+// responseChallenge names the vendor of the challenge res with body is, or
+// returns "" for an ordinary response.
+func responseChallenge(res *http.Response, body []byte) string {
+	server := strings.ToLower(res.Header.Get("Server"))
+	blocked := res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusServiceUnavailable
+	cloudflare := res.Header.Get("Cf-Ray") != "" || server == "cloudflare"
+	if cloudflare && res.Header.Get("Cf-Mitigated") == "challenge" {
+		return "cloudflare"
+	}
+	for _, marker := range cloudflareMarkers {
+		if bytes.Contains(body, marker) && (cloudflare || blocked) {
+			return "cloudflare"
+		}
+	}
+	if !blocked {
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(server, "ddos-guard"):
+		return "ddos-guard"
+	case server == "akamaighost":
+		return "akamai"
+	case res.Header.Get("X-Datadome") != "" || bytes.Contains(body, []byte("captcha-delivery.com")):
+		return "datadome"
+	case res.Header.Get("X-Sucuri-Id") != "":
+		return "sucuri"
+	}
+	return ""
+}
+
+// captchaChallenge names the captcha of a form in doc, for pages that came
+// back without their title.
+func captchaChallenge(doc *goquery.Document) string {
+	captchas := map[string]string{
+		".g-recaptcha, iframe[src*='recaptcha']":                  "recaptcha",
+		".h-captcha, iframe[src*='hcaptcha.com']":                 "hcaptcha",
+		".cf-turnstile, iframe[src*='challenges.cloudflare.com']": "turnstile",
+	}
+	for selector, name := range captchas {
+		if 0 < doc.Find(selector).Length() {
+			return name
+		}
+	}
+	return ""
+}
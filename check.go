@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// maxCheckSrcs bounds the srcs check prints per URL.
+const maxCheckSrcs = 3
+
+// check fetches the documents of the URLs given and prints what the
+// config makes of each, for tuning selectors and rules without
+// downloading any image.
+func check(config *Config, args []string) error {
+	flags := newFlagSet("check")
+	flags.BoolVar(&debugEnabled, "debug", false, "log debug details")
+	flags.BoolVar(&debugSelectors, "debug-selectors", false, "log what pages look like when the title or image selector matches nothing")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageError(errors.New("Usage: scrape-go check <url>..."))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	scraper := &Scraper{Config: config, Unattended: true}
+	failed := 0
+	for _, url := range flags.Args() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := scraper.check(ctx, url); err != nil {
+			fmt.Println("  error:      ", err)
+			failed++
+		}
+	}
+	if 0 < failed {
+		return errors.New(strconv.Itoa(failed) + " of " + strconv.Itoa(flags.NArg()) + " URLs failed the check")
+	}
+	return nil
+}
+
+func (s *Scraper) check(ctx context.Context, url string) error {
+	page, err := s.Config.MatchPage(url)
+	if err != nil {
+		fmt.Println(url)
+		return err
+	}
+	fmt.Println(url, "as", page.label())
+	client, err := s.client(page)
+	if err != nil {
+		return err
+	}
+	doc, err := page.GetDocument(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	if err := page.gate(doc, url); err != nil {
+		return err
+	}
+
+	raw := page.rawTitle(doc)
+	fmt.Printf("  title:       %q\n", raw)
+	if 0 < len(page.TitleRules) {
+		fmt.Printf("  title_rules: %q\n", page.applyTitleRules(raw))
+	}
+	title, err := page.GetTitle(doc)
+	if err != nil {
+		title = urlTitle(url)
+		fmt.Println("  no title, named", title)
+	}
+	result := &Result{Page: page.Name, Url: url, Title: s.processTitle(page, title)}
+	fmt.Println("  path:       ", s.encryptedPath(page, s.outputPath(page, result)))
+
+	selector := page.ImageSelector
+	if page.ImageSource != imageSourceMeta && page.Extract == nil && selector == "" {
+		if selector, err = s.detectImageSelector(doc); err != nil {
+			return err
+		}
+		fmt.Println("  detected:   ", selector)
+	}
+	srcs, err := s.collectSrcs(ctx, page, client, doc, selector)
+	if err != nil {
+		return err
+	}
+	srcs = page.orderSrcs(srcs)
+	srcs, originals := page.rewriteSrcs(srcs)
+	fmt.Println("  images:     ", len(srcs))
+	for i, src := range srcs {
+		if i == maxCheckSrcs {
+			fmt.Println("               …")
+			break
+		}
+		if original, ok := originals[src]; ok {
+			fmt.Println("              ", displaySrc(original))
+			fmt.Println("             →", displaySrc(src))
+			continue
+		}
+		fmt.Println("              ", displaySrc(src))
+	}
+	return nil
+}
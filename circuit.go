@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultCircuitCooloff = time.Minute
+
+var errHostDown = errors.New("host circuit open")
+
+// Circuit stops downloading from a host after circuit_threshold failures
+// in a row, until a probe after circuit_cooloff succeeds.
+type Circuit struct {
+	CircuitThreshold int      `toml:"circuit_threshold"`
+	CircuitCooloff   Duration `toml:"circuit_cooloff"`
+}
+
+type hostCircuit struct {
+	failures int
+	open     bool
+	probing  bool
+	openedAt time.Time
+	// openedAfter and skipped are reported by reportCircuits.
+	openedAfter int
+	skipped     int
+}
+
+type circuits struct {
+	mu    sync.Mutex
+	hosts map[string]*hostCircuit
+}
+
+func srcHost(src string) string {
+	u, err := url.Parse(src)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// allowHost fails fast while the circuit of src's host is open, letting a
+// single probe through once the cool-off is over.
+func (s *Scraper) allowHost(src string) error {
+	if s.Config.CircuitThreshold <= 0 || isDataUri(src) {
+		return nil
+	}
+	cooloff := s.Config.CircuitCooloff.Duration
+	if cooloff == 0 {
+		cooloff = defaultCircuitCooloff
+	}
+	s.circuits.mu.Lock()
+	defer s.circuits.mu.Unlock()
+	c := s.circuits.hosts[srcHost(src)]
+	switch {
+	case c == nil, !c.open:
+		return nil
+	case !c.probing && cooloff <= time.Since(c.openedAt):
+		c.probing = true
+		return nil
+	default:
+		c.skipped++
+		return errHostDown
+	}
+}
+
+func (s *Scraper) recordHost(src string, err error) {
+	if s.Config.CircuitThreshold <= 0 || isDataUri(src) || errors.Is(err, context.Canceled) {
+		return
+	}
+	host := srcHost(src)
+	s.circuits.mu.Lock()
+	defer s.circuits.mu.Unlock()
+	if s.circuits.hosts == nil {
+		s.circuits.hosts = make(map[string]*hostCircuit)
+	}
+	c := s.circuits.hosts[host]
+	if c == nil {
+		c = &hostCircuit{}
+		s.circuits.hosts[host] = c
+	}
+
+	if err == nil {
+		if c.open {
+			log.Println("Host", host, "circuit closed")
+		}
+		c.failures, c.open, c.probing = 0, false, false
+		return
+	}
+	c.failures++
+	switch {
+	case c.probing:
+		c.probing = false
+		c.openedAt = time.Now()
+	case !c.open && s.Config.CircuitThreshold <= c.failures:
+		log.Println("Host", host, "circuit-opened after", c.failures, "failures")
+		c.open = true
+		c.openedAt = time.Now()
+		c.openedAfter = c.failures
+	}
+}
+
+// reportCircuits logs the hosts whose circuit opened since the last report.
+func (s *Scraper) reportCircuits() {
+	s.circuits.mu.Lock()
+	defer s.circuits.mu.Unlock()
+	hosts := make([]string, 0, len(s.circuits.hosts))
+	for host, c := range s.circuits.hosts {
+		if 0 < c.openedAfter {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		c := s.circuits.hosts[host]
+		log.Println("Host", host, "circuit-opened after", c.openedAfter, "failures,", c.skipped, "images skipped")
+		c.openedAfter, c.skipped = 0, 0
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+)
+
+// Transport holds the connection settings that can be set globally and
+// overridden per page. It is embedded in both Config and Page.
+type Transport struct {
+	ForceHttp1        bool `toml:"force_http1"`
+	MaxIdleConns      int  `toml:"max_idle_conns"`
+	DisableKeepalives bool `toml:"disable_keepalives"`
+
+	// TLSInsecure disables certificate verification. TLSCAFile adds a PEM
+	// bundle to the system roots; TLSCertFile and TLSKeyFile present a
+	// client certificate.
+	TLSInsecure bool   `toml:"tls_insecure"`
+	TLSCAFile   string `toml:"tls_ca_file"`
+	TLSCertFile string `toml:"tls_cert_file"`
+	TLSKeyFile  string `toml:"tls_key_file"`
+
+	// MaxRedirects caps the redirects followed per request; 0 keeps Go's
+	// default of 10 and a negative value follows none.
+	MaxRedirects int `toml:"max_redirects"`
+
+	// Proxies are rotated per request in ProxyRotation order, or kept for
+	// a whole page with ProxySticky. A proxy failing ProxyMaxFailures
+	// requests in a row sits out for a minute.
+	Proxies          []string
+	ProxyRotation    string `toml:"proxy_rotation"`
+	ProxySticky      bool   `toml:"proxy_sticky"`
+	ProxyMaxFailures int    `toml:"proxy_max_failures"`
+
+	// UserAgent is sent with every request. Without it a UserAgents list,
+	// or the list of UAPreset, is rotated per request or, with
+	// UserAgentRotation "page", per page.
+	UserAgent         string   `toml:"user_agent"`
+	UserAgents        []string `toml:"user_agents"`
+	UAPreset          string   `toml:"ua_preset"`
+	UserAgentRotation string   `toml:"user_agent_rotation"`
+
+	// AcceptLanguage, Accept and AcceptEncoding are sent with every
+	// request, for sites that pick the language or the image format by
+	// them. Responses to an AcceptEncoding other than gzip or deflate
+	// cannot be decoded.
+	AcceptLanguage string `toml:"accept_language"`
+	Accept         string
+	AcceptEncoding string `toml:"accept_encoding"`
+}
+
+// transportOptions is the effective Transport of a page. Pages with equal
+// options share one http.Transport and therefore its connection pool.
+type transportOptions struct {
+	ForceHttp1        bool
+	MaxIdleConns      int
+	DisableKeepalives bool
+	TLSInsecure       bool
+	TLSCAFile         string
+	TLSCertFile       string
+	TLSKeyFile        string
+}
+
+func (t *Transport) validate() error {
+	if err := t.validateProxies(); err != nil {
+		return err
+	}
+	if err := validateAcceptEncoding(t.AcceptEncoding); err != nil {
+		return err
+	}
+	return t.validateUserAgents()
+}
+
+// or returns a unless it is empty.
+func or(a string, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
+
+func (s *Scraper) transportOptions(page *Page) transportOptions {
+	global := s.Config.Transport
+	opts := transportOptions{
+		ForceHttp1:        global.ForceHttp1 || page.ForceHttp1,
+		MaxIdleConns:      global.MaxIdleConns,
+		DisableKeepalives: global.DisableKeepalives || page.DisableKeepalives,
+		TLSInsecure:       global.TLSInsecure || page.TLSInsecure,
+		TLSCAFile:         or(page.TLSCAFile, global.TLSCAFile),
+		TLSCertFile:       or(page.TLSCertFile, global.TLSCertFile),
+		TLSKeyFile:        or(page.TLSKeyFile, global.TLSKeyFile),
+	}
+	if page.MaxIdleConns != 0 {
+		opts.MaxIdleConns = page.MaxIdleConns
+	}
+	return opts
+}
+
+func newTLSConfig(opts transportOptions) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: opts.TLSInsecure}
+	if opts.TLSCAFile != "" {
+		pem, err := os.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in " + opts.TLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+	if opts.TLSCertFile != "" || opts.TLSKeyFile != "" {
+		if opts.TLSCertFile == "" || opts.TLSKeyFile == "" {
+			return nil, errors.New("tls_cert_file and tls_key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func newTransport(opts transportOptions) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+	t.Proxy = proxyFromContext
+	if opts.ForceHttp1 {
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if opts.MaxIdleConns != 0 {
+		t.MaxIdleConns = opts.MaxIdleConns
+		t.MaxIdleConnsPerHost = opts.MaxIdleConns
+	}
+	t.DisableKeepAlives = opts.DisableKeepalives
+	return t, nil
+}
+
+// jar returns the cookie jar of page, loading its cookie_file and the
+// cookie_store the first time. The caller must hold s.mu.
+func (s *Scraper) jar(page *Page) (http.CookieJar, error) {
+	if jar, ok := s.jars[page]; ok {
+		return jar, nil
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	if page.CookieFile != "" {
+		err := loadCookieFile(jar, page.CookieFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var pageJar http.CookieJar = jar
+	if s.Config.CookieStore != "" {
+		if s.cookies == nil {
+			s.cookies = loadCookieStore(s.Config.CookieStore, s.FreshSession)
+		}
+		s.cookies.fill(jar)
+		pageJar = &storedJar{CookieJar: jar, store: s.cookies}
+	}
+	if s.jars == nil {
+		s.jars = make(map[*Page]http.CookieJar)
+	}
+	s.jars[page] = pageJar
+	return pageJar, nil
+}
+
+// saveCookies writes the cookie_store, if any.
+func (s *Scraper) saveCookies() error {
+	s.mu.Lock()
+	cookies := s.cookies
+	s.mu.Unlock()
+	return cookies.Save()
+}
+
+// client returns an http.Client for page built on the Transport shared by
+// every page with the same settings, with a cookie jar of its own.
+func (s *Scraper) client(page *Page) (*http.Client, error) {
+	opts := s.transportOptions(page)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.transports == nil {
+		s.transports = make(map[transportOptions]*http.Transport)
+	}
+	t, ok := s.transports[opts]
+	if !ok {
+		debugln("New transport", opts)
+		if opts.TLSInsecure {
+			log.Println("WARNING: TLS certificate verification is DISABLED for", page.Url)
+		}
+		var err error
+		t, err = newTransport(opts)
+		if err != nil {
+			return nil, err
+		}
+		if dial := s.Config.Resolver.dialContext(); dial != nil {
+			t.DialContext = dial
+		}
+		s.transports[opts] = t
+	}
+
+	jar, err := s.jar(page)
+	if err != nil {
+		return nil, err
+	}
+	max := page.MaxRedirects
+	if max == 0 {
+		max = s.Config.MaxRedirects
+	}
+	rt := s.headerTransport(page, s.userAgentTransport(page, s.pacingTransport(page, s.proxyTransport(page, s.dumpTransport(s.cassetteTransport(t))))))
+	return &http.Client{Transport: rt, Jar: jar, CheckRedirect: checkRedirect(max)}, nil
+}
+
+func checkRedirect(max int) func(*http.Request, []*http.Request) error {
+	switch {
+	case max == 0:
+		max = 10
+	case max < 0:
+		max = 0
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		// via holds every request so far, one per redirect followed.
+		if max < len(via) {
+			return fmt.Errorf("stopped after %d redirects from %s", max, via[0].URL)
+		}
+		if allowlist := allowlistOf(req.Context()); !allowlist.allows(req.URL.String()) {
+			allowlist.skip(req.Context(), "redirect", req.URL.String())
+			return fmt.Errorf("redirect from %s to off-site %s", via[0].URL, req.URL.Host)
+		}
+		debugln("Redirect", via[len(via)-1].URL, "→", req.URL)
+		return nil
+	}
+}
+
+// logRedirect logs where a request for src ended up when it was redirected.
+func logRedirect(src string, res *http.Response) {
+	if final := res.Request.URL.String(); final != src {
+		debugln("Final URL", final, "for", src)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// urlTitle names a page whose title could not be found after the last
+// meaningful segment of its URL path, or its host.
+func urlTitle(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return sanitize(rawurl)
+	}
+	segments := strings.Split(u.Path, "/")
+	for i := len(segments) - 1; 0 <= i; i-- {
+		segment := strings.TrimSuffix(segments[i], path.Ext(segments[i]))
+		switch strings.ToLower(segment) {
+		case "", "index", "default":
+			continue
+		}
+		return sanitize(segment)
+	}
+	return sanitize(u.Host)
+}
+
+// archiveUrl is the source URL recorded in the manifest of the archive at
+// path, or "" for archives without one.
+func archiveUrl(path string) string {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name != "manifest.json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return ""
+		}
+		defer rc.Close()
+		var m manifest
+		if json.NewDecoder(rc).Decode(&m) != nil {
+			return ""
+		}
+		return m.Url
+	}
+	return ""
+}
+
+// uniquePath returns path, or path with " (2)", " (3)"… before its
+// extension when an archive of another URL, by urlKey, is already saved
+// there. The URL of an archive is in its manifest or else in historyFile,
+// as for encrypted archives. Archives of an unknown URL, such as those
+// saved before manifests, are taken to be of url under --update and
+// SkipExisting, which look for them, and of another URL otherwise.
+func (s *Scraper) uniquePath(ctx context.Context, p string, url string) string {
+	ext := path.Ext(p)
+	if ext == ".age" {
+		ext = path.Ext(strings.TrimSuffix(p, ext)) + ext
+	}
+	var history map[string]string
+	candidate := p
+	for n := 2; s.storage().Exists(candidate); n++ {
+		var saved string
+		if s.storesLocally() {
+			saved = archiveUrl(candidate)
+		}
+		if saved == "" {
+			if history == nil {
+				history = historyUrls()
+			}
+			saved = history[candidate]
+		}
+		if saved == "" && (s.Update || s.SkipExisting) || saved != "" && s.Config.urlKey(saved) == s.Config.urlKey(url) {
+			break
+		}
+		logln(ctx, candidate, "holds", or(saved, "an archive of an unknown URL"))
+		candidate = strings.TrimSuffix(p, ext) + " (" + strconv.Itoa(n) + ")" + ext
+	}
+	if candidate != p {
+		logln(ctx, "Title collision, saving to", candidate)
+	}
+	return candidate
+}
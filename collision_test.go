@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveArchive saves an archive of url, with only its manifest, at path.
+func saveArchive(t *testing.T, path string, url string) {
+	t.Helper()
+	b, err := json.Marshal(manifest{Url: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := []*Image{{Name: "manifest.json", Bytes: newBody(b)}}
+	body, err := createZip(context.Background(), images, time.Now(), ArchiveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, readAll(t, body), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniquePath(t *testing.T) {
+	quiet(t)
+	inTempDir(t)
+	scraper := &Scraper{Config: &Config{}}
+	ctx := context.Background()
+	const url = "https://gallery.example/g/1"
+	for _, test := range []struct {
+		name, path string
+		// save writes what is at path already.
+		save func(path string)
+		want string
+	}{
+		{"free", "downloads/free.zip", func(string) {}, "downloads/free.zip"},
+		{"same url", "downloads/same.zip", func(path string) { saveArchive(t, path, url) }, "downloads/same.zip"},
+		{"other url", "downloads/other.zip", func(path string) { saveArchive(t, path, "https://gallery.example/g/2") }, "downloads/other (2).zip"},
+		{"no manifest", "downloads/bare.zip", func(path string) { os.WriteFile(path, []byte("PK"), 0644) }, "downloads/bare (2).zip"},
+		{"encrypted", "downloads/secret.zip.age", func(path string) { os.WriteFile(path, []byte("age-encryption.org/v1"), 0644) }, "downloads/secret (2).zip.age"},
+		{"encrypted in history", "downloads/known.zip.age", func(path string) {
+			os.WriteFile(path, []byte("age-encryption.org/v1"), 0644)
+			scraper.recordHistory(&Result{Url: url, Path: path}, nil)
+		}, "downloads/known.zip.age"},
+	} {
+		os.MkdirAll("downloads", 0755)
+		test.save(test.path)
+		if got := scraper.uniquePath(ctx, test.path, url); got != test.want {
+			t.Errorf("%s: %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// --update and SkipExisting find the archives saved before manifests.
+func TestUniquePathUnknownUrl(t *testing.T) {
+	quiet(t)
+	inTempDir(t)
+	os.MkdirAll("downloads", 0755)
+	os.WriteFile("downloads/old.zip", []byte("PK"), 0644)
+	for _, scraper := range []*Scraper{
+		{Config: &Config{}, Update: true},
+		{Config: &Config{}, SkipExisting: true},
+	} {
+		if got := scraper.uniquePath(context.Background(), "downloads/old.zip", "https://gallery.example/g/1"); got != "downloads/old.zip" {
+			t.Errorf("update %t, skip existing %t: %s", scraper.Update, scraper.SkipExisting, got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// version identifies the build in archive comments and HAR files. Release
+// builds set it with -ldflags "-X main.version=...".
+var version = "dev"
+
+// zipCommentVariables are the variables of zip_comment, the filename
+// variables plus {url} and {version}.
+var zipCommentVariables = append(nameVariables[:len(nameVariables):len(nameVariables)], "url", "version")
+
+// defaultZipComment is the archive comment without zip_comment, which
+// `unzip -z` shows even when no manifest is saved.
+const defaultZipComment = "{url} scraped {datetime:2006-01-02T15:04:05Z07:00} by scrape-go {version}"
+
+// maxZipComment is the most a zip comment can hold.
+const maxZipComment = 1<<16 - 1
+
+var defaultZipCommentTemplate = mustNameTemplate(defaultZipComment, zipCommentVariables)
+
+func mustNameTemplate(s string, variables []string) *nameTemplate {
+	tmpl, err := parseNameTemplate(s, variables)
+	if err != nil {
+		panic(err)
+	}
+	return tmpl
+}
+
+// zipComment is the archive comment of result: a single line, cut to
+// what the zip format can hold.
+func (s *Scraper) zipComment(page *Page, result *Result) string {
+	tmpl := page.zipComment
+	if tmpl == nil {
+		tmpl = s.Config.zipComment
+	}
+	if tmpl == nil {
+		tmpl = defaultZipCommentTemplate
+	}
+	vars := templateVars(result)
+	vars["url"] = result.Url
+	vars["version"] = version
+	comment := oneLine(tmpl.expand(vars, oneLine))
+	return cutUtf8(comment, maxZipComment)
+}
+
+// oneLine collapses the whitespace of s, newlines included, to single
+// spaces.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// cutUtf8 cuts s to at most n bytes without splitting a character.
+func cutUtf8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZipCommentRoundTrip(t *testing.T) {
+	config := &Config{}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	scraper := &Scraper{Config: config}
+	started := time.Date(2024, 3, 9, 12, 30, 0, 0, time.UTC)
+	result := &Result{Url: "https://example.com/gallery/1", Title: "Gallery\nwith a newline", Started: started}
+	comment := scraper.zipComment(&Page{}, result)
+	want := "https://example.com/gallery/1 scraped 2024-03-09T12:30:00Z by scrape-go " + version
+	if comment != want {
+		t.Fatalf("comment %q, want %q", comment, want)
+	}
+
+	images := []*Image{{Name: "0-a.jpg", Bytes: newBody([]byte("image"))}}
+	body, err := createZip(context.Background(), images, started, ArchiveOptions{Comment: comment})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if got := readZip(t, body).Comment; got != comment {
+		t.Errorf("archive/zip reads comment %q, want %q", got, comment)
+	}
+
+	unzip, err := exec.LookPath("unzip")
+	if err != nil {
+		t.Skip("no unzip to read the comment with")
+	}
+	path := filepath.Join(t.TempDir(), "a.zip")
+	if err := os.WriteFile(path, readAll(t, body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(unzip, "-z", path).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), comment) {
+		t.Errorf("unzip -z shows %q, without %q", out, comment)
+	}
+}
+
+func TestZipCommentTemplate(t *testing.T) {
+	config := &Config{ZipComment: "{title}\n{url}"}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	scraper := &Scraper{Config: config}
+	result := &Result{Url: "https://example.com/g", Title: "Title", Started: time.Now()}
+	if got := scraper.zipComment(&Page{}, result); got != "Title https://example.com/g" {
+		t.Errorf("comment %q is not one line", got)
+	}
+
+	config.zipComment = mustNameTemplate("{url}", zipCommentVariables)
+	result.Url = "https://example.com/" + strings.Repeat("x", maxZipComment)
+	if got := scraper.zipComment(&Page{}, result); len(got) != maxZipComment {
+		t.Errorf("comment of %d bytes, want it cut to %d", len(got), maxZipComment)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pageState remembers the validators of every page scraped under
+// Scraper.Conditional, so later runs can tell an unchanged page by a 304.
+var pageState = filepath.Join(downloadsDir, ".page-state.json")
+
+var errUnchanged = errors.New("Unchanged since the last scrape")
+
+// validators are what tells whether a document changed: its ETag and
+// Last-Modified, and the checksum of its body for the sites sending
+// neither.
+type validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Sha256       string `json:"sha256,omitempty"`
+}
+
+// conditional is the conditional fetch of the document of one scrape:
+// the validators last saved, and those of the document fetched now.
+type conditional struct {
+	url string
+	// canonical is the canonical URL of the document, when it has one,
+	// under which the validators are kept as well.
+	canonical string
+	last      validators
+	fetched   *validators
+}
+
+type conditionalKey struct{}
+
+func withConditional(ctx context.Context, c *conditional) context.Context {
+	return context.WithValue(ctx, conditionalKey{}, c)
+}
+
+// conditionalOf is the conditional of the scrape of ctx when url is its
+// document, nil otherwise.
+func conditionalOf(ctx context.Context, url string) *conditional {
+	c, _ := ctx.Value(conditionalKey{}).(*conditional)
+	if c == nil || c.url != url {
+		return nil
+	}
+	return c
+}
+
+// setHeaders makes req conditional on the last validators.
+func (c *conditional) setHeaders(req *http.Request) {
+	if c.last.ETag != "" {
+		req.Header.Set("If-None-Match", c.last.ETag)
+	}
+	if c.last.LastModified != "" {
+		req.Header.Set("If-Modified-Since", c.last.LastModified)
+	}
+}
+
+// observe records the validators of the document fetched, and fails
+// with errUnchanged when a document without any has the checksum of the
+// last one.
+func (c *conditional) observe(header http.Header, body []byte) error {
+	fetched := &validators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified"), Sha256: checksum(body)}
+	c.fetched = fetched
+	if fetched.ETag == "" && fetched.LastModified == "" && fetched.Sha256 == c.last.Sha256 {
+		return errUnchanged
+	}
+	return nil
+}
+
+// pageStates maps each page URL, by key, to its validators when last
+// saved.
+type pageStates struct {
+	mu   sync.Mutex
+	urls map[string]validators
+	key  func(string) string
+}
+
+func loadPageStates(key func(string) string) *pageStates {
+	state := &pageStates{urls: map[string]validators{}, key: key}
+	data, err := os.ReadFile(pageState)
+	if err == nil {
+		err = json.Unmarshal(data, &state.urls)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println("WARNING: page state:", err)
+	}
+	return state
+}
+
+// conditional starts the conditional fetch of url, or returns nil unless
+// s.Conditional.
+func (s *Scraper) conditional(url string) *conditional {
+	if !s.Conditional {
+		return nil
+	}
+	s.mu.Lock()
+	if s.pages == nil {
+		s.pages = loadPageStates(s.Config.urlKey)
+	}
+	states := s.pages
+	s.mu.Unlock()
+	states.mu.Lock()
+	defer states.mu.Unlock()
+	return &conditional{url: url, last: states.urls[states.key(url)]}
+}
+
+// recordConditional saves the validators of the document of c, once its
+// archive is saved with every image.
+func (s *Scraper) recordConditional(c *conditional) error {
+	if c == nil || c.fetched == nil {
+		return nil
+	}
+	s.mu.Lock()
+	states := s.pages
+	s.mu.Unlock()
+	states.mu.Lock()
+	defer states.mu.Unlock()
+	states.urls[states.key(c.url)] = *c.fetched
+	if c.canonical != "" {
+		states.urls[states.key(c.canonical)] = *c.fetched
+	}
+	data, err := json.MarshalIndent(states.urls, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pageState), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(pageState, data, 0644)
+}
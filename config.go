@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string like "10s" in the config.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// Size is a number of bytes written as a string like "16MB" in the config.
+type Size struct {
+	Bytes int64
+}
+
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+}
+
+func (s *Size) UnmarshalText(text []byte) error {
+	t := strings.ToUpper(strings.TrimSpace(string(text)))
+	i := strings.IndexFunc(t, func(r rune) bool { return (r < '0' || '9' < r) && r != '.' })
+	if i < 0 {
+		i = len(t)
+	}
+	unit, ok := sizeUnits[strings.TrimSpace(t[i:])]
+	n, err := strconv.ParseFloat(t[:i], 64)
+	if !ok || err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", text)
+	}
+	s.Bytes = int64(n * float64(unit))
+	return nil
+}
+
+// Validate checks the config and compiles the patterns it contains.
+func (c *Config) Validate() error {
+	if c.Filename != "" {
+		tmpl, err := parseNameTemplate(c.Filename, nameVariables)
+		if err != nil {
+			return fmt.Errorf("filename: %v", err)
+		}
+		c.filename = tmpl
+	}
+	if c.ZipComment != "" {
+		tmpl, err := parseNameTemplate(c.ZipComment, zipCommentVariables)
+		if err != nil {
+			return fmt.Errorf("zip_comment: %v", err)
+		}
+		c.zipComment = tmpl
+	}
+	if err := c.Transport.validate(); err != nil {
+		return err
+	}
+	if err := c.Resolver.validate(); err != nil {
+		return err
+	}
+	if err := validateEntryLayout(c.EntryLayout); err != nil {
+		return err
+	}
+	if err := c.Encryption.compile(); err != nil {
+		return err
+	}
+	if err := c.Timeouts.validate(); err != nil {
+		return err
+	}
+	if err := c.Pacing.validate(); err != nil {
+		return err
+	}
+	if err := c.ThumbnailOptions.validate(); err != nil {
+		return err
+	}
+	if err := c.Duplicates.validate(); err != nil {
+		return err
+	}
+	if err := c.validateFetching(); err != nil {
+		return err
+	}
+	if err := c.Throttle.validate(); err != nil {
+		return err
+	}
+	if err := c.Types.validate(); err != nil {
+		return err
+	}
+	if err := c.Blocklist.validate(); err != nil {
+		return err
+	}
+	if err := c.Statuses.validate(); err != nil {
+		return err
+	}
+	if c.Upload != nil {
+		if err := c.Upload.validate(); err != nil {
+			return fmt.Errorf("upload: %v", err)
+		}
+	}
+	for i := range c.Pages {
+		page := &c.Pages[i]
+		err := page.compile()
+		if err != nil {
+			return fmt.Errorf("page %s: %v", page.label(), err)
+		}
+	}
+	return nil
+}
+
+// label names the page in messages.
+func (p *Page) label() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Url
+}
+
+func (p *Page) compile() error {
+	if err := p.Transport.validate(); err != nil {
+		return err
+	}
+	if err := validateEntryLayout(p.EntryLayout); err != nil {
+		return err
+	}
+	if err := p.Encryption.compile(); err != nil {
+		return err
+	}
+	if err := p.Timeouts.validate(); err != nil {
+		return err
+	}
+	if err := p.Pacing.validate(); err != nil {
+		return err
+	}
+	if err := p.ThumbnailOptions.validate(); err != nil {
+		return err
+	}
+	if err := p.Duplicates.validate(); err != nil {
+		return err
+	}
+	if err := p.Types.validate(); err != nil {
+		return err
+	}
+	if err := p.Blocklist.validate(); err != nil {
+		return err
+	}
+	if err := p.Statuses.validate(); err != nil {
+		return err
+	}
+	if err := p.Api.compile(); err != nil {
+		return err
+	}
+	if err := p.Sitemap.compile(); err != nil {
+		return err
+	}
+	if err := p.ExpectedCount.compile(); err != nil {
+		return err
+	}
+	if err := compileTitleRules(p.TitleRules); err != nil {
+		return err
+	}
+	if err := compileSrcRules(p.SrcRewrite); err != nil {
+		return err
+	}
+	if err := p.Order.compile(); err != nil {
+		return err
+	}
+	selectors := map[string]string{
+		"title_selector":      p.TitleSelector,
+		"image_selector":      p.ImageSelector,
+		"media_selector":      p.MediaSelector,
+		"iframe_selector":     p.IframeSelector,
+		"merge_selector":      p.MergeSelector,
+		"crawl_link_selector": p.CrawlLinkSelector,
+		"abort_if_selector":   p.AbortIfSelector,
+		"require_selector":    p.RequireSelector,
+	}
+	for key, selector := range selectors {
+		if err := compileSelector(selector); err != nil {
+			return fmt.Errorf("%s: invalid XPath: %v", key, err)
+		}
+	}
+
+	switch p.ImageSource {
+	case "", imageSourceHtml, imageSourceMeta:
+	default:
+		return fmt.Errorf("image_source: unknown source %q", p.ImageSource)
+	}
+	switch p.Prefer {
+	case "", preferLargest:
+	default:
+		return fmt.Errorf("prefer: unknown mode %q", p.Prefer)
+	}
+
+	if p.Filename != "" {
+		tmpl, err := parseNameTemplate(p.Filename, nameVariables)
+		if err != nil {
+			return fmt.Errorf("filename: %v", err)
+		}
+		p.filename = tmpl
+	}
+	if p.ZipComment != "" {
+		tmpl, err := parseNameTemplate(p.ZipComment, zipCommentVariables)
+		if err != nil {
+			return fmt.Errorf("zip_comment: %v", err)
+		}
+		p.zipComment = tmpl
+	}
+
+	if p.Upload != nil {
+		if err := p.Upload.validate(); err != nil {
+			return fmt.Errorf("upload: %v", err)
+		}
+	}
+
+	if p.Extract != nil {
+		if err := p.Extract.compile(); err != nil {
+			return fmt.Errorf("extract: %v", err)
+		}
+	}
+
+	pattern := p.HostPattern
+	if 2 <= len(pattern) && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return fmt.Errorf("host_pattern: %v", err)
+		}
+		p.hostPattern = re
+	} else if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("host_pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+func (p *Page) MatchHost(host string) bool {
+	host = strings.ToLower(host)
+	if p.hostPattern != nil {
+		return p.hostPattern.MatchString(host)
+	}
+	if p.HostPattern == "" {
+		return false
+	}
+	ok, _ := path.Match(strings.ToLower(p.HostPattern), host)
+	return ok
+}
+
+// MatchPage returns the page whose host_pattern matches rawurl. When several
+// match, the one with the highest priority wins; a tie is an error.
+func (c *Config) MatchPage(rawurl string) (*Page, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	var best []*Page
+	for i := range c.Pages {
+		page := &c.Pages[i]
+		if !page.MatchHost(u.Hostname()) {
+			continue
+		}
+		if len(best) == 0 || best[0].Priority < page.Priority {
+			best = []*Page{page}
+		} else if best[0].Priority == page.Priority {
+			best = append(best, page)
+		}
+	}
+	switch len(best) {
+	case 0:
+		return nil, errors.New("No page matches host " + u.Hostname())
+	case 1:
+		return best[0], nil
+	default:
+		names := make([]string, len(best))
+		for i, page := range best {
+			names[i] = page.label()
+		}
+		return nil, errors.New("Pages " + strings.Join(names, ", ") +
+			" all match host " + u.Hostname() + "; give one a higher priority")
+	}
+}
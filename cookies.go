@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+// parseCookieLine parses one line of a Netscape cookies.txt file:
+// domain, include-subdomains, path, secure, expiry, name and value
+// separated by tabs. It returns the URL the cookie belongs to.
+func parseCookieLine(line string) (*url.URL, *http.Cookie, error) {
+	httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+	if httpOnly {
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+	}
+	fields := strings.Split(line, "\t")
+	if len(fields) != 7 {
+		return nil, nil, errors.New("expected 7 tab separated fields, got " + strconv.Itoa(len(fields)))
+	}
+	domain, subdomains, path, secure, expiry, name, value :=
+		fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return nil, nil, errors.New("invalid expiry " + expiry)
+	}
+	host := strings.TrimPrefix(domain, ".")
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Secure:   strings.EqualFold(secure, "TRUE"),
+		HttpOnly: httpOnly,
+	}
+	// An empty Domain makes the jar treat the cookie as host-only.
+	if strings.EqualFold(subdomains, "TRUE") {
+		cookie.Domain = host
+	}
+	if expires != 0 {
+		cookie.Expires = time.Unix(expires, 0)
+	}
+
+	scheme := "http"
+	if cookie.Secure {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: path}, cookie, nil
+}
+
+// loadCookieFile adds the cookies of a curl-compatible cookies.txt export
+// to jar. Expired cookies are skipped with a warning.
+func loadCookieFile(jar http.CookieJar, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	loaded, expired := 0, 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") && !strings.HasPrefix(line, httpOnlyPrefix) {
+			continue
+		}
+		u, cookie, err := parseCookieLine(line)
+		if err != nil {
+			return errors.New(path + ":" + strconv.Itoa(n) + ": " + err.Error())
+		}
+		if !cookie.Expires.IsZero() && cookie.Expires.Before(now) {
+			log.Println("WARNING:", path+":"+strconv.Itoa(n), "cookie", cookie.Name, "for", u.Host, "expired", cookie.Expires.Format(time.RFC3339))
+			expired++
+			continue
+		}
+		jar.SetCookies(u, []*http.Cookie{cookie})
+		loaded++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Println("Loaded", loaded, "cookies from", path, "skipped", expired, "expired")
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// storedCookie is a cookie of the cookie_store file. Expires, in Unix
+// seconds, is 0 for session cookies, which are kept too, as they are what
+// logins set.
+type storedCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Expires  int64  `json:"expires,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+	HttpOnly bool   `json:"http_only,omitempty"`
+}
+
+func (c storedCookie) expired(now time.Time) bool {
+	return c.Expires != 0 && c.Expires <= now.Unix()
+}
+
+// cookieStore keeps the cookies the hosts set, by host, in a file that
+// outlives the run.
+type cookieStore struct {
+	path string
+
+	mu    sync.Mutex
+	hosts map[string][]storedCookie
+	dirty bool
+}
+
+// loadCookieStore reads the cookie store at path, or starts an empty one
+// if there is none yet or fresh is set.
+func loadCookieStore(path string, fresh bool) *cookieStore {
+	store := &cookieStore{path: expandHome(path), hosts: map[string][]storedCookie{}}
+	if fresh {
+		return store
+	}
+	b, err := os.ReadFile(store.path)
+	if err == nil {
+		err = json.Unmarshal(b, &store.hosts)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("WARNING: cookie_store:", err)
+	}
+	return store
+}
+
+// fill adds the stored cookies that have not expired to jar.
+func (c *cookieStore) fill(jar http.CookieJar) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for host, stored := range c.hosts {
+		for _, sc := range stored {
+			if sc.expired(now) {
+				// Save drops it.
+				c.dirty = true
+				continue
+			}
+			scheme := "http"
+			if sc.Secure {
+				scheme = "https"
+			}
+			cookie := &http.Cookie{
+				Name:     sc.Name,
+				Value:    sc.Value,
+				Domain:   sc.Domain,
+				Path:     sc.Path,
+				Secure:   sc.Secure,
+				HttpOnly: sc.HttpOnly,
+			}
+			if sc.Expires != 0 {
+				cookie.Expires = time.Unix(sc.Expires, 0)
+			}
+			jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: "/"}, []*http.Cookie{cookie})
+		}
+	}
+}
+
+// record stores the cookies set by a response from u, replacing those of
+// the same name, domain and path and dropping the ones they expire.
+func (c *cookieStore) record(u *url.URL, cookies []*http.Cookie) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	host := u.Hostname()
+	now := time.Now()
+	for _, cookie := range cookies {
+		sc := storedCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		}
+		switch {
+		case cookie.MaxAge < 0:
+			sc.Expires = now.Unix()
+		case 0 < cookie.MaxAge:
+			sc.Expires = now.Unix() + int64(cookie.MaxAge)
+		case !cookie.Expires.IsZero():
+			sc.Expires = cookie.Expires.Unix()
+		}
+		kept := c.hosts[host][:0]
+		for _, old := range c.hosts[host] {
+			if old.Name != sc.Name || old.Domain != sc.Domain || old.Path != sc.Path {
+				kept = append(kept, old)
+			}
+		}
+		if !sc.expired(now) {
+			kept = append(kept, sc)
+		}
+		c.hosts[host] = kept
+		c.dirty = true
+	}
+}
+
+// Save writes the store, without its expired cookies, readable by the
+// user only. It does nothing when no cookie changed.
+func (c *cookieStore) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	now := time.Now()
+	for host, stored := range c.hosts {
+		kept := stored[:0]
+		for _, sc := range stored {
+			if !sc.expired(now) {
+				kept = append(kept, sc)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.hosts, host)
+		} else {
+			c.hosts[host] = kept
+		}
+	}
+	b, err := json.MarshalIndent(c.hosts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	// WriteFile leaves the mode of an existing file alone.
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// storedJar is a cookie jar whose cookies also go to a cookieStore.
+type storedJar struct {
+	http.CookieJar
+	store *cookieStore
+}
+
+func (j *storedJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.CookieJar.SetCookies(u, cookies)
+	j.store.record(u, cookies)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultCountPattern finds the first number of the matched text, with
+// thousands separators, as in "1,024 images".
+var defaultCountPattern = regexp.MustCompile(`\d[\d,.]*`)
+
+// ExpectedCount checks the number of images found against the number
+// the page shows in the text of ExpectedCountSelector, taken by the
+// first capture group of ExpectedCountRegex or else its first number.
+type ExpectedCount struct {
+	ExpectedCountSelector string `toml:"expected_count_selector"`
+	ExpectedCountRegex    string `toml:"expected_count_regex"`
+
+	expectedCountRegex *regexp.Regexp
+}
+
+func (c *ExpectedCount) compile() error {
+	if c.ExpectedCountRegex == "" {
+		return nil
+	}
+	if c.ExpectedCountSelector == "" {
+		return errors.New("expected_count_selector is required by expected_count_regex")
+	}
+	re, err := regexp.Compile(c.ExpectedCountRegex)
+	if err != nil {
+		return fmt.Errorf("expected_count_regex: %v", err)
+	}
+	if re.NumSubexp() < 1 {
+		return errors.New("expected_count_regex needs a capture group for the number")
+	}
+	c.expectedCountRegex = re
+	return nil
+}
+
+// expectedCount reads the number of images doc claims to have.
+func (c *ExpectedCount) expectedCount(doc *goquery.Document) (int, error) {
+	text := strings.TrimSpace(find(doc, c.ExpectedCountSelector).First().Text())
+	if text == "" {
+		return 0, errors.New("expected_count_selector " + strconv.Quote(c.ExpectedCountSelector) + " matched nothing")
+	}
+	number := ""
+	if c.expectedCountRegex != nil {
+		if m := c.expectedCountRegex.FindStringSubmatch(text); m != nil {
+			number = m[1]
+		}
+	} else {
+		number = defaultCountPattern.FindString(text)
+	}
+	number = strings.NewReplacer(",", "", ".", "").Replace(number)
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return 0, errors.New("no count found in " + strconv.Quote(text))
+	}
+	return n, nil
+}
+
+// checkCount compares the srcs found in doc with the count it shows,
+// failing the page on a mismatch under strict_count.
+func (s *Scraper) checkCount(ctx context.Context, page *Page, doc *goquery.Document, found int, result *Result) error {
+	if page.ExpectedCountSelector == "" || doc == nil {
+		return nil
+	}
+	expected, err := page.expectedCount(doc)
+	if err != nil {
+		logln(ctx, "WARNING: expected count:", err)
+		return nil
+	}
+	result.ExpectedCount = expected
+	result.FoundCount = found
+	if expected == found {
+		return nil
+	}
+	msg := "page shows " + strconv.Itoa(expected) + " images but " + strconv.Itoa(found) + " were found"
+	if s.Config.StrictCount || page.StrictCount {
+		return errors.New("Count mismatch: " + msg)
+	}
+	logln(ctx, "WARNING: count mismatch:", msg)
+	return nil
+}
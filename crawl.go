@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultCrawlLinkSelector = "a[href]"
+	defaultCrawlMaxPages     = 100
+)
+
+// Crawl follows the same-host links matched by CrawlLinkSelector
+// (default "a[href]") from the page URL up to CrawlDepth links deep, and
+// archives every page with images, each through the usual pipeline.
+// CrawlMaxPages (default 100) bounds the pages fetched per host.
+type Crawl struct {
+	CrawlDepth        int    `toml:"crawl_depth"`
+	CrawlLinkSelector string `toml:"crawl_link_selector"`
+	CrawlMaxPages     int    `toml:"crawl_max_pages"`
+}
+
+// canonicalUrl keys the visited set: without fragment, with lower case
+// scheme and host and without trailing slash.
+func canonicalUrl(u *url.URL) string {
+	c := *u
+	c.Fragment = ""
+	c.RawFragment = ""
+	c.Scheme = strings.ToLower(c.Scheme)
+	c.Host = strings.ToLower(c.Host)
+	c.Path = strings.TrimRight(c.Path, "/")
+	c.RawPath = ""
+	return c.String()
+}
+
+// crawlStats describe the tree of a crawl.
+type crawlStats struct {
+	Depths   []int
+	Archived int
+	Failed   int
+	OffHost  int
+	OverMax  int
+}
+
+func (c *crawlStats) String() string {
+	depths := make([]string, len(c.Depths))
+	for i, n := range c.Depths {
+		depths[i] = fmt.Sprint(i, ":", n)
+	}
+	visited := 0
+	for _, n := range c.Depths {
+		visited += n
+	}
+	return fmt.Sprint(
+		visited, " pages visited (by depth ", strings.Join(depths, " "), "), ",
+		c.Archived, " with images, ",
+		c.Failed, " failed, ",
+		c.OffHost, " off-host links ignored, ",
+		c.OverMax, " links over crawl_max_pages",
+	)
+}
+
+type crawlLink struct {
+	Url   *url.URL
+	Depth int
+}
+
+// crawl archives the pages found from page.Url, handing every outcome to
+// add.
+func (s *Scraper) crawl(ctx context.Context, page *Page, add func(*Result, error)) {
+	start, err := url.Parse(page.Url)
+	if err != nil {
+		result := &Result{Page: page.Name, Url: page.Url}
+		s.Report.Add(result, err)
+		add(result, err)
+		return
+	}
+	client, err := s.client(page)
+	if err != nil {
+		result := &Result{Page: page.Name, Url: page.Url}
+		s.Report.Add(result, err)
+		add(result, err)
+		return
+	}
+	selector := or(page.CrawlLinkSelector, defaultCrawlLinkSelector)
+	max := page.CrawlMaxPages
+	if max <= 0 {
+		max = defaultCrawlMaxPages
+	}
+
+	stats := &crawlStats{}
+	visited := map[string]bool{canonicalUrl(start): true}
+	perHost := map[string]int{}
+	queue := []crawlLink{{Url: start}}
+	for 0 < len(queue) && ctx.Err() == nil {
+		link := queue[0]
+		queue = queue[1:]
+		host := strings.ToLower(link.Url.Hostname())
+		if max <= perHost[host] {
+			stats.OverMax++
+			continue
+		}
+		perHost[host]++
+		for len(stats.Depths) <= link.Depth {
+			stats.Depths = append(stats.Depths, 0)
+		}
+		stats.Depths[link.Depth]++
+
+		rawurl := link.Url.String()
+		debugln("Crawl", rawurl, "at depth", link.Depth)
+		doc, err := page.GetDocument(ctx, client, rawurl)
+		if err != nil {
+			log.Println("Failed", rawurl, err)
+			stats.Failed++
+			if link.Depth == 0 {
+				result := &Result{Page: page.Name, Url: rawurl}
+				s.Report.Add(result, err)
+				add(result, err)
+			}
+			continue
+		}
+		if link.Depth < page.CrawlDepth {
+			find(doc, selector).Each(func(i int, a *goquery.Selection) {
+				href, _ := a.Attr("href")
+				u, err := doc.Url.Parse(strings.TrimSpace(href))
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					return
+				}
+				if !strings.EqualFold(u.Hostname(), start.Hostname()) {
+					stats.OffHost++
+					return
+				}
+				key := canonicalUrl(u)
+				if visited[key] {
+					return
+				}
+				visited[key] = true
+				u.Fragment = ""
+				queue = append(queue, crawlLink{Url: u, Depth: link.Depth + 1})
+			})
+		}
+
+		if !s.hasImages(page, doc) {
+			continue
+		}
+		stats.Archived++
+		log.Println("→", rawurl)
+		result, err := s.scrape(ctx, page, rawurl, nil)
+		if err != nil {
+			log.Println("Failed", rawurl, err)
+		}
+		add(result, err)
+	}
+	log.Println("Crawl", page.Url+":", stats.String())
+}
+
+// hasImages reports whether doc has images for page to archive.
+func (s *Scraper) hasImages(page *Page, doc *goquery.Document) bool {
+	if page.ImageSelector == "" || s.Auto {
+		_, err := s.detectImageSelector(doc)
+		return err == nil
+	}
+	return 0 < find(doc, page.ImageSelector).Length()
+}
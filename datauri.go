@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+var imageExtensions = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/avif":    ".avif",
+	"image/bmp":     ".bmp",
+	"image/svg+xml": ".svg",
+	"image/x-icon":  ".ico",
+}
+
+// extensionForType returns the file extension for a MIME type.
+func extensionForType(mediatype string) string {
+	if ext, ok := imageExtensions[mediatype]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediatype); 0 < len(exts) {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+func isDataUri(src string) bool {
+	return 5 <= len(src) && strings.EqualFold(src[:5], "data:")
+}
+
+// displaySrc shortens data URIs so logs stay readable.
+func displaySrc(src string) string {
+	if isDataUri(src) && 48 < len(src) {
+		return src[:48] + "…"
+	}
+	return src
+}
+
+// decodeDataUri turns an RFC 2397 data URI into an Image without touching
+// the network. The name is derived from the payload so it is stable.
+func decodeDataUri(src string) (*Image, error) {
+	comma := strings.IndexByte(src, ',')
+	if comma < 0 {
+		return nil, errors.New("Malformed data URI: no comma")
+	}
+	header, payload := src[len("data:"):comma], src[comma+1:]
+
+	isBase64 := false
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		isBase64 = true
+		header = header[:len(header)-len(";base64")]
+	}
+	mediatype := "text/plain"
+	if header != "" {
+		parsed, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return nil, errors.New("Malformed data URI media type: " + err.Error())
+		}
+		mediatype = parsed
+	}
+
+	var data []byte
+	var err error
+	if isBase64 {
+		payload, err = url.PathUnescape(payload)
+		if err == nil {
+			payload = strings.Map(func(r rune) rune {
+				if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+					return -1
+				}
+				return r
+			}, payload)
+			data, err = base64.StdEncoding.DecodeString(payload)
+			if err != nil {
+				data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+			}
+		}
+	} else {
+		var s string
+		s, err = url.PathUnescape(payload)
+		data = []byte(s)
+	}
+	if err != nil {
+		return nil, errors.New("Malformed data URI payload: " + err.Error())
+	}
+
+	sum := sha256.Sum256(data)
+	name := "data-" + hex.EncodeToString(sum[:6]) + extensionForType(mediatype)
+	return &Image{Name: name, Bytes: newBody(data)}, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Deadline bounds the wall-clock time of a whole page, document and
+// images together, so one pathological host cannot wedge a run.
+type Deadline struct {
+	PageTimeout Duration `toml:"page_timeout"`
+	// SalvagePartial saves the images downloaded before page_timeout ran
+	// out to <name>.partial.zip.
+	SalvagePartial bool `toml:"salvage_partial"`
+}
+
+func (s *Scraper) pageTimeout(page *Page) time.Duration {
+	if page.PageTimeout.Duration != 0 {
+		return page.PageTimeout.Duration
+	}
+	return s.Config.PageTimeout.Duration
+}
+
+// timedOut reports whether err is ctx running out of time.
+func timedOut(ctx context.Context, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// fits reports whether waiting d leaves ctx any time to do something.
+func fits(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || d < time.Until(deadline)
+}
+
+// salvage saves the images of a timed-out page next to where the full
+// archive would go.
+func salvage(ctx context.Context, storage Storage, result *Result, images []*Image, opts ArchiveOptions) error {
+	zip, err := createZip(ctx, images, result.Started, opts)
+	if err != nil {
+		return err
+	}
+	defer zip.Close()
+	path, encrypted := strings.TrimSuffix(result.Path, ".age"), ""
+	if path != result.Path {
+		encrypted = ".age"
+	}
+	path = strings.TrimSuffix(path, ".zip") + ".partial.zip" + encrypted
+	_, err = save(ctx, storage, path, zip)
+	if err != nil {
+		return err
+	}
+	result.Path = path
+	result.Images = len(images)
+	for _, image := range images {
+		result.Bytes += int64(image.Bytes.Len())
+	}
+	emit(ctx, ArchiveWritten{Url: result.Url, Path: path, Images: result.Images, Bytes: result.Bytes})
+	return nil
+}
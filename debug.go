@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// debugEnabled is set by the --debug flag of every command.
+var debugEnabled bool
+
+func debugln(v ...interface{}) {
+	if debugEnabled {
+		log.Println(append([]interface{}{"DEBUG"}, v...)...)
+	}
+}
+
+type jobKey struct{}
+
+// withJob tags the log lines of the scrape running under ctx with the job
+// id, so concurrent jobs can be told apart.
+func withJob(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, jobKey{}, id)
+}
+
+// logln is log.Println prefixed with the job of ctx, if any.
+func logln(ctx context.Context, v ...interface{}) {
+	if id, ok := ctx.Value(jobKey{}).(string); ok {
+		v = append([]interface{}{"[job " + id + "]"}, v...)
+	}
+	log.Println(v...)
+}
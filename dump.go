@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dumpBodyLimit is how much of the body of error responses is logged.
+const dumpBodyLimit = 1024
+
+// Dump logs every HTTP exchange when Log is set and records it for a HAR
+// file when Har is. Secrets in headers are redacted unless Unsafe is set.
+type Dump struct {
+	Log    bool
+	Unsafe bool
+	Har    string
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+// secretHeaders have their values redacted; cookies keep their names.
+var secretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+func (d *Dump) redact(name, value string) string {
+	if d.Unsafe || !secretHeaders[name] {
+		return value
+	}
+	if name != "Cookie" && name != "Set-Cookie" {
+		return "[redacted]"
+	}
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		if name == "Set-Cookie" && 0 < i {
+			// Attributes such as Path and Expires are no secret.
+			continue
+		}
+		if eq := strings.IndexByte(part, '='); 0 <= eq {
+			parts[i] = part[:eq+1] + "[redacted]"
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+func (d *Dump) headers(h http.Header) []harPair {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []harPair
+	for _, name := range names {
+		for _, value := range h[name] {
+			pairs = append(pairs, harPair{Name: name, Value: d.redact(name, value)})
+		}
+	}
+	return pairs
+}
+
+// dumpTransport logs and records the exchanges of base.
+type dumpTransport struct {
+	base http.RoundTripper
+	dump *Dump
+}
+
+func (s *Scraper) dumpTransport(base http.RoundTripper) http.RoundTripper {
+	if s.Dump == nil || (!s.Dump.Log && s.Dump.Har == "") {
+		return base
+	}
+	return &dumpTransport{base: base, dump: s.Dump}
+}
+
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	d := t.dump
+	requestHeaders := d.headers(req.Header)
+	if d.Log {
+		var b strings.Builder
+		b.WriteString("> " + req.Method + " " + req.URL.String() + " " + req.Proto)
+		for _, h := range requestHeaders {
+			b.WriteString("\n> " + h.Name + ": " + h.Value)
+		}
+		log.Println(b.String())
+	}
+
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	wait := time.Since(start)
+	if err != nil {
+		if d.Log {
+			log.Println("< error:", req.URL, err)
+		}
+		return res, err
+	}
+
+	responseHeaders := d.headers(res.Header)
+	if d.Log {
+		var b strings.Builder
+		b.WriteString("< " + res.Proto + " " + res.Status + " " + req.URL.String())
+		for _, h := range responseHeaders {
+			b.WriteString("\n< " + h.Name + ": " + h.Value)
+		}
+		if 400 <= res.StatusCode {
+			head := make([]byte, dumpBodyLimit)
+			n, _ := io.ReadFull(res.Body, head)
+			head = head[:n]
+			res.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(head), res.Body), res.Body}
+			b.WriteString("\n<\n" + string(head))
+			if n == dumpBodyLimit {
+				b.WriteString("\n< [truncated]")
+			}
+		}
+		log.Println(b.String())
+	}
+
+	if d.Har != "" {
+		d.record(req, res, requestHeaders, responseHeaders, start, wait)
+	}
+	return res, nil
+}
+
+func (d *Dump) record(req *http.Request, res *http.Response, requestHeaders, responseHeaders []harPair, start time.Time, wait time.Duration) {
+	query := []harPair{}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			query = append(query, harPair{Name: name, Value: value})
+		}
+	}
+	millis := float64(wait) / float64(time.Millisecond)
+	entry := harEntry{
+		Started: start.Format(time.RFC3339Nano),
+		Time:    millis,
+		Request: harRequest{
+			Method:      req.Method,
+			Url:         req.URL.String(),
+			HttpVersion: req.Proto,
+			Headers:     orEmpty(requestHeaders),
+			QueryString: query,
+			Cookies:     []harPair{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: harResponse{
+			Status:      res.StatusCode,
+			StatusText:  http.StatusText(res.StatusCode),
+			HttpVersion: res.Proto,
+			Headers:     orEmpty(responseHeaders),
+			Cookies:     []harPair{},
+			Content:     harContent{Size: res.ContentLength, MimeType: res.Header.Get("Content-Type")},
+			RedirectUrl: res.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    res.ContentLength,
+		},
+		Cache:   struct{}{},
+		Timings: harTimings{Send: 0, Wait: millis, Receive: -1},
+	}
+	d.mu.Lock()
+	d.entries = append(d.entries, entry)
+	d.mu.Unlock()
+}
+
+func orEmpty(pairs []harPair) []harPair {
+	if pairs == nil {
+		return []harPair{}
+	}
+	return pairs
+}
+
+// reset forgets the exchanges recorded so far.
+func (d *Dump) reset() {
+	d.mu.Lock()
+	d.entries = nil
+	d.mu.Unlock()
+}
+
+// WriteHar writes the recorded exchanges to the HAR file, if any.
+func (d *Dump) WriteHar() error {
+	if d == nil || d.Har == "" {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.Create(d.Har)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var har struct {
+		Log struct {
+			Version string     `json:"version"`
+			Creator harCreator `json:"creator"`
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	har.Log.Version = "1.2"
+	har.Log.Creator = harCreator{Name: "scrape-go", Version: version}
+	har.Log.Entries = orEmptyEntries(d.entries)
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(har)
+}
+
+func orEmptyEntries(entries []harEntry) []harEntry {
+	if entries == nil {
+		return []harEntry{}
+	}
+	return entries
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	Started  string      `json:"startedDateTime"`
+	Time     float64     `json:"time"`
+	Request  harRequest  `json:"request"`
+	Response harResponse `json:"response"`
+	Cache    struct{}    `json:"cache"`
+	Timings  harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string    `json:"method"`
+	Url         string    `json:"url"`
+	HttpVersion string    `json:"httpVersion"`
+	Headers     []harPair `json:"headers"`
+	QueryString []harPair `json:"queryString"`
+	Cookies     []harPair `json:"cookies"`
+	HeadersSize int       `json:"headersSize"`
+	BodySize    int       `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HttpVersion string     `json:"httpVersion"`
+	Headers     []harPair  `json:"headers"`
+	Cookies     []harPair  `json:"cookies"`
+	Content     harContent `json:"content"`
+	RedirectUrl string     `json:"redirectURL"`
+	HeadersSize int        `json:"headersSize"`
+	BodySize    int64      `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// dumpFlags adds --dump-http, --dump-http-unsafe and --dump-har to flags.
+func dumpFlags(flags *flag.FlagSet) *Dump {
+	d := &Dump{}
+	flags.BoolVar(&d.Log, "dump-http", false, "log the headers of every HTTP request and response, with secrets redacted")
+	flags.BoolVar(&d.Unsafe, "dump-http-unsafe", false, "with --dump-http or --dump-har, keep Authorization and cookie values")
+	flags.StringVar(&d.Har, "dump-har", "", "record every HTTP exchange into this HAR file")
+	return d
+}
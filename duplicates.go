@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/image/draw"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// defaultDuplicateThreshold is the Hamming distance between the dHashes
+// of two images up to which they count as the same picture.
+const defaultDuplicateThreshold = 5
+
+// Duplicates finds the images of a page that repeat an earlier one.
+// Exact copies are always reported. FindDuplicates also reports the near
+// duplicates by perceptual hash, those within DuplicateThreshold (5)
+// bits, and PruneDuplicates keeps only the first image of each.
+type Duplicates struct {
+	FindDuplicates     bool `toml:"find_duplicates"`
+	PruneDuplicates    bool `toml:"prune_duplicates"`
+	DuplicateThreshold int  `toml:"duplicate_threshold"`
+}
+
+func (d Duplicates) validate() error {
+	if d.DuplicateThreshold < 0 || 64 < d.DuplicateThreshold {
+		return errors.New("duplicate_threshold must be between 0 and 64")
+	}
+	return nil
+}
+
+// Pruned is an image left out of the archive as a duplicate.
+type Pruned struct {
+	Url         string `json:"url"`
+	DuplicateOf string `json:"duplicate_of"`
+}
+
+// findDuplicates sets the DuplicateOf of every image of page repeating an
+// earlier one, in page order, and counts them in result. It returns the
+// images to archive, without the duplicates under prune_duplicates.
+func (s *Scraper) findDuplicates(ctx context.Context, page *Page, result *Result, images []*Image) []*Image {
+	perceptual := s.Config.FindDuplicates || page.FindDuplicates || s.Config.PruneDuplicates || page.PruneDuplicates
+	prune := s.Config.PruneDuplicates || page.PruneDuplicates
+	threshold := page.DuplicateThreshold
+	if threshold == 0 {
+		threshold = s.Config.DuplicateThreshold
+	}
+	if threshold == 0 {
+		threshold = defaultDuplicateThreshold
+	}
+
+	type first struct {
+		image  *Image
+		hash   uint64
+		hashed bool
+	}
+	var firsts []first
+	bySum := map[string]*Image{}
+	kept := make([]*Image, 0, len(images))
+	for _, img := range sortedImages(images) {
+		if ctx.Err() != nil {
+			return images
+		}
+		var of *Image
+		how := "an exact copy"
+		sum := img.Bytes.Sha256()
+		if same, ok := bySum[sum]; ok {
+			of = same
+		}
+		var hash uint64
+		hashed := false
+		if of == nil && perceptual {
+			var err error
+			hash, err = dHash(img)
+			hashed = err == nil
+			if err != nil {
+				debugln("No perceptual hash of", img.Name+":", err)
+			}
+			for _, f := range firsts {
+				if !hashed || !f.hashed {
+					continue
+				}
+				if distance := bits.OnesCount64(hash ^ f.hash); distance <= threshold {
+					of = f.image
+					how = "a near duplicate, " + strconv.Itoa(distance) + " bits apart,"
+					break
+				}
+			}
+		}
+		if of == nil {
+			bySum[sum] = img
+			firsts = append(firsts, first{img, hash, hashed})
+			kept = append(kept, img)
+			continue
+		}
+
+		result.Duplicates++
+		if prune {
+			logln(ctx, "Prune", img.Name, "as", how, "of", of.Name)
+			result.Pruned = append(result.Pruned, Pruned{Url: displaySrc(img.Src), DuplicateOf: of.Name})
+			continue
+		}
+		logln(ctx, "WARNING:", img.Name, "is", how, "of", of.Name)
+		img.DuplicateOf = of.Name
+		kept = append(kept, img)
+	}
+	return kept
+}
+
+// dHash is the difference hash of img: whether each pixel of its 9x8
+// grayscale reduction is brighter than the next one in its row.
+func dHash(img *Image) (uint64, error) {
+	config, _, err := image.DecodeConfig(img.Bytes.Reader())
+	if err != nil {
+		return 0, err
+	}
+	if maxThumbnailPixels < config.Width*config.Height {
+		return 0, errors.New("too large to decode")
+	}
+	src, _, err := image.Decode(img.Bytes.Reader())
+	if err != nil {
+		return 0, err
+	}
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), src, src.Bounds(), draw.Src, nil)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/BurntSushi/toml"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const redacted = "[redacted]"
+
+// settings are config keys and their values, as in config.toml.
+type settings map[string]interface{}
+
+// effective is what page runs with: every key of the page and of the
+// config, merged the way the settings are read, booleans set by either
+// and the rest by the page unless zero there, or unset for the keys read
+// through pointers, with defaults filled in where they are not zero.
+// Secrets are redacted. Without all, unset keys are left out. The flags
+// of the run come under "flags".
+func (s *Scraper) effective(page *Page, all bool) settings {
+	global := flatten(reflect.ValueOf(s.Config).Elem(), all)
+	merged := flatten(reflect.ValueOf(page).Elem(), all)
+	set := setPointers(reflect.ValueOf(page).Elem())
+	for key, value := range global {
+		if pageValue, ok := merged[key]; !ok || isZero(pageValue) && !set[key] {
+			merged[key] = value
+		}
+	}
+
+	if pacing := s.pacing(page); pacing.PacingMode != "" {
+		for key, value := range flatten(reflect.ValueOf(pacing), all) {
+			merged[key] = value
+		}
+	}
+	if !all {
+		for key, value := range merged {
+			if isZero(value) {
+				delete(merged, key)
+			}
+		}
+	}
+
+	flags := settings{}
+	for key, value := range map[string]interface{}{
+		"auto":          s.Auto,
+		"update":        s.Update,
+		"sample":        s.Sample,
+		"estimate":      s.Estimate,
+		"strict_limits": s.StrictLimits,
+		"no_blocklist":  s.NoBlocklist,
+		"seed":          s.Seed,
+		"fresh_session": s.FreshSession,
+		"conditional":   s.Conditional,
+	} {
+		if !isZero(value) {
+			flags[key] = value
+		}
+	}
+	if 0 < len(flags) {
+		merged["flags"] = flags
+	}
+	return merged
+}
+
+// flatten maps the fields of the struct v, and of the structs embedded
+// in it, to their values by config key. Unset pointers are left out, and
+// so is every zero value unless all.
+func flatten(v reflect.Value, all bool) settings {
+	values := settings{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Name == "Pages" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for key, value := range flatten(v.Field(i), all) {
+				values[key] = value
+			}
+			continue
+		}
+		key, ok := fieldKey(field)
+		if !ok {
+			continue
+		}
+		value, ok := plain(key, v.Field(i), all)
+		if ok && (all || !isZero(value)) {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// setPointers are the keys of the struct v, and of the structs embedded
+// in it, held by pointers that are set, zero or not.
+func setPointers(v reflect.Value) map[string]bool {
+	set := map[string]bool{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for key := range setPointers(v.Field(i)) {
+				set[key] = true
+			}
+			continue
+		}
+		if key, ok := fieldKey(field); ok && field.Type.Kind() == reflect.Ptr && !v.Field(i).IsNil() {
+			set[key] = true
+		}
+	}
+	return set
+}
+
+// fieldKey is the config key of field, false for the fields left out of
+// the config.
+func fieldKey(field reflect.StructField) (string, bool) {
+	tag := strings.Split(field.Tag.Get("toml"), ",")[0]
+	switch tag {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(field.Name), true
+	}
+	return tag, true
+}
+
+// plain is v as TOML and JSON write it, the value of key, redacted when
+// it is a secret. It is false for unset pointers.
+func plain(key string, v reflect.Value, all bool) (interface{}, bool) {
+	if secret(key) && !v.IsZero() {
+		return redacted, true
+	}
+	switch value := v.Interface().(type) {
+	case Duration:
+		return value.Duration.String(), true
+	case Size:
+		return value.Bytes, true
+	case string:
+		return redactUrl(value), true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, false
+		}
+		return plain(key, v.Elem(), all)
+	case reflect.Struct:
+		return flatten(v, all), true
+	case reflect.Slice, reflect.Array:
+		list := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if value, ok := plain(key, v.Index(i), all); ok {
+				list = append(list, value)
+			}
+		}
+		return list, true
+	case reflect.Map:
+		m := settings{}
+		iter := v.MapRange()
+		for iter.Next() {
+			name := iter.Key().String()
+			if value, ok := plain(name, iter.Value(), all); ok {
+				m[name] = value
+			}
+		}
+		return m, true
+	}
+	return v.Interface(), true
+}
+
+// secret reports whether key holds a secret, as opposed to the name of
+// the variable holding one.
+func secret(key string) bool {
+	key = strings.ToLower(key)
+	if strings.HasSuffix(key, "_env") {
+		return false
+	}
+	return strings.Contains(key, "password") || strings.Contains(key, "secret") || strings.Contains(key, "token") || key == "authorization" || key == "cookie"
+}
+
+// redactUrl hides the password of value when it is a URL with one.
+func redactUrl(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	return u.Redacted()
+}
+
+// isZero reports whether value is unset. Durations are written out, so
+// "0s" is one.
+func isZero(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero() || value == "0s"
+}
+
+// configCommand prints the effective configuration of every page, or of
+// the one of --page, as TOML or with --json as JSON.
+func configCommand(config *Config, args []string) error {
+	if len(args) == 0 || args[0] != "resolve" {
+		return usageError(errors.New("Usage: scrape-go config resolve [--page NAME] [--json]"))
+	}
+	flags := newFlagSet("config resolve")
+	name := flags.String("page", "", "print only the page of this name")
+	asJson := flags.Bool("json", false, "print JSON instead of TOML")
+	if err := parseFlags(flags, args[1:]); err != nil {
+		return err
+	}
+
+	scraper := &Scraper{Config: config}
+	resolved := settings{}
+	for i := range config.Pages {
+		page := &config.Pages[i]
+		if *name == "" || page.Name == *name {
+			resolved[page.label()] = scraper.effective(page, true)
+		}
+	}
+	if *name != "" && len(resolved) == 0 {
+		return usageError(errors.New("No page " + *name))
+	}
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(resolved)
+	}
+	return toml.NewEncoder(os.Stdout).Encode(resolved)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/term"
+	"io"
+	"os"
+)
+
+const (
+	encryptZipAes = "zip-aes"
+	encryptAge    = "age"
+)
+
+// Encryption protects saved archives. Encrypt is "zip-aes" for WinZip
+// AES-256 entries under the password of the run, or "age" to encrypt
+// whole archives to AgeRecipients, saved as <title>.zip.age.
+type Encryption struct {
+	Encrypt       string
+	AgeRecipients []string `toml:"age_recipients"`
+
+	recipients []age.Recipient
+}
+
+func (e *Encryption) compile() error {
+	switch e.Encrypt {
+	case "", encryptZipAes:
+	case encryptAge:
+		if len(e.AgeRecipients) == 0 {
+			return errors.New("encrypt: age needs age_recipients")
+		}
+	default:
+		return fmt.Errorf("encrypt: unknown method %q, expected zip-aes or age", e.Encrypt)
+	}
+	e.recipients = nil
+	for _, key := range e.AgeRecipients {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return fmt.Errorf("age_recipients: %v", err)
+		}
+		e.recipients = append(e.recipients, recipient)
+	}
+	return nil
+}
+
+// encryption is the encryption of page's archives.
+func (s *Scraper) encryption(page *Page) *Encryption {
+	if page.Encrypt != "" {
+		return &page.Encryption
+	}
+	return &s.Config.Encryption
+}
+
+// usesZipAes reports whether any archive needs the password.
+func (c *Config) usesZipAes() bool {
+	if c.Encrypt == encryptZipAes {
+		return true
+	}
+	for _, page := range c.Pages {
+		if page.Encrypt == encryptZipAes {
+			return true
+		}
+	}
+	return false
+}
+
+// readPassword sets the password of zip-aes archives from PasswordEnv
+// (default SCRAPE_GO_ZIP_PASSWORD), or else asks for it on the terminal.
+func (c *Config) readPassword() error {
+	if !c.usesZipAes() {
+		return nil
+	}
+	env := or(c.PasswordEnv, "SCRAPE_GO_ZIP_PASSWORD")
+	if password := os.Getenv(env); password != "" {
+		c.password = password
+		return nil
+	}
+	if !promptsEnabled() {
+		return errors.New("zip-aes needs a password, set $" + env)
+	}
+	// --interactive reads it as a line when stdin is not a terminal.
+	fd := int(os.Stdin.Fd())
+	read := func() ([]byte, error) {
+		if term.IsTerminal(fd) {
+			return term.ReadPassword(fd)
+		}
+		line, err := readLine()
+		return []byte(line), err
+	}
+	fmt.Fprint(os.Stderr, "Archive password: ")
+	password, err := read()
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	again, err := read()
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	if len(password) == 0 {
+		return errors.New("Empty archive password")
+	}
+	if !hmac.Equal(password, again) {
+		return errors.New("Archive passwords do not match")
+	}
+	c.password = string(password)
+	return nil
+}
+
+// ArchiveOptions are what createZip writes besides the images.
+type ArchiveOptions struct {
+	Comment string
+	// Password encrypts every entry with WinZip AES, leaving out Comment
+	// and those of the entries.
+	Password string
+	// Recipients encrypt the whole archive with age.
+	Recipients []age.Recipient
+}
+
+func (s *Scraper) archiveOptions(page *Page, result *Result) ArchiveOptions {
+	opts := ArchiveOptions{Comment: s.zipComment(page, result)}
+	switch e := s.encryption(page); e.Encrypt {
+	case encryptZipAes:
+		opts.Password = s.Config.password
+	case encryptAge:
+		opts.Recipients = e.recipients
+	}
+	return opts
+}
+
+// encryptedPath is path with the suffix of page's encryption.
+func (s *Scraper) encryptedPath(page *Page, path string) string {
+	if s.encryption(page).Encrypt == encryptAge {
+		return path + ".age"
+	}
+	return path
+}
+
+// WinZip AES, as described in https://www.winzip.com/en/support/aes-encryption/
+const (
+	zipAesMethod   = 99
+	zipAesExtraId  = 0x9901
+	zipAesSaltLen  = 16 // AES-256
+	zipAesKeyLen   = 32
+	zipAesMacLen   = 10
+	zipAesRounds   = 1000
+	zipEncryptFlag = 0x1
+)
+
+// createAesEntry deflates r into w as an AE-2 entry under password.
+// AE-2 leaves the CRC out, as the authentication code covers it.
+func createAesEntry(ctx context.Context, w *zip.Writer, fh *zip.FileHeader, r io.Reader, password string) error {
+	deflated := spoolBody(ctx)
+	defer deflated.Close()
+	fw, err := flate.NewWriter(deflated, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(fw, r)
+	if err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	salt := make([]byte, zipAesSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	keys := pbkdf2.Key([]byte(password), salt, zipAesRounds, 2*zipAesKeyLen+2, sha1.New)
+	block, err := aes.NewCipher(keys[:zipAesKeyLen])
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha1.New, keys[zipAesKeyLen:2*zipAesKeyLen])
+
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], zipAesExtraId)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], 2) // AE-2
+	copy(extra[6:], "AE")
+	extra[8] = 3 // AES-256
+	binary.LittleEndian.PutUint16(extra[9:], zip.Deflate)
+
+	fh.SetModTime(fh.Modified)
+	fh.Method = zipAesMethod
+	fh.Flags |= zipEncryptFlag
+	fh.Extra = append(fh.Extra, extra...)
+	fh.CRC32 = 0
+	fh.UncompressedSize64 = uint64(n)
+	fh.CompressedSize64 = uint64(zipAesSaltLen + 2 + deflated.Len() + zipAesMacLen)
+	raw, err := w.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+	if _, err := raw.Write(salt); err != nil {
+		return err
+	}
+	if _, err := raw.Write(keys[2*zipAesKeyLen:]); err != nil {
+		return err
+	}
+	encrypted := cipher.StreamWriter{S: newZipAesCtr(block), W: io.MultiWriter(raw, mac)}
+	if _, err := io.Copy(encrypted, deflated.Reader()); err != nil {
+		return err
+	}
+	_, err = raw.Write(mac.Sum(nil)[:zipAesMacLen])
+	return err
+}
+
+// zipAesCtr is CTR mode with the little-endian counter, starting at 1,
+// that WinZip uses instead of the big-endian one of cipher.NewCTR.
+type zipAesCtr struct {
+	block   cipher.Block
+	counter uint64
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newZipAesCtr(block cipher.Block) *zipAesCtr {
+	return &zipAesCtr{block: block, used: aes.BlockSize}
+}
+
+func (c *zipAesCtr) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			c.counter++
+			var in [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(in[:], c.counter)
+			c.block.Encrypt(c.stream[:], in[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/yeka/zip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// zip-aes archives open with another WinZip AES implementation than
+// createAesEntry.
+func TestZipAesDecrypts(t *testing.T) {
+	images := []*Image{
+		{Name: "0-a.jpg", Bytes: newBody([]byte("image"))},
+		{Name: "1-b.jpg", Bytes: newBody(bytes.Repeat([]byte("a longer image, over a block "), 100))},
+		{Name: "2-empty.jpg", Bytes: newBody(nil)},
+	}
+	opts := ArchiveOptions{Comment: "https://example.com/gallery/1", Password: "secret"}
+	body, err := createZip(context.Background(), images, time.Now(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	b := readAll(t, body)
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != len(images) {
+		t.Fatalf("%d entries, want %d", len(r.File), len(images))
+	}
+	for i, f := range r.File {
+		if !f.IsEncrypted() {
+			t.Errorf("%s not encrypted", f.Name)
+		}
+		f.SetPassword(opts.Password)
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if want := readAll(t, images[i].Bytes); !bytes.Equal(got, want) {
+			t.Errorf("%s: %d bytes, want %d", f.Name, len(got), len(want))
+		}
+	}
+
+	// The comments are not encrypted, and are left out not to give away
+	// the URL.
+	if r.Comment != "" || bytes.Contains(b, []byte("example.com")) {
+		t.Errorf("comment %q in the clear", r.Comment)
+	}
+
+	r.File[0].SetPassword("wrong")
+	if rc, err := r.File[0].Open(); err == nil {
+		_, err = io.ReadAll(rc)
+		rc.Close()
+		if err == nil || !strings.Contains(err.Error(), "password") {
+			t.Errorf("read with the wrong password: %v", err)
+		}
+	}
+}
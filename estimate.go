@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Estimate is the size of a page's images according to their headers.
+type Estimate struct {
+	Count int
+	// Bytes sums the images that reported a length, Unknown counts the
+	// others.
+	Bytes   int64
+	Unknown int
+}
+
+func (e Estimate) String() string {
+	s := strconv.Itoa(e.Count) + " images, " + formatBytes(e.Bytes)
+	if 0 < e.Unknown {
+		s += " + " + strconv.Itoa(e.Unknown) + " of unknown size"
+	}
+	return s
+}
+
+// contentLength asks for the size of src without downloading it. Servers
+// that reject HEAD are asked for the first byte instead, whose
+// Content-Range carries the full length.
+func contentLength(ctx context.Context, client *http.Client, src string) int64 {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", src, nil)
+	if err != nil {
+		return -1
+	}
+	res, err := client.Do(req)
+	if err == nil {
+		res.Body.Close()
+		if res.StatusCode < 400 {
+			return res.ContentLength
+		}
+	}
+
+	req, err = http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return -1
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	res, err = client.Do(req)
+	if err != nil {
+		return -1
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes 0-0/12345
+		contentRange := res.Header.Get("Content-Range")
+		n, err := strconv.ParseInt(contentRange[strings.LastIndex(contentRange, "/")+1:], 10, 64)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+	if res.StatusCode < 400 {
+		return res.ContentLength
+	}
+	return -1
+}
+
+// estimateSize asks for the length of every src at once.
+func estimateSize(ctx context.Context, client *http.Client, srcs []string) Estimate {
+	estimate := Estimate{Count: len(srcs)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, src := range srcs {
+		if isDataUri(src) {
+			estimate.Bytes += int64(len(src))
+			continue
+		}
+		wg.Add(1)
+		go func(src string) {
+			defer wg.Done()
+			size := contentLength(ctx, client, src)
+			debugln("Size", src, size)
+			mu.Lock()
+			defer mu.Unlock()
+			if size < 0 {
+				estimate.Unknown++
+			} else {
+				estimate.Bytes += size
+			}
+		}(src)
+	}
+	wg.Wait()
+	return estimate
+}
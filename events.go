@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Event is something that happened in a scrape: one of the types below,
+// each carrying the Url of the page it belongs to.
+type Event interface {
+	event()
+}
+
+// PageStarted is sent as a scrape of Url begins.
+type PageStarted struct {
+	Page string
+	Url  string
+}
+
+// TitleResolved is sent once the title, and so the archive name, of the
+// page is known.
+type TitleResolved struct {
+	Url   string
+	Title string
+}
+
+// ImageQueued is sent for every image to download, before any starts.
+type ImageQueued struct {
+	Url   string
+	Index int
+	Src   string
+}
+
+// ImageStarted is sent as the download of an image begins.
+type ImageStarted struct {
+	Url   string
+	Index int
+	Src   string
+}
+
+// ImageProgress is sent as the body of an image is read. Bytes is what
+// has been read so far, Total the Content-Length or 0 when unknown.
+type ImageProgress struct {
+	Url   string
+	Index int
+	Src   string
+	Bytes int64
+	Total int64
+}
+
+// ImageDone is sent for every image downloaded, or taken from the store
+// when Stored.
+type ImageDone struct {
+	Url      string
+	Index    int
+	Src      string
+	Bytes    int64
+	Stored   bool
+	Snapshot *Snapshot
+}
+
+// ImageFailed is sent for every image given up on.
+type ImageFailed struct {
+	Url   string
+	Index int
+	Src   string
+	Err   error
+}
+
+// ArchiveWritten is sent once the archive of the page is saved at Path.
+type ArchiveWritten struct {
+	Url    string
+	Path   string
+	Images int
+	Bytes  int64
+}
+
+func (PageStarted) event()    {}
+func (TitleResolved) event()  {}
+func (ImageQueued) event()    {}
+func (ImageStarted) event()   {}
+func (ImageProgress) event()  {}
+func (ImageDone) event()      {}
+func (ImageFailed) event()    {}
+func (ArchiveWritten) event() {}
+
+// eventSink is where the events of one scrape go: the log, the Progress
+// of the scrape and Scraper.Events.
+type eventSink struct {
+	url      string
+	progress *Progress
+	handler  func(Event)
+}
+
+type eventsKey struct{}
+
+func withEvents(ctx context.Context, sink *eventSink) context.Context {
+	return context.WithValue(ctx, eventsKey{}, sink)
+}
+
+// pageUrl is the url of the scrape of ctx.
+func pageUrl(ctx context.Context) string {
+	if sink, ok := ctx.Value(eventsKey{}).(*eventSink); ok {
+		return sink.url
+	}
+	return ""
+}
+
+// emit sends event to every handler of the scrape of ctx.
+func emit(ctx context.Context, event Event) {
+	logEvent(ctx, event)
+	sink, ok := ctx.Value(eventsKey{}).(*eventSink)
+	if !ok {
+		return
+	}
+	sink.progress.Handle(event)
+	if sink.handler != nil {
+		sink.handler(event)
+	}
+}
+
+// logEvent is the handler that writes the log of a scrape.
+func logEvent(ctx context.Context, event Event) {
+	switch e := event.(type) {
+	case ImageStarted:
+		logln(ctx, "START", "[", e.Index, "]", displaySrc(e.Src))
+	case ImageDone:
+		if e.Stored {
+			logln(ctx, "STORED", "[", e.Index, "]", displaySrc(e.Src))
+		} else {
+			logln(ctx, "DONE", "[", e.Index, "]", displaySrc(e.Src))
+		}
+	case ImageFailed:
+		logln(ctx, "FAILED", "[", e.Index, "]", displaySrc(e.Src), e.Err)
+	case ArchiveWritten:
+		logln(ctx, "Saved", e.Path)
+	}
+}
+
+// Handle counts event into p.
+func (p *Progress) Handle(event Event) {
+	if p == nil {
+		return
+	}
+	switch e := event.(type) {
+	case ImageQueued:
+		atomic.AddInt64(&p.total, 1)
+	case ImageDone:
+		atomic.AddInt64(&p.done, 1)
+		atomic.AddInt64(&p.bytes, e.Bytes)
+	case ImageFailed:
+		atomic.AddInt64(&p.failed, 1)
+	}
+}
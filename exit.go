@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// Exit codes, see exitCodesHelp.
+const (
+	exitOK          = 0
+	exitUsage       = 1
+	exitFailed      = 2
+	exitPartial     = 3
+	exitInterrupted = 4
+)
+
+const exitCodesHelp = `
+Exit codes:
+  0  every page was scraped or skipped
+  1  usage or config error
+  2  every page failed
+  3  some pages failed
+  4  interrupted
+`
+
+// exitError is an error that ends the process with Code instead of the
+// default exitFailed.
+type exitError struct {
+	Code int
+	Err  error
+}
+
+func (e *exitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.Err
+}
+
+func usageError(err error) error {
+	return &exitError{Code: exitUsage, Err: err}
+}
+
+func exitCode(err error) int {
+	var e *exitError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	default:
+		return exitFailed
+	}
+}
+
+// newFlagSet returns a flag set whose parse errors are returned as usage
+// errors instead of exiting with flag's own code 2, which is taken.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage of "+name+":")
+		flags.PrintDefaults()
+		fmt.Fprint(flags.Output(), exitCodesHelp)
+	}
+	return flags
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil && err != flag.ErrHelp {
+		return usageError(err)
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Extract describes how to find image URLs inside a script element: either
+// a regular expression whose first capture group is the URL, or a JSON path
+// such as "data.pages[*].url" into the JSON value embedded in the script.
+type Extract struct {
+	Regex    string
+	JsonPath string `toml:"json_path"`
+
+	regex *regexp.Regexp
+	path  []pathStep
+}
+
+// pathStep is one segment of a JSON path: a key, an index, or a wildcard.
+type pathStep struct {
+	Key      string
+	Index    int
+	Wildcard bool
+}
+
+var stepPattern = regexp.MustCompile(`^([^\[\]]*)((?:\[(?:\*|\d+)\])*)$`)
+var subscriptPattern = regexp.MustCompile(`\[(\*|\d+)\]`)
+
+func parseJsonPath(path string) ([]pathStep, error) {
+	var steps []pathStep
+	for _, part := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		m := stepPattern.FindStringSubmatch(part)
+		if m == nil || (m[1] == "" && m[2] == "") {
+			return nil, errors.New("invalid json_path segment " + strconv.Quote(part))
+		}
+		if m[1] != "" {
+			steps = append(steps, pathStep{Key: m[1], Index: -1})
+		}
+		for _, sub := range subscriptPattern.FindAllStringSubmatch(m[2], -1) {
+			if sub[1] == "*" {
+				steps = append(steps, pathStep{Index: -1, Wildcard: true})
+			} else {
+				n, _ := strconv.Atoi(sub[1])
+				steps = append(steps, pathStep{Index: n})
+			}
+		}
+	}
+	return steps, nil
+}
+
+func (e *Extract) compile() error {
+	switch {
+	case e.Regex != "" && e.JsonPath != "":
+		return errors.New("set either regex or json_path, not both")
+	case e.Regex != "":
+		re, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return err
+		}
+		if re.NumSubexp() < 1 {
+			return errors.New("regex needs a capture group for the URL")
+		}
+		e.regex = re
+	case e.JsonPath != "":
+		path, err := parseJsonPath(e.JsonPath)
+		if err != nil {
+			return err
+		}
+		e.path = path
+	default:
+		return errors.New("set regex or json_path")
+	}
+	return nil
+}
+
+// evaluate applies path to v and returns every value it reaches.
+func evaluate(v interface{}, path []pathStep) []interface{} {
+	values := []interface{}{v}
+	for _, step := range path {
+		var next []interface{}
+		for _, value := range values {
+			switch {
+			case step.Key != "":
+				if m, ok := value.(map[string]interface{}); ok {
+					if child, ok := m[step.Key]; ok {
+						next = append(next, child)
+					}
+				}
+			case step.Wildcard:
+				switch c := value.(type) {
+				case []interface{}:
+					next = append(next, c...)
+				case map[string]interface{}:
+					for _, child := range c {
+						next = append(next, child)
+					}
+				}
+			default:
+				if a, ok := value.([]interface{}); ok && step.Index < len(a) {
+					next = append(next, a[step.Index])
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// decodeEmbeddedJson decodes the first JSON object or array in text, which
+// lets assignments like "window.__DATA__ = {...};" through.
+func decodeEmbeddedJson(text string) (interface{}, error) {
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return nil, errors.New("no JSON found in script")
+	}
+	var v interface{}
+	err := json.NewDecoder(strings.NewReader(text[start:])).Decode(&v)
+	return v, err
+}
+
+// Apply extracts the image URLs from the text of each matched element.
+func (e *Extract) Apply(scripts *goquery.Selection) ([]string, error) {
+	var srcs []string
+	var failure error
+	scripts.Each(func(i int, el *goquery.Selection) {
+		text := el.Text()
+		if e.regex != nil {
+			for _, m := range e.regex.FindAllStringSubmatch(text, -1) {
+				srcs = append(srcs, m[1])
+			}
+			return
+		}
+		v, err := decodeEmbeddedJson(text)
+		if err != nil {
+			failure = err
+			return
+		}
+		for _, value := range evaluate(v, e.path) {
+			if s, ok := value.(string); ok {
+				srcs = append(srcs, s)
+			}
+		}
+	})
+	if len(srcs) == 0 {
+		if failure != nil {
+			return nil, fmt.Errorf("extract: %v", failure)
+		}
+		return nil, errors.New("extract: no image URLs found in " + strconv.Itoa(scripts.Length()) + " matched elements")
+	}
+	return srcs, nil
+}
+
+// resolveSrcs makes srcs absolute against base, the URL of the document
+// they came from, and drops empty ones.
+func resolveSrcs(base *url.URL, srcs []string) []string {
+	results := make([]string, 0, len(srcs))
+	for _, src := range srcs {
+		src = strings.TrimSpace(src)
+		if src == "" {
+			continue
+		}
+		if base != nil && !isDataUri(src) {
+			if u, err := base.Parse(src); err == nil {
+				src = u.String()
+			}
+		}
+		results = append(results, src)
+	}
+	return results
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var errImageTimeout = errors.New("Image not downloaded within image_fetch.timeout")
+
+// FetchPolicy is how one kind of request is made: at most Concurrency at
+// once over the run, Delay apart per host, retried Retries times
+// RetryDelay apart, doubled on each attempt, and each given Timeout.
+// Zero values, and Retries left out, keep the behavior of the older keys.
+type FetchPolicy struct {
+	Concurrency int      `toml:"concurrency"`
+	Delay       Duration `toml:"delay"`
+	Retries     *int     `toml:"retries"`
+	RetryDelay  Duration `toml:"retry_delay"`
+	Timeout     Duration `toml:"timeout"`
+}
+
+func (p FetchPolicy) validate() error {
+	if p.Concurrency < 0 || p.Delay.Duration < 0 || p.Retries != nil && *p.Retries < 0 || p.RetryDelay.Duration < 0 || p.Timeout.Duration < 0 {
+		return errors.New("concurrency, delay, retries, retry_delay and timeout must not be negative")
+	}
+	return nil
+}
+
+// Fetching separates the document fetches, under PagesFetch, from the
+// image downloads, under ImageFetch, so pages can be fetched slowly while
+// images come fast from a CDN. MaxPerHost caps the requests of both
+// kinds in flight to one host together, and MaxInflightBytes the bytes
+// of the images downloading at once; see byteBudget.
+type Fetching struct {
+	PagesFetch       FetchPolicy `toml:"pages_fetch"`
+	ImageFetch       FetchPolicy `toml:"image_fetch"`
+	MaxPerHost       int         `toml:"max_per_host"`
+	MaxInflightBytes Size        `toml:"max_inflight_bytes"`
+	// InflightEstimate is reserved for the images of unknown length, 4 MB
+	// by default.
+	InflightEstimate Size `toml:"inflight_estimate"`
+}
+
+// validateFetching checks the Fetching of c and makes its retries and
+// timeouts those of the keys they stand for, page_retries,
+// page_retry_delay, document_timeout and image_retries, which pages
+// still override.
+func (c *Config) validateFetching() error {
+	f := c.Fetching
+	if err := f.PagesFetch.validate(); err != nil {
+		return fmt.Errorf("pages_fetch: %v", err)
+	}
+	if err := f.ImageFetch.validate(); err != nil {
+		return fmt.Errorf("image_fetch: %v", err)
+	}
+	if f.MaxPerHost < 0 {
+		return errors.New("max_per_host must not be negative")
+	}
+	if f.MaxInflightBytes.Bytes < 0 || f.InflightEstimate.Bytes < 0 {
+		return errors.New("max_inflight_bytes and inflight_estimate must not be negative")
+	}
+	disagree := func(key, section string) error {
+		return errors.New(key + " and " + section + " disagree")
+	}
+	if retries := f.PagesFetch.Retries; retries != nil {
+		if c.PageRetries != nil && *c.PageRetries != *retries {
+			return disagree("page_retries", "pages_fetch.retries")
+		}
+		c.PageRetries = retries
+	}
+	if delay := f.PagesFetch.RetryDelay; delay.Duration != 0 {
+		if c.PageRetryDelay.Duration != 0 && c.PageRetryDelay != delay {
+			return disagree("page_retry_delay", "pages_fetch.retry_delay")
+		}
+		c.PageRetryDelay = delay
+	}
+	if timeout := f.PagesFetch.Timeout; timeout.Duration != 0 {
+		if c.DocumentTimeout.Duration != 0 && c.DocumentTimeout != timeout {
+			return disagree("document_timeout", "pages_fetch.timeout")
+		}
+		c.DocumentTimeout = timeout
+	}
+	if retries := f.ImageFetch.Retries; retries != nil {
+		if c.ImageRetries != nil && *c.ImageRetries != *retries {
+			return disagree("image_retries", "image_fetch.retries")
+		}
+		c.ImageRetries = retries
+	}
+	return nil
+}
+
+// imageRetryDelay is the wait before the first retry of an image.
+func (s *Scraper) imageRetryDelay() time.Duration {
+	if delay := s.Config.ImageFetch.RetryDelay.Duration; delay != 0 {
+		return delay
+	}
+	return defaultImageRetryDelay
+}
+
+// fetchPool bounds one kind of request.
+type fetchPool struct {
+	slots chan struct{}
+	delay time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newFetchPool(policy FetchPolicy) *fetchPool {
+	p := &fetchPool{delay: policy.Delay.Duration, next: map[string]time.Time{}}
+	if 0 < policy.Concurrency {
+		p.slots = make(chan struct{}, policy.Concurrency)
+	}
+	return p
+}
+
+// wait waits until the last request of p to host is delay behind.
+func (p *fetchPool) wait(ctx context.Context, host string) error {
+	if p.delay == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	now := time.Now()
+	start := p.next[host]
+	if start.Before(now) {
+		start = now
+	}
+	p.next[host] = start.Add(p.delay)
+	p.mu.Unlock()
+	return sleep(ctx, time.Until(start))
+}
+
+// fetchers are the pools of the documents and the images of a run, and
+// the per-host slots they share.
+type fetchers struct {
+	documents *fetchPool
+	images    *fetchPool
+	perHost   int
+	inflight  *byteBudget
+	throttle  *throttles
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// fetchers returns the fetchers of s, made the first time.
+func (s *Scraper) fetchers() *fetchers {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fetch == nil {
+		s.fetch = &fetchers{
+			documents: newFetchPool(s.Config.PagesFetch),
+			images:    newFetchPool(s.Config.ImageFetch),
+			perHost:   s.Config.MaxPerHost,
+			inflight:  newByteBudget(s.Config.MaxInflightBytes.Bytes, s.Config.InflightEstimate.Bytes),
+			throttle:  newThrottles(s.Config.Throttle),
+			hosts:     map[string]chan struct{}{},
+		}
+	}
+	return s.fetch
+}
+
+func (f *fetchers) host(host string) chan struct{} {
+	if f.perHost == 0 {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	slots, ok := f.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, f.perHost)
+		f.hosts[host] = slots
+	}
+	return slots
+}
+
+// acquire waits for a slot of pool and of the host of rawurl, then for
+// the delay of pool and the throttle of the host, and returns the
+// function releasing the slots.
+func (f *fetchers) acquire(ctx context.Context, pool *fetchPool, rawurl string) (func(), error) {
+	host := ""
+	if u, err := url.Parse(rawurl); err == nil {
+		host = u.Hostname()
+	}
+	var held []chan struct{}
+	release := func() {
+		for _, slots := range held {
+			<-slots
+		}
+	}
+	for _, slots := range []chan struct{}{pool.slots, f.host(host)} {
+		if slots == nil {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			held = append(held, slots)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	if err := pool.wait(ctx, host); err != nil {
+		release()
+		return nil, err
+	}
+	done, err := f.throttle.acquire(ctx, host)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return func() { done(); release() }, nil
+}
+
+type fetchersKey struct{}
+
+func withFetchers(ctx context.Context, f *fetchers) context.Context {
+	return context.WithValue(ctx, fetchersKey{}, f)
+}
+
+// acquireDocument waits for the turn of the document rawurl under the
+// fetchers of ctx, if any.
+func acquireDocument(ctx context.Context, rawurl string) (func(), error) {
+	f, ok := ctx.Value(fetchersKey{}).(*fetchers)
+	if !ok {
+		return func() {}, nil
+	}
+	return f.acquire(ctx, f.documents, rawurl)
+}
+
+// imageContext waits for the turn of the image src under the fetchers of
+// s and bounds its download by image_fetch.timeout. The returned
+// function ends the download.
+func (s *Scraper) imageContext(ctx context.Context, src string) (context.Context, func(), error) {
+	f := s.fetchers()
+	release, err := f.acquire(ctx, f.images, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	timeout := s.Config.ImageFetch.Timeout.Duration
+	if timeout == 0 {
+		return ctx, release, nil
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, errImageTimeout)
+	return ctx, func() { cancel(); release() }, nil
+}
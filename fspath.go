@@ -0,0 +1,23 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// downloadsDir holds the archives, relative to the working directory.
+const downloadsDir = "downloads"
+
+// maxNameBytes keeps archive names, with the suffixes of samples and
+// collisions, under the 255 bytes file systems allow per name.
+const maxNameBytes = 230
+
+// archivePath is where the archive named name is saved. Slashes in name,
+// from the literal text of a filename template, make directories.
+func archivePath(name string) string {
+	components := strings.Split(filepath.ToSlash(name), "/")
+	for i, component := range components {
+		components[i] = safeComponent(truncate(component, maxNameBytes))
+	}
+	return platformPath(filepath.Join(downloadsDir, filepath.Join(components...)+".zip"))
+}
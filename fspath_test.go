@@ -0,0 +1,26 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchivePathTruncatesComponents(t *testing.T) {
+	long := strings.Repeat("あ", 100)
+	p := archivePath("series/" + long)
+	dir, file := filepath.Split(p)
+	if filepath.Base(dir) != "series" {
+		t.Errorf("directory of %s is not series", p)
+	}
+	name := strings.TrimSuffix(file, ".zip")
+	if maxNameBytes < len(name) {
+		t.Errorf("name of %d bytes, over %d", len(name), maxNameBytes)
+	}
+	if !strings.HasPrefix(name, strings.Repeat("あ", 10)) {
+		t.Errorf("name %q does not keep the start of the title", name)
+	}
+	if archivePath("series/"+long+"!") == p {
+		t.Error("titles differing past the cut share a path")
+	}
+}
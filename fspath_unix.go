@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// sanitizePlatform leaves s as it is, any byte but "/" being fine in a
+// name here.
+func sanitizePlatform(s string) string {
+	return s
+}
+
+func safeComponent(name string) string {
+	if name == "" || name == "." || name == ".." {
+		return "_" + name
+	}
+	return name
+}
+
+func platformPath(path string) string {
+	return path
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "testing"
+
+func TestSanitizeUnix(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"CON", "CON"},
+		{"nul.txt", "nul.txt"},
+		{"title. ", "title. "},
+		{`a<b>c:d"e\f|g?h*`, `a<b>c:d"e\f|g?h*`},
+		{"tab\there", "tab\there"},
+		{"..", "_.."},
+		{".", "_."},
+		{"", "_"},
+		{"...", "..."},
+	}
+	for _, test := range tests {
+		if got := safeComponent(sanitizePlatform(test.name)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var invalidNameChars = strings.NewReplacer(
+	`<`, "_", `>`, "_", `:`, "_", `"`, "_", `\`, "_", `|`, "_", `?`, "_", `*`, "_",
+)
+
+// reservedName matches the device names Windows reserves with or without
+// an extension.
+var reservedName = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9]|lpt[0-9])(\..*)?$`)
+
+// sanitizePlatform replaces the characters Windows forbids in names.
+func sanitizePlatform(s string) string {
+	s = invalidNameChars.Replace(s)
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// safeComponent drops the trailing dots and spaces Windows strips from
+// names and prefixes reserved device names.
+func safeComponent(name string) string {
+	name = strings.TrimRight(name, ". ")
+	if name == "" || reservedName.MatchString(name) {
+		return "_" + name
+	}
+	return name
+}
+
+// platformPath makes path absolute, which lets the os package lift the
+// MAX_PATH limit with the \\?\ prefix for long paths.
+func platformPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestSanitizeWindows(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"CON", "_CON"},
+		{"con", "_con"},
+		{"NUL.txt", "_NUL.txt"},
+		{"COM1", "_COM1"},
+		{"lpt9.zip", "_lpt9.zip"},
+		{"CONSOLE", "CONSOLE"},
+		{"title. ", "title"},
+		{"title...", "title"},
+		{"CON. ", "_CON"},
+		{`a<b>c:d"e\f|g?h*`, "a_b_c_d_e_f_g_h_"},
+		{"tab\there", "tab_here"},
+		{"..", "_"},
+		{".", "_"},
+		{"", "_"},
+	}
+	for _, test := range tests {
+		if got := safeComponent(sanitizePlatform(test.name)); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+)
+
+// GateError is a document that is not the gallery but the page in its
+// way: a paywall, a login form, the home page of a silent redirect.
+type GateError struct {
+	Url    string
+	Reason string
+}
+
+func (e *GateError) Error() string {
+	return e.Reason + " at " + e.Url
+}
+
+// gate fails doc when abort_if_selector matches it or require_selector
+// does not.
+func (p *Page) gate(doc *goquery.Document, url string) error {
+	if p.AbortIfSelector != "" && 0 < find(doc, p.AbortIfSelector).Length() {
+		return &GateError{Url: url, Reason: "blocker matched: " + p.AbortIfSelector}
+	}
+	if p.RequireSelector != "" && find(doc, p.RequireSelector).Length() == 0 {
+		return &GateError{Url: url, Reason: "required element missing: " + p.RequireSelector}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decodableEncodings are the content codings headerTransport can undo
+// once accept_encoding takes decompression away from net/http.
+var decodableEncodings = map[string]bool{"gzip": true, "x-gzip": true, "deflate": true, "identity": true, "*": true}
+
+func validateAcceptEncoding(accept string) error {
+	for _, part := range strings.Split(accept, ",") {
+		coding := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		if coding != "" && !decodableEncodings[coding] {
+			return errors.New("accept_encoding: cannot decode " + coding + ", expected gzip, deflate or identity")
+		}
+	}
+	return nil
+}
+
+// headerTransport sets Accept, Accept-Language and Accept-Encoding on
+// every request that has none. With Accept-Encoding set net/http leaves
+// responses compressed, so it decodes them itself.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, value)
+		}
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil || t.headers["Accept-Encoding"] == "" || req.Method == "HEAD" || res.ContentLength == 0 {
+		return res, err
+	}
+	var body io.ReadCloser
+	switch strings.ToLower(res.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		body = decodedBody{zr, res.Body}
+	case "deflate":
+		// HTTP's deflate is zlib, not raw DEFLATE.
+		zr, err := zlib.NewReader(res.Body)
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		body = decodedBody{zr, res.Body}
+	}
+	if body != nil {
+		res.Body = body
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Uncompressed = true
+	}
+	return res, nil
+}
+
+type decodedBody struct {
+	io.Reader
+	raw io.Closer
+}
+
+func (b decodedBody) Close() error {
+	return b.raw.Close()
+}
+
+// headerTransport wraps base with the accept headers of page, each taken
+// from the page or else the global config.
+func (s *Scraper) headerTransport(page *Page, base http.RoundTripper) http.RoundTripper {
+	global := &s.Config.Transport
+	headers := make(map[string]string)
+	for name, value := range map[string]string{
+		"Accept":          or(page.Accept, global.Accept),
+		"Accept-Language": or(page.AcceptLanguage, global.AcceptLanguage),
+		"Accept-Encoding": or(page.AcceptEncoding, global.AcceptEncoding),
+	} {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	if len(headers) == 0 {
+		return base
+	}
+	return &headerTransport{base: base, headers: headers}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyFile gets a line for every page scraped, by any run, for good.
+var historyFile = filepath.Join(downloadsDir, "history.jsonl")
+
+// historyEntry is a line of historyFile.
+type historyEntry struct {
+	Time time.Time `json:"time"`
+	// Action is scrape, update, repair or resume.
+	Action   string        `json:"action"`
+	Page     string        `json:"page,omitempty"`
+	Url      string        `json:"url"`
+	Path     string        `json:"path,omitempty"`
+	Images   int           `json:"images"`
+	Failed   int           `json:"failed"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// FailedImages are the images left out of the archive, and why.
+	FailedImages []failedImage `json:"failed_images,omitempty"`
+}
+
+type failedImage struct {
+	Url   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// recordHistory appends result, and err if it failed, to historyFile
+// under a lock, so concurrent runs do not interleave their lines.
+func (s *Scraper) recordHistory(result *Result, err error) error {
+	entry := historyEntry{
+		Time:     result.Started.UTC(),
+		Action:   "scrape",
+		Page:     result.Page,
+		Url:      result.Url,
+		Images:   result.Images,
+		Failed:   result.Failed,
+		Skipped:  result.Skipped,
+		Bytes:    result.Bytes,
+		Duration: result.Duration,
+	}
+	if s.Repair != "" {
+		entry.Action = "repair"
+	} else if s.Resume != nil {
+		entry.Action = "resume"
+	} else if s.Update {
+		entry.Action = "update"
+	}
+	if !result.Skipped && err == nil {
+		entry.Path = result.Path
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	for _, f := range result.Files {
+		if f.Error != "" {
+			entry.FailedImages = append(entry.FailedImages, failedImage{Url: f.Url, Error: f.Error})
+		}
+	}
+	line, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	if err := os.MkdirAll(filepath.Dir(historyFile), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// historyUrls maps every path historyFile records saving to the URL of
+// the page last saved there.
+func historyUrls() map[string]string {
+	urls := map[string]string{}
+	f, err := os.Open(historyFile)
+	if err != nil {
+		return urls
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry historyEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Path != "" {
+			urls[entry.Path] = entry.Url
+		}
+	}
+	return urls
+}
+
+// parseSince reads --since: a duration back from now such as 36h or 7d,
+// a date, or a time in RFC 3339.
+func parseSince(value string) (time.Time, error) {
+	if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && strings.HasSuffix(value, "d") {
+		return time.Now().AddDate(0, 0, -days), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("--since: " + strconv.Quote(value) + " is not a duration, a date or an RFC 3339 time")
+}
+
+// history prints the lines of historyFile matching the filters of args.
+func history(config *Config, args []string) error {
+	flags := newFlagSet("history")
+	name := flags.String("page", "", "only the pages of this name")
+	sinceFlag := flags.String("since", "", "only since this long ago (36h, 7d), this date, or this RFC 3339 time")
+	failed := flags.Bool("failed", false, "only the pages that failed")
+	asJson := flags.Bool("json", false, "print the matching lines as they are")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if 0 < flags.NArg() {
+		return usageError(errors.New("Usage: scrape-go history [--page NAME] [--since WHEN] [--failed] [--json]"))
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = parseSince(*sinceFlag); err != nil {
+			return usageError(err)
+		}
+	}
+
+	f, err := os.Open(historyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			fmt.Fprintln(os.Stderr, "WARNING:", historyFile+":"+strconv.Itoa(n)+":", err)
+			continue
+		}
+		if (*name != "" && entry.Page != *name) || entry.Time.Before(since) || (*failed && entry.Error == "") {
+			continue
+		}
+		if *asJson {
+			fmt.Println(scanner.Text())
+			continue
+		}
+		fmt.Println(entry.String())
+	}
+	return scanner.Err()
+}
+
+func (e historyEntry) String() string {
+	line := e.Time.Local().Format("2006-01-02 15:04:05") + " " + e.Action + " " + or(e.Page, "-") + " " + e.Url
+	switch {
+	case e.Error != "":
+		line += " FAILED: " + e.Error
+	case e.Skipped:
+		line += " skipped"
+	default:
+		line += " → " + e.Path + ", " + strconv.Itoa(e.Images) + " images, " + formatBytes(e.Bytes)
+	}
+	if 0 < e.Failed {
+		line += ", " + strconv.Itoa(e.Failed) + " failed"
+	}
+	line += " in " + e.Duration.Round(time.Millisecond).String()
+	for _, image := range e.FailedImages {
+		line += "\n    " + image.Url + ": " + image.Error
+	}
+	return line
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHistoryFailedImages(t *testing.T) {
+	scraper, page := replayScraper(t, "errors")
+	if _, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/7", nil); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry historyEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Failed != 2 || len(entry.FailedImages) != 2 {
+		t.Fatalf("%d failed, failed images %+v", entry.Failed, entry.FailedImages)
+	}
+	missing := entry.FailedImages[0]
+	if missing.Url != "https://gallery.example/i/7/2.jpg" || !strings.Contains(missing.Error, "Not Found") {
+		t.Errorf("first failed image = %+v", missing)
+	}
+	if line := entry.String(); !strings.Contains(line, "\n    https://gallery.example/i/7/2.jpg: ") {
+		t.Errorf("history line does not list the failed image:\n%s", line)
+	}
+}
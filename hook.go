@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// hook returns the page's command, or the global one when the page has
+// none.
+func (s *Scraper) hook(page []string, global []string) []string {
+	if 0 < len(page) {
+		return page
+	}
+	if 0 < len(global) {
+		return global
+	}
+	return nil
+}
+
+func hookVars(page *Page, url string, result *Result) map[string]string {
+	return map[string]string{
+		"path":      result.Path,
+		"title":     result.Title,
+		"url":       url,
+		"page_name": page.Name,
+	}
+}
+
+func logLines(ctx context.Context, wg *sync.WaitGroup, r io.Reader, prefix string) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logln(ctx, prefix, scanner.Text())
+	}
+}
+
+// runHook runs argv with its placeholders expanded. Stdout is logged as
+// is, stderr with an ERROR prefix; a non-zero exit is an error.
+func runHook(ctx context.Context, name string, argv []string, vars map[string]string) error {
+	args := make([]string, len(argv))
+	for i, arg := range argv {
+		args[i] = expandTemplate(arg, vars)
+	}
+	logln(ctx, "Run", name, strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.New(name + ": " + err.Error())
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go logLines(ctx, &wg, stdout, "["+name+"]")
+	go logLines(ctx, &wg, stderr, "["+name+"] ERROR")
+	wg.Wait()
+	if err := cmd.Wait(); err != nil {
+		return errors.New(name + " " + args[0] + ": " + err.Error())
+	}
+	return nil
+}
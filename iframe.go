@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"github.com/PuerkitoBio/goquery"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const defaultIframeDepth = 2
+
+// iframeSrcs collects the images of the frames of doc, in frame order and
+// each frame's own frames right after it. The frames are fetched with the
+// page's client, so they get its cookies and headers too.
+func (s *Scraper) iframeSrcs(ctx context.Context, page *Page, client *http.Client, doc *goquery.Document, selector string, depth int) []string {
+	max := page.IframeDepth
+	if max == 0 {
+		max = defaultIframeDepth
+	}
+	if max < depth {
+		return nil
+	}
+
+	var frames []string
+	find(doc, or(page.IframeSelector, "iframe")).Each(func(_ int, el *goquery.Selection) {
+		src, _ := el.Attr("src")
+		src = strings.TrimSpace(src)
+		if src == "" || isDataUri(src) || strings.HasPrefix(src, "about:") || strings.HasPrefix(src, "javascript:") {
+			return
+		}
+		frames = append(frames, src)
+	})
+
+	var srcs []string
+	for _, frame := range resolveSrcs(doc.Url, frames) {
+		if ctx.Err() != nil {
+			break
+		}
+		if allowlist := allowlistOf(ctx); !allowlist.allows(frame) {
+			allowlist.skip(ctx, "iframe", frame)
+			continue
+		}
+		logln(ctx, "Follow iframe", frame)
+		frameDoc, err := page.GetDocument(ctx, client, frame)
+		if err != nil {
+			log.Println("WARNING: iframe", frame, err)
+			continue
+		}
+		found, err := s.collectSrcs(ctx, page, client, frameDoc, selector)
+		if err != nil {
+			log.Println("WARNING: iframe", frame, err)
+		}
+		srcs = append(srcs, found...)
+		srcs = append(srcs, s.iframeSrcs(ctx, page, client, frameDoc, selector, depth+1)...)
+	}
+	return srcs
+}
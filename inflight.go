@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const defaultInflightEstimate = 4 << 20
+
+// byteBudget bounds the bytes of the image bodies in flight. A body
+// reserves its Content-Length, or the estimate, before it is read, and
+// tops its reservation up without waiting when it turns out longer. A
+// body larger than the whole budget waits until it is alone.
+type byteBudget struct {
+	max      int64
+	estimate int64
+
+	mu      sync.Mutex
+	used    int64
+	changed chan struct{}
+}
+
+// newByteBudget returns the budget of limit bytes, nil for none.
+func newByteBudget(limit, estimate int64) *byteBudget {
+	if limit == 0 {
+		return nil
+	}
+	if estimate == 0 {
+		estimate = defaultInflightEstimate
+	}
+	return &byteBudget{max: limit, estimate: estimate, changed: make(chan struct{})}
+}
+
+// reserve waits until n bytes fit in b, or ctx is done, and takes them.
+func (b *byteBudget) reserve(ctx context.Context, n int64) error {
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.max {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// add takes n more bytes, or gives them back when negative.
+func (b *byteBudget) add(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += n
+	if n < 0 {
+		close(b.changed)
+		b.changed = make(chan struct{})
+	}
+}
+
+// budgeted makes the body of the image response res hold its bytes of
+// the max_inflight_bytes of ctx until closed, waiting for them first.
+func budgeted(ctx context.Context, res *http.Response) (*http.Response, error) {
+	f, ok := ctx.Value(fetchersKey{}).(*fetchers)
+	if !ok || f.inflight == nil || 400 <= res.StatusCode {
+		return res, nil
+	}
+	reserved := res.ContentLength
+	if reserved < 0 {
+		reserved = f.inflight.estimate
+	}
+	if err := f.inflight.reserve(ctx, reserved); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	res.Body = &budgetBody{ReadCloser: res.Body, budget: f.inflight, reserved: reserved}
+	return res, nil
+}
+
+// budgetBody is a response body holding bytes of a byteBudget.
+type budgetBody struct {
+	io.ReadCloser
+	budget   *byteBudget
+	reserved int64
+	read     int64
+	once     sync.Once
+}
+
+func (b *budgetBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.reserved < b.read {
+		b.budget.add(b.read - b.reserved)
+		b.reserved = b.read
+	}
+	return n, err
+}
+
+func (b *budgetBody) Close() error {
+	b.once.Do(func() { b.budget.add(-b.reserved) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// journalDir holds a journal for every scrape under way with journal set,
+// so resume can finish the scrapes of a process that stopped before saving
+// them. Each scrape has a directory of its own, named by the key of its URL
+// and a suffix of its own, with journal.jsonl and the images downloaded so
+// far by checksum. The scrape holds a lock on journal.jsonl until it ends.
+var journalDir = filepath.Join(downloadsDir, ".journal")
+
+// errLocked is tryLockFile finding the file locked.
+var errLocked = errors.New("locked by another process")
+
+// journalRecord is a line of journal.jsonl: first the plan, then an image
+// for every download written next to it, then commit once the archive is
+// saved. A line cut short by a crash is ignored, as is everything after.
+type journalRecord struct {
+	// Kind is plan, image or commit.
+	Kind string `json:"kind"`
+
+	Page      string            `json:"page,omitempty"`
+	Url       string            `json:"url,omitempty"`
+	Canonical string            `json:"canonical,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Srcs      []string          `json:"srcs,omitempty"`
+	Parts     map[string]string `json:"parts,omitempty"`
+	Originals map[string]string `json:"originals,omitempty"`
+
+	Src         string    `json:"src,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Sha256      string    `json:"sha256,omitempty"`
+	Modified    time.Time `json:"modified,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Snapshot    *Snapshot `json:"snapshot,omitempty"`
+}
+
+// journal is the journal of one scrape.
+type journal struct {
+	dir  string
+	plan journalRecord
+	// done are the images already written, by src.
+	done      map[string]journalRecord
+	committed bool
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+type journalKey struct{}
+
+func withJournal(ctx context.Context, j *journal) context.Context {
+	return context.WithValue(ctx, journalKey{}, j)
+}
+
+// journalOf is the journal of the scrape of ctx, or nil.
+func journalOf(ctx context.Context) *journal {
+	j, _ := ctx.Value(journalKey{}).(*journal)
+	return j
+}
+
+// journal is that of s.Resume, or a new one with the plan of the scrape
+// of url when page or the config set journal. Estimates, samples, updates
+// and repairs are not journaled.
+func (s *Scraper) journal(page *Page, url string, result *Result, srcs []string, parts map[string]string, originals map[string]string) (*journal, error) {
+	if s.Resume != nil {
+		return s.Resume, nil
+	}
+	if !s.Config.Journal && !page.Journal || s.Estimate || 0 < s.Sample || s.Update || s.Repair != "" {
+		return nil, nil
+	}
+	plan := journalRecord{
+		Kind:      "plan",
+		Page:      page.Name,
+		Url:       url,
+		Canonical: result.Canonical,
+		Title:     result.Title,
+		Path:      result.Path,
+		Srcs:      srcs,
+		Parts:     parts,
+		Originals: originals,
+	}
+	// Another job may be scraping url as well: each has a directory of
+	// its own, and resume picks the latest.
+	if err := os.MkdirAll(journalDir, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(journalDir, journalPrefix(s.Config.urlKey(plan.Url)))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(dir, "journal.jsonl"))
+	if err == nil {
+		err = lockFile(f)
+		if err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	j := &journal{dir: dir, plan: plan, done: map[string]journalRecord{}, f: f}
+	if err := j.append(plan); err != nil {
+		f.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return j, nil
+}
+
+// journalPrefix starts the names of the journal directories of the URL
+// with key.
+func journalPrefix(key string) string {
+	return checksum([]byte(key))[:16] + "-"
+}
+
+// openJournal reads the journal in dir, and opens it to go on with when
+// it was not committed. It fails with errLocked while a scrape still
+// holds the journal.
+func openJournal(dir string) (*journal, error) {
+	f, err := os.OpenFile(filepath.Join(dir, "journal.jsonl"), os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j := &journal{dir: dir, done: map[string]journalRecord{}, f: f}
+	r := bufio.NewReader(f)
+	var good int64
+	for {
+		line, err := r.ReadBytes('\n')
+		var record journalRecord
+		if err != nil || json.Unmarshal(line, &record) != nil {
+			break
+		}
+		good += int64(len(line))
+		switch record.Kind {
+		case "plan":
+			j.plan = record
+		case "image":
+			j.done[record.Src] = record
+		case "commit":
+			j.committed = true
+		}
+	}
+	if j.plan.Kind == "" {
+		f.Close()
+		return nil, errors.New(dir + ": journal without a plan")
+	}
+	// Later records go after the last whole line.
+	if err := f.Truncate(good); err == nil {
+		_, err = f.Seek(good, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *journal) append(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// add writes image next to the journal and records it, once it is safely
+// on disk.
+func (j *journal) add(ctx context.Context, image *Image) {
+	if j == nil {
+		return
+	}
+	record := journalRecord{
+		Kind:        "image",
+		Src:         image.Src,
+		Name:        strings.TrimPrefix(image.Name, strconv.Itoa(image.Index)+"-"),
+		Sha256:      image.Bytes.Sha256(),
+		Modified:    image.Modified,
+		ContentType: image.ContentType,
+		Snapshot:    image.Snapshot,
+	}
+	err := j.write(record.Sha256, image.Bytes)
+	if err == nil {
+		j.mu.Lock()
+		err = j.append(record)
+		j.mu.Unlock()
+	}
+	if err != nil {
+		logln(ctx, "WARNING: journal:", err)
+	}
+}
+
+func (j *journal) write(name string, body *Body) error {
+	f, err := os.Create(filepath.Join(j.dir, name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body.Reader())
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// remaining matches srcs against the images of the journal, which are
+// kept when their bytes are intact, and leaves the rest to download.
+func (j *journal) remaining(ctx context.Context, srcs []string) *update {
+	u := &update{}
+	for i, src := range srcs {
+		record, ok := j.done[src]
+		var b []byte
+		var err error
+		if ok {
+			b, err = os.ReadFile(filepath.Join(j.dir, record.Sha256))
+		}
+		if !ok || err != nil || checksum(b) != record.Sha256 {
+			if ok {
+				logln(ctx, "Journal lost", displaySrc(src))
+			}
+			u.download = append(u.download, src)
+			u.positions = append(u.positions, i)
+			continue
+		}
+		u.kept = append(u.kept, &Image{
+			Name:        strconv.Itoa(i) + "-" + record.Name,
+			Bytes:       newBody(b),
+			Index:       i,
+			Src:         src,
+			Modified:    record.Modified,
+			ContentType: record.ContentType,
+			Snapshot:    record.Snapshot,
+		})
+	}
+	return u
+}
+
+// commit records that the archive is saved and removes the journal.
+func (j *journal) commit() error {
+	j.mu.Lock()
+	err := j.append(journalRecord{Kind: "commit"})
+	j.f.Close()
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(j.dir)
+}
+
+// close leaves the journal for resume.
+func (j *journal) close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.f.Close()
+}
+
+// journals lists the directories of journalDir, the latest first.
+func journals() ([]string, error) {
+	entries, err := os.ReadDir(journalDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	modified := map[string]time.Time{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(journalDir, entry.Name())
+		if info, err := entry.Info(); err == nil {
+			modified[dir] = info.ModTime()
+		}
+		dirs = append(dirs, dir)
+	}
+	sort.SliceStable(dirs, func(i, j int) bool { return modified[dirs[i]].After(modified[dirs[j]]) })
+	return dirs, nil
+}
+
+// journalUrl is the part of the name of the journal directory dir that
+// journalPrefix made.
+func journalUrl(dir string) string {
+	name := filepath.Base(dir)
+	if i := strings.IndexByte(name, '-'); 0 <= i {
+		return name[:i]
+	}
+	return name
+}
+
+// resume finishes the scrapes left in journalDir, keeping the images
+// they wrote and downloading the rest.
+func resume(config *Config, args []string) error {
+	flags := newFlagSet("resume")
+	flags.BoolVar(&debugEnabled, "debug", false, "log debug details")
+	list := flags.Bool("list", false, "list the scrapes to resume instead")
+	promptFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := checkPromptFlags(); err != nil {
+		return err
+	}
+	if 0 < flags.NArg() {
+		return usageError(errors.New("Usage: scrape-go resume [--list]"))
+	}
+	dirs, err := journals()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var failed []error
+	// seen are the URLs of the journals already gone through: the
+	// journals of earlier runs of them are superseded.
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		j, err := openJournal(dir)
+		if errors.Is(err, errLocked) {
+			log.Println("Skip", dir+": its scrape is still running")
+			seen[journalUrl(dir)] = true
+			continue
+		}
+		if err != nil {
+			log.Println("WARNING: journal:", err)
+			continue
+		}
+		if j.committed {
+			j.close()
+			os.RemoveAll(dir)
+			continue
+		}
+		if seen[journalUrl(dir)] {
+			j.close()
+			if !*list {
+				log.Println("Drop", dir+": superseded by a later scrape of", j.plan.Url)
+				os.RemoveAll(dir)
+			}
+			continue
+		}
+		seen[journalUrl(dir)] = true
+		if *list {
+			j.close()
+			fmt.Println(j.plan.Url, "→", j.plan.Path+",", len(j.done), "of", len(j.plan.Srcs), "images")
+			continue
+		}
+		if err := resumeJournal(ctx, config, j); err != nil {
+			log.Println("Resume", j.plan.Url+":", err)
+			failed = append(failed, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	if 0 < len(failed) {
+		return &exitError{Code: exitPartial, Err: fmt.Errorf("%d of the scrapes could not be resumed", len(failed))}
+	}
+	return nil
+}
+
+func resumeJournal(ctx context.Context, config *Config, j *journal) error {
+	page := config.FindPage(j.plan.Page)
+	if page == nil {
+		var err error
+		page, err = config.MatchPage(j.plan.Url)
+		if err != nil {
+			j.close()
+			return err
+		}
+	}
+	defer j.close()
+	log.Println("Resume", j.plan.Url+":", len(j.done), "of", len(j.plan.Srcs), "images written")
+	scraper := &Scraper{Config: config, Resume: j}
+	result, err := scraper.scrape(ctx, page, j.plan.Url, nil)
+	if err != nil {
+		return err
+	}
+	log.Println("Saved", result.Path+":", result.Images, "images,", result.Failed, "failed")
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func journalScraper(t *testing.T, journal bool) (*Scraper, *Page) {
+	t.Helper()
+	quiet(t)
+	inTempDir(t)
+	config := &Config{Journal: journal, Pages: []Page{{Name: "gallery", TitleSelector: "h1", ImageSelector: "img"}}}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return &Scraper{Config: config}, &config.Pages[0]
+}
+
+func TestJournalOptIn(t *testing.T) {
+	scraper, page := journalScraper(t, false)
+	j, err := scraper.journal(page, "https://gallery.example/g/1", &Result{}, nil, nil, nil)
+	if j != nil || err != nil {
+		t.Fatalf("journal = %v, %v without journal set", j, err)
+	}
+	if _, err := os.Stat(journalDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%s made: %v", journalDir, err)
+	}
+
+	page.Journal = true
+	j, err = scraper.journal(page, "https://gallery.example/g/1", &Result{}, nil, nil, nil)
+	if err != nil || j == nil {
+		t.Fatalf("journal = %v, %v with the journal of the page set", j, err)
+	}
+	j.close()
+}
+
+// Jobs scraping the same URL at once keep journals of their own, and
+// resume leaves alone those still held.
+func TestJournalPerJob(t *testing.T) {
+	scraper, page := journalScraper(t, true)
+	result := &Result{Path: "downloads/title.zip"}
+	srcs := []string{"https://gallery.example/1.jpg"}
+	first, err := scraper.journal(page, "https://gallery.example/g/1", result, srcs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.close()
+	second, err := scraper.journal(page, "https://gallery.example/g/1", result, srcs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.dir == second.dir || journalUrl(first.dir) != journalUrl(second.dir) {
+		t.Fatalf("journals in %s and %s", first.dir, second.dir)
+	}
+	if _, err := os.Stat(first.dir); err != nil {
+		t.Fatalf("the second job removed the journal of the first: %v", err)
+	}
+
+	if _, err := openJournal(first.dir); !errors.Is(err, errLocked) {
+		t.Fatalf("opening a held journal: %v, want %v", err, errLocked)
+	}
+	if err := second.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first.dir); err != nil {
+		t.Errorf("committing the second job removed the journal of the first: %v", err)
+	}
+
+	first.close()
+	j, err := openJournal(first.dir)
+	if err != nil {
+		t.Fatalf("opening a left journal: %v", err)
+	}
+	defer j.close()
+	if j.plan.Url != "https://gallery.example/g/1" || len(j.plan.Srcs) != 1 || j.committed {
+		t.Errorf("plan = %+v, committed %t", j.plan, j.committed)
+	}
+	if err := resume(scraper.Config, []string{"--list"}); err != nil {
+		t.Errorf("resume --list with the journal held: %v", err)
+	}
+}
+
+func TestResumeSupersededJournals(t *testing.T) {
+	scraper, page := journalScraper(t, true)
+	var dirs []string
+	for _, title := range []string{"earlier.zip", "later.zip"} {
+		j, err := scraper.journal(page, "https://gallery.example/g/1", &Result{Path: "downloads/" + title}, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, j.dir)
+		j.close()
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(dirs[0], hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := journals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[0] != dirs[1] {
+		t.Fatalf("journals = %v, want %s first", listed, dirs[1])
+	}
+	// The later journal, still running, supersedes the earlier one.
+	later, err := openJournal(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer later.close()
+	if err := resume(scraper.Config, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		_, err := os.Stat(dir)
+		if dir == later.dir && err != nil {
+			t.Errorf("the journal held was removed: %v", err)
+		}
+		if dir != later.dir && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("the superseded journal %s was kept: %v", dir, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	entryLayoutFlat = "flat"
+	entryLayoutPath = "path"
+)
+
+func validateEntryLayout(layout string) error {
+	switch layout {
+	case "", entryLayoutFlat, entryLayoutPath:
+		return nil
+	default:
+		return errors.New("entry_layout must be " + entryLayoutFlat + " or " + entryLayoutPath)
+	}
+}
+
+// entryPath is the archive path of image under the "path" layout: its URL
+// path without the host, cleaned so that ".." cannot leave the archive.
+// Images without a usable path keep their flat name.
+func entryPath(image *Image, prefix bool) string {
+	flat := strings.TrimPrefix(image.Name, strconv.Itoa(image.Index)+"-")
+	u, err := url.Parse(image.Src)
+	if err != nil || isDataUri(image.Src) || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return image.Name
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path.Clean("/"+u.Path), "/"), "/")
+	for i, segment := range segments {
+		segments[i] = sanitize(segment)
+	}
+	base := segments[len(segments)-1]
+	if filepath.Ext(base) == "" {
+		// downloadImage named it after its Content-Type.
+		base += filepath.Ext(flat)
+	}
+	if prefix {
+		base = strconv.Itoa(image.Index) + "-" + base
+	}
+	segments[len(segments)-1] = base
+	return strings.Join(segments, "/")
+}
+
+// layoutEntries renames images for the "path" layout, numbering names
+// that collide.
+func layoutEntries(images []*Image, prefix bool) {
+	seen := make(map[string]bool)
+	for _, image := range sortedImages(images) {
+		name := entryPath(image, prefix)
+		ext := path.Ext(name)
+		for n := 2; seen[name]; n++ {
+			name = strings.TrimSuffix(entryPath(image, prefix), ext) + "-" + strconv.Itoa(n) + ext
+		}
+		seen[name] = true
+		image.Name = name
+	}
+}
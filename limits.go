@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits ask for confirmation before a run downloads more than expected,
+// which is usually a selector matching far too much.
+type Limits struct {
+	ConfirmOverImages int   `toml:"confirm_over_images"`
+	ConfirmOverBytes  int64 `toml:"confirm_over_bytes"`
+}
+
+func (s *Scraper) limits(page *Page) Limits {
+	limits := page.Limits
+	if limits.ConfirmOverImages == 0 {
+		limits.ConfirmOverImages = s.Config.ConfirmOverImages
+	}
+	if limits.ConfirmOverBytes == 0 {
+		limits.ConfirmOverBytes = s.Config.ConfirmOverBytes
+	}
+	return limits
+}
+
+// confirm checks srcs against the limits of page before any image is
+// fetched. Over a limit it asks on a terminal; otherwise it carries on,
+// or fails when StrictLimits is set.
+func (s *Scraper) confirm(ctx context.Context, page *Page, client *http.Client, srcs []string) error {
+	limits := s.limits(page)
+	var over []string
+	if 0 < limits.ConfirmOverImages && limits.ConfirmOverImages < len(srcs) {
+		over = append(over, strconv.Itoa(len(srcs))+" images (limit "+strconv.Itoa(limits.ConfirmOverImages)+")")
+	}
+	if 0 < limits.ConfirmOverBytes {
+		estimate := estimateSize(ctx, client, srcs)
+		if limits.ConfirmOverBytes < estimate.Bytes {
+			over = append(over, estimate.String()+" (limit "+formatBytes(limits.ConfirmOverBytes)+")")
+		}
+	}
+	if len(over) == 0 {
+		return nil
+	}
+
+	message := "Page " + page.label() + " matched " + strings.Join(over, " and ")
+	fallback := "y"
+	if s.StrictLimits {
+		fallback = "n"
+	}
+	answer, asked := s.ask(ctx, message+". Download anyway? [y/N]:", fallback)
+	if strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes") {
+		return nil
+	}
+	if !asked {
+		return errors.New(message)
+	}
+	return errors.New(message + ", cancelled")
+}
+
+// prompts reports whether scraping page may read from stdin.
+func (s *Scraper) prompts(page *Page) bool {
+	if !s.canPrompt() {
+		return false
+	}
+	limits := s.limits(page)
+	return s.Select || 0 < limits.ConfirmOverImages || 0 < limits.ConfirmOverBytes
+}
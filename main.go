@@ -3,62 +3,362 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/PuerkitoBio/goquery"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type Image struct {
 	Name  string
-	Bytes *bytes.Buffer
+	Bytes *Body
+	Index int
+	Src   string
+	// Modified is the Last-Modified of the download, if it had one.
+	Modified time.Time
+	// Snapshot is set when Src was dead and the image came from the
+	// Wayback Machine.
+	Snapshot *Snapshot
+	// Thumbnail is the entry name of the thumbnail of the image, if any.
+	Thumbnail string
+	// Part is the page of a merged gallery the image was found on.
+	Part string
+	// DuplicateOf is the entry name of the earlier image this one repeats.
+	DuplicateOf string
+	// ContentType is the media type the image was served as.
+	ContentType string
+	// OriginalName and DeclaredType are the name and ContentType of an
+	// image renamed by fix_extensions.
+	OriginalName string
+	DeclaredType string
 }
 
 type Config struct {
-	Pages []Page
+	WebhookUrl    string `toml:"webhook_url"`
+	WebhookSecret string `toml:"webhook_secret"`
+	// WebhookRetries retries a failed webhook, 3 times when unset; 0 is
+	// no retries.
+	WebhookRetries *int `toml:"webhook_retries"`
+	Notify         bool
+	// AutoConfidence is the share of the image score the detected gallery
+	// must reach before an auto-detected selector is used.
+	AutoConfidence float64 `toml:"auto_confidence"`
+	Upload         *Upload
+	// PreScrapeCommand and PostSaveCommand are argv arrays run before each
+	// scrape and after each save, with {path}, {title}, {url} and
+	// {page_name} replaced.
+	PreScrapeCommand []string `toml:"pre_scrape_command"`
+	PostSaveCommand  []string `toml:"post_save_command"`
+	// Filename names archives; see nameTemplate. It defaults to "{title}".
+	Filename string
+	// ZipComment is the archive comment; see zipCommentVariables.
+	ZipComment string `toml:"zip_comment"`
+	// PageRetries retries fetching the document and finding its title,
+	// waiting PageRetryDelay, doubled on each attempt, in between. Pages
+	// without it take that of the config; 0 is no retries.
+	PageRetries    *int     `toml:"page_retries"`
+	PageRetryDelay Duration `toml:"page_retry_delay"`
+	SaveHtml       bool     `toml:"save_html"`
+	// Store keeps each image once in this directory, named by its
+	// SHA-256, and reuses it instead of downloading its URL again.
+	Store string
+	// ArchiveMtime dates the saved archive by its newest image.
+	ArchiveMtime bool `toml:"archive_mtime"`
+	// Journal writes every downloaded image of a scrape to disk, fsynced,
+	// as it comes, so resume can finish the scrapes of a run that stopped.
+	Journal bool
+	// EntryLayout "path" mirrors the URL paths of the images inside the
+	// archive instead of the "flat" <index>-<name>. EntryIndex keeps the
+	// index prefix in path mode.
+	EntryLayout string `toml:"entry_layout"`
+	EntryIndex  *bool  `toml:"entry_index"`
+	StrictTitle bool   `toml:"strict_title"`
+	StrictCount bool   `toml:"strict_count"`
+	AsciiNames  bool   `toml:"ascii_names"`
+	// WaybackFallback downloads images that end up 404 or 410 from the
+	// Wayback Machine, when it has them.
+	WaybackFallback bool `toml:"wayback_fallback"`
+	// StalePageFallback scrapes the copy of the document cached by its
+	// last fetch when fetching it fails, page_retries included.
+	StalePageFallback bool `toml:"stale_page_fallback"`
+	// PlaceholderThreshold warns about pages where this many images or
+	// more are the same bytes.
+	PlaceholderThreshold int `toml:"placeholder_threshold"`
+	// CookieStore is a file keeping the cookies hosts set, such as login
+	// sessions, from one run to the next.
+	CookieStore string `toml:"cookie_store"`
+	// StripParams are the query parameters, glob patterns, that URLs given
+	// to a run lose before they are scraped and deduplicated; see
+	// defaultStripParams.
+	StripParams []string `toml:"strip_params"`
+	// SpoolOver keeps downloads and archives larger than it in temp files
+	// in SpoolDir (the system's by default) instead of in memory.
+	SpoolOver Size   `toml:"spool_over"`
+	SpoolDir  string `toml:"spool_dir"`
+	TitleOptions
+	Transport
+	Resolver
+	Limits
+	Deadline
+	Timeouts
+	Circuit
+	Ranged
+	Blocklist
+	Statuses
+	Watchdog
+	Encryption
+	Types
+	Pacing
+	ThumbnailOptions
+	Duplicates
+	Fetching
+	Throttle
+	CanonicalLink
+	// PasswordEnv names the variable holding the zip-aes password,
+	// SCRAPE_GO_ZIP_PASSWORD by default. It is asked for without one.
+	PasswordEnv string `toml:"password_env"`
+	Pages       []Page
+
+	filename   *nameTemplate
+	zipComment *nameTemplate
+	password   string
+}
+
+// FindPage returns the page configured under name, or nil.
+func (c *Config) FindPage(name string) *Page {
+	for i := range c.Pages {
+		if c.Pages[i].Name == name {
+			return &c.Pages[i]
+		}
+	}
+	return nil
 }
 
 type Page struct {
+	Name          string
 	Url           string
 	TitleSelector string `toml:"title_selector"`
 	ImageSelector string `toml:"image_selector"`
+	WebhookUrl    string `toml:"webhook_url"`
+	WebhookSecret string `toml:"webhook_secret"`
+	Notify        bool
+	CookieFile    string `toml:"cookie_file"`
+	// HostPattern selects this page for URLs of a URL list whose host
+	// matches it: a glob, or a regular expression between slashes.
+	HostPattern string `toml:"host_pattern"`
+	Priority    int
+	// Extract pulls image URLs out of the text of the elements matched by
+	// ImageSelector instead of reading their src attributes.
+	Extract *Extract
+	// ExtractBackground falls back to CSS background images, inline or
+	// from the page's stylesheets, for matched elements without a src.
+	ExtractBackground bool `toml:"extract_background"`
+	// ImageSource "meta" downloads the single og:image or twitter:image of
+	// the page instead of matching ImageSelector.
+	ImageSource string `toml:"image_source"`
+	// MediaSelector matches videos or links to them, downloaded into the
+	// same archive from their MediaAttr ("src" by default).
+	MediaSelector string `toml:"media_selector"`
+	MediaAttr     string `toml:"media_attr"`
+	// Prefer "largest" downloads, of the URLs in the PreferAttrs of each
+	// image (data-zoom-image, data-src and src by default), the one with
+	// the largest Content-Length, or the first when none reports it.
+	Prefer      string   `toml:"prefer"`
+	PreferAttrs []string `toml:"prefer_attrs"`
+	// FollowIframes also collects the images of the documents of the
+	// frames matching IframeSelector ("iframe" by default), descending
+	// at most IframeDepth (default 2) frames deep.
+	FollowIframes  bool   `toml:"follow_iframes"`
+	IframeSelector string `toml:"iframe_selector"`
+	IframeDepth    int    `toml:"iframe_depth"`
+	// MergeSelector matches the links of the first page to the other
+	// pages of the same gallery, whose images are appended, in link
+	// order, to the archive of the first.
+	MergeSelector string `toml:"merge_selector"`
+	// SaveHtml stores every fetched document in the archive as is, with
+	// its final URL and fetch time in manifest.json.
+	SaveHtml     bool   `toml:"save_html"`
+	ArchiveMtime bool   `toml:"archive_mtime"`
+	EntryLayout  string `toml:"entry_layout"`
+	EntryIndex   *bool  `toml:"entry_index"`
+	Journal      bool
+	// MetaRefreshHosts are the hosts besides the page's own that a
+	// <meta http-equiv="refresh"> may send GetDocument to.
+	MetaRefreshHosts []string `toml:"meta_refresh_hosts"`
+	Upload           *Upload
+	PreScrapeCommand []string `toml:"pre_scrape_command"`
+	PostSaveCommand  []string `toml:"post_save_command"`
+	Filename         string
+	ZipComment       string   `toml:"zip_comment"`
+	PageRetries      *int     `toml:"page_retries"`
+	PageRetryDelay   Duration `toml:"page_retry_delay"`
+	// StrictTitle fails pages without a title instead of naming them
+	// after their URL.
+	StrictTitle bool `toml:"strict_title"`
+	// StrictCount fails pages whose image count differs from the one
+	// they show instead of warning.
+	StrictCount bool `toml:"strict_count"`
+	// AsciiNames adds an ASCII transliteration of every entry name to
+	// manifest.json for tools that cannot read UTF-8 names.
+	AsciiNames           bool `toml:"ascii_names"`
+	WaybackFallback      bool `toml:"wayback_fallback"`
+	StalePageFallback    bool `toml:"stale_page_fallback"`
+	PlaceholderThreshold int  `toml:"placeholder_threshold"`
+	// AbortIfSelector fails the page before any download when it matches
+	// the document, and RequireSelector when it does not, for paywalls
+	// and redirects that serve something else than the gallery.
+	AbortIfSelector string `toml:"abort_if_selector"`
+	RequireSelector string `toml:"require_selector"`
+	// TitleRules rewrite the raw title, in order, before it is sanitized.
+	TitleRules []TitleRule `toml:"title_rules"`
+	// SrcRewrite rewrites every resolved src, in order, before it is
+	// downloaded. SrcRewriteFallback downloads the src as it was when the
+	// rewritten one is gone.
+	SrcRewrite         []SrcRule `toml:"src_rewrite"`
+	SrcRewriteFallback bool      `toml:"src_rewrite_fallback"`
+	// Title names the archives of URLs with ranges such as
+	// page-{001..120}.jpg, which are downloaded without fetching any
+	// document. RangeMaxMisses (default 5) consecutive missing images end
+	// an open range such as {1..}.
+	Title          string
+	RangeMaxMisses int `toml:"range_max_misses"`
+	TitleOptions
+	Transport
+	Limits
+	Deadline
+	Timeouts
+	Blocklist
+	Api
+	Sitemap
+	Statuses
+	ExpectedCount
+	Crawl
+	Encryption
+	Types
+	Pacing
+	ThumbnailOptions
+	Order
+	Duplicates
+	CanonicalLink
+
+	hostPattern *regexp.Regexp
+	filename    *nameTemplate
+	zipComment  *nameTemplate
+}
+
+// rawTitle is the title of doc as the page shows it.
+func (p *Page) rawTitle(doc *goquery.Document) string {
+	if p.TitleSelector == "" && p.ImageSource == imageSourceMeta {
+		title := metaContent(doc, `meta[property="og:title"]`, `meta[name="twitter:title"]`)
+		if title == "" {
+			title = strings.TrimSpace(doc.Find("title").First().Text())
+		}
+		return title
+	}
+	return find(doc, p.TitleSelector).Text()
 }
 
 func (p *Page) GetTitle(doc *goquery.Document) (string, error) {
-	var title = doc.Find(p.TitleSelector).Text()
-	title = strings.Replace(title, "/", "_", -1)
-	title = strings.Replace(title, " ", "_", -1)
+	title := sanitize(p.applyTitleRules(p.rawTitle(doc)))
 
 	if len(title) < 1 {
-		return "", errors.New("Failed to get title " + p.TitleSelector)
+		return "", fmt.Errorf("%w %s", errNoTitle, p.TitleSelector)
 	}
 	return title, nil
 }
 
-func (p *Page) GetDocument(url string) (*goquery.Document, error) {
-	res, err := http.Get(url)
+func (p *Page) GetDocument(ctx context.Context, client *http.Client, url string) (*goquery.Document, error) {
+	doc, err := fetchDocument(ctx, client, url)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
+	target := metaRefresh(doc)
+	if target == nil {
+		return doc, nil
+	}
+	if !p.allowRefresh(doc.Url, target) {
+		logln(ctx, "Ignore meta refresh to", target, "from", doc.Url)
+		return doc, nil
+	}
+	logln(ctx, "Follow meta refresh to", target)
+	return fetchDocument(ctx, client, target.String())
+}
 
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+func fetchDocument(ctx context.Context, client *http.Client, url string) (doc *goquery.Document, err error) {
+	release, err := acquireDocument(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	slow := false
+	defer func() { observeDocument(ctx, url, start, slow || throttleFailure(err)) }()
+	ctx, cancel := documentContext(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	fetch := conditionalOf(ctx, url)
+	if fetch != nil {
+		fetch.setHeaders(req)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	debugln(res.Proto, res.Status, url)
+	logRedirect(url, res)
+	if fetch != nil && res.StatusCode == http.StatusNotModified {
+		return nil, errUnchanged
+	}
+	body, err := io.ReadAll(res.Body)
+	if vendor := responseChallenge(res, body); vendor != "" {
+		return nil, &ChallengeError{Url: url, Vendor: vendor}
+	}
+	if 400 <= res.StatusCode {
+		return nil, &StatusError{Url: url, Code: res.StatusCode, Status: res.Status}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fetch != nil {
+		if err := fetch.observe(res.Header, body); err != nil {
+			return nil, err
+		}
+	}
+	recordSource(ctx, res.Request.URL.String(), body)
+	slow = slowDown(res.Header.Get("Content-Type"), bytes.NewReader(body))
+	doc, err = goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
+	doc.Url = res.Request.URL
 	return doc, nil
 }
 
 func (p *Page) GetImageSrcs(doc *goquery.Document) []string {
-	images := doc.Find(p.ImageSelector)
+	return imageSrcs(doc, p.ImageSelector)
+}
+
+func imageSrcs(doc *goquery.Document, selector string) []string {
+	images := find(doc, selector)
 	results := make([]string, images.Length())
 
 	images.Each(func(i int, el *goquery.Selection) {
@@ -71,178 +371,868 @@ func (p *Page) GetImageSrcs(doc *goquery.Document) []string {
 	return results
 }
 
-func downloadImage(src string) (*Image, error) {
+// collectSrcs returns the image srcs of doc, resolved against its URL.
+func (s *Scraper) collectSrcs(ctx context.Context, page *Page, client *http.Client, doc *goquery.Document, selector string) ([]string, error) {
+	var srcs []string
+	var err error
+	if page.ImageSource == imageSourceMeta {
+		srcs, err = metaImageSrcs(doc)
+		if err != nil {
+			return nil, err
+		}
+	} else if page.Extract != nil {
+		srcs, err = page.Extract.Apply(find(doc, selector))
+		if err != nil {
+			return nil, err
+		}
+	} else if page.ExtractBackground {
+		srcs = s.backgroundSrcs(ctx, client, doc, selector)
+	} else if page.MediaSelector != "" {
+		srcs = page.mediaSrcs(doc, selector)
+	} else if page.Prefer == preferLargest {
+		srcs = s.largestSrcs(ctx, page, client, doc, selector)
+	} else {
+		srcs = imageSrcs(doc, selector)
+	}
+	if page.MediaSelector != "" && (page.ImageSource == imageSourceMeta || page.Extract != nil || page.ExtractBackground) {
+		// DOM order only means something for attribute matches.
+		srcs = append(srcs, attrSrcs(find(doc, page.MediaSelector), page.mediaAttr())...)
+	}
+	return resolveSrcs(doc.Url, srcs), nil
+}
+
+func downloadImage(ctx context.Context, client *http.Client, src string) (*Image, error) {
+	if isDataUri(src) {
+		return decodeDataUri(src)
+	}
 	if 0 < len(src) {
-		res, err := http.Get(src)
+		req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := doTimed(client, req)
 		if err != nil {
 			return nil, err
 		}
 		defer res.Body.Close()
+		debugln(res.Proto, res.Status, src)
+		logRedirect(src, res)
+		if 400 <= res.StatusCode {
+			return nil, &StatusError{Url: src, Code: res.StatusCode, Status: res.Status}
+		}
+		setDownloadTotal(ctx, res.ContentLength)
 
-		buf := new(bytes.Buffer)
-		io.Copy(buf, res.Body)
+		body := spoolBody(ctx)
+		n, err := io.Copy(body, countBody(ctx, res.Body))
+		if err == nil && n == 0 {
+			err = errEmpty
+		}
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		return newImage(src, res.Header, body), nil
+	}
+	return nil, errors.New("<img> does not have attribute `src`")
+}
 
-		paths := strings.Split(src, "/")
-		name := paths[len(paths)-1]
+// newImage names the downloaded buf after the last segment of src, with
+// an extension from its Content-Type if it has none.
+func newImage(src string, header http.Header, buf *Body) *Image {
+	paths := strings.Split(src, "/")
+	name := paths[len(paths)-1]
+	mediatype, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if filepath.Ext(name) == "" && err == nil {
+		name += extensionForType(mediatype)
+	}
 
-		image := Image{Name: name, Bytes: buf}
-		return &image, nil
+	image := Image{Name: name, Bytes: buf, ContentType: mediatype}
+	if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		image.Modified = modified.UTC()
 	}
-	return nil, errors.New("<img> does not have attribute `src`")
+	return &image
 }
 
-func downloadImages(srcs []string) []*Image {
-	log.Println(len(srcs), "images.")
+func (s *Scraper) downloadImages(ctx context.Context, page *Page, client *http.Client, srcs []string) ([]*Image, []error) {
+	logln(ctx, len(srcs), "images.")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	retries := s.imageRetries(page)
+	url := pageUrl(ctx)
+	for i, src := range srcs {
+		emit(ctx, ImageQueued{Url: url, Index: i, Src: src})
+	}
+	store, err := s.store()
+	if err != nil {
+		logln(ctx, "WARNING: store:", err)
+	}
 	results := make(chan []*Image)
+	errs := make(chan []error)
 	finished := make(chan bool)
 	done := make(chan *Image)
+	failed := make(chan error)
 
 	go func() {
 		xs := make([]*Image, 0)
+		es := make([]error, 0)
 		for {
 			select {
 			case x := <-done:
 				xs = append(xs, x)
+			case e := <-failed:
+				es = append(es, e)
 			case <-finished:
 				results <- xs
+				errs <- es
 				return
 			}
 		}
 	}()
 
+	// Paced pages download one image at a time, in downloadOrder.
+	paced := s.pacing(page).PacingMode != ""
+	order := s.downloadOrder(page, len(srcs))
 	go func() {
 		var wg sync.WaitGroup
-		for i, src := range srcs {
+		for _, i := range order {
 			wg.Add(1)
-			go func(i int, src string) {
-				log.Println("START", "[", i, "]", src)
+			fetch := func(i int, src string) {
+				defer wg.Done()
+				emit(ctx, ImageStarted{Url: url, Index: i, Src: src})
 
-				image, err := downloadImage(src)
-				log.Println("DONE", "[", i, "]", src)
+				if image := store.Get(src); image != nil {
+					emit(ctx, ImageDone{Url: url, Index: i, Src: src, Bytes: int64(image.Bytes.Len()), Stored: true})
+					image.Name = strconv.Itoa(i) + "-" + image.Name
+					image.Index = i
+					image.Src = src
+					journalOf(ctx).add(ctx, image)
+					done <- image
+					return
+				}
+				if err := s.allowHost(src); err != nil {
+					emit(ctx, ImageFailed{Url: url, Index: i, Src: src, Err: err})
+					failed <- &imageError{Index: i, Src: src, Err: err}
+					return
+				}
+				var image *Image
+				var err error
+				downloadCtx, finish := activity.startDownload(ctx, i, src)
+				defer finish()
+				for attempt := 0; ; attempt++ {
+					var imageCtx context.Context
+					var end func()
+					imageCtx, end, err = s.imageContext(downloadCtx, src)
+					if err != nil {
+						break
+					}
+					record := &downloadRecord{Url: src, Start: time.Now()}
+					attemptCtx, cancelAttempt := context.WithCancelCause(imageCtx)
+					s.watchDownload(attemptCtx, i, cancelAttempt)
+					image, err = s.download(traceDownload(attemptCtx, record), client, src)
+					if cause := context.Cause(attemptCtx); err != nil && (errors.Is(cause, errStalled) || errors.Is(cause, errImageTimeout)) {
+						err = cause
+					}
+					cancelAttempt(nil)
+					end()
+					s.recordHost(src, err)
+					s.fetchers().observe(src, record.Total, throttleFailure(err) || (err == nil && slowDown(image.ContentType, image.Bytes.Reader())))
+					record.finish(image, err)
+					metrics.ObserveDownload(record.Total, image, err)
+					s.Stats.Add(record)
+					if err == nil || retries <= attempt || (s.imageAction(page, err) != statusRetry && !errors.Is(err, errStalled) && !errors.Is(err, errEmpty) && !downloadTimeout(err)) {
+						break
+					}
+					logln(ctx, "RETRY", "[", i, "]", displaySrc(src), err)
+					if sleep(ctx, s.imageRetryDelay()<<attempt) != nil {
+						break
+					}
+				}
 
+				if original := originalSrc(ctx, src); err != nil && page.SrcRewriteFallback && gone(err) && original != "" {
+					logln(ctx, "FALLBACK", "[", i, "]", displaySrc(original), "after", err)
+					if imageCtx, end, acquireErr := s.imageContext(downloadCtx, original); acquireErr == nil {
+						image, err = s.download(imageCtx, client, original)
+						end()
+					}
+				}
+				if err != nil && (s.Config.WaybackFallback || page.WaybackFallback) && gone(err) {
+					snapshot, waybackErr := s.fromWayback(downloadCtx, client, src)
+					if waybackErr != nil {
+						logln(ctx, "WAYBACK", "[", i, "]", displaySrc(src), waybackErr)
+					} else {
+						logln(ctx, "WAYBACK", "[", i, "]", displaySrc(src), "from", snapshot.Snapshot.Url)
+						image, err = snapshot, nil
+					}
+				}
 				if err != nil {
-					log.Fatal(err)
+					action := s.imageAction(page, err)
+					if action == statusFail {
+						// Stop the rest, the page fails anyway.
+						cancel()
+					}
+					emit(ctx, ImageFailed{Url: url, Index: i, Src: src, Err: err})
+					failed <- &imageError{Index: i, Src: src, Err: err, Skipped: action == statusSkip}
+					return
+				}
+				// Snapshots stay out of the store, which would forget
+				// where they came from.
+				if image.Snapshot == nil {
+					if err := store.Put(src, image); err != nil {
+						logln(ctx, "WARNING: store:", err)
+					}
 				}
+				emit(ctx, ImageDone{Url: url, Index: i, Src: src, Bytes: int64(image.Bytes.Len()), Snapshot: image.Snapshot})
 				name := strconv.Itoa(i) + "-" + image.Name
 				image.Name = name
+				image.Index = i
+				image.Src = src
+				journalOf(ctx).add(ctx, image)
 
 				done <- image
-				wg.Done()
-			}(i, src)
+			}
+			if paced {
+				fetch(i, srcs[i])
+			} else {
+				go fetch(i, srcs[i])
+			}
 		}
 		wg.Wait()
+		if err := store.Flush(); err != nil {
+			logln(ctx, "WARNING: store:", err)
+		}
 		finished <- true
 	}()
 
-	return <-results
+	return <-results, <-errs
 }
 
-func save(title string, zip *bytes.Buffer) (int, error) {
-	log.Println("Create directory")
-	err := os.MkdirAll("downloads", 0755)
+// save writes zip to path in storage. It goes to path.part first, so
+// nothing watching storage ever sees half an archive under path.
+func save(ctx context.Context, storage Storage, path string, zip *Body) (int64, error) {
+	logln(ctx, "Create zip file")
+	part := path + ".part"
+	f, err := storage.Create(part)
 	if err != nil {
 		return 0, err
 	}
 
-	log.Println("Create zip file")
-	f, err := os.Create("downloads/" + title + ".zip")
+	logln(ctx, "Write zip file")
+	n, err := io.Copy(f, zip.Reader())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return 0, err
 	}
-	defer f.Close()
-
-	log.Println("Write zip file")
-	n, err := f.Write(zip.Bytes())
-	if err != nil {
+	if err := storage.Rename(part, path); err != nil {
 		return 0, err
 	}
-	log.Println("Saved", title)
 	return n, nil
 }
 
-func createZip(images []*Image) (*bytes.Buffer, error) {
-	buf := new(bytes.Buffer)
-	writer := zip.NewWriter(buf)
-	defer writer.Close()
-
+// createZip archives images, dating each entry by its Last-Modified or
+// else by scraped, and encrypts them as opts says. The archive spills to
+// disk like the bodies of ctx.
+func createZip(ctx context.Context, images []*Image, scraped time.Time, opts ArchiveOptions) (*Body, error) {
+	buf := spoolBody(ctx)
+	builder, err := newZipBuilder(ctx, buf, opts)
+	if err != nil {
+		buf.Close()
+		return nil, err
+	}
 	for _, image := range images {
-
-		w, err := writer.Create(image.Name)
-		if err != nil {
-			return nil, err
+		modified := image.Modified
+		if modified.IsZero() {
+			modified = scraped
 		}
-
-		_, err = io.Copy(w, image.Bytes)
+		err := builder.Add(image.Name, image.Bytes.Reader(), EntryMeta{Modified: modified, Method: zip.Deflate})
 		if err != nil {
+			buf.Close()
 			return nil, err
 		}
 	}
-
+	if err := builder.Close(); err != nil {
+		buf.Close()
+		return nil, err
+	}
 	return buf, nil
 }
 
-func scrape(page *Page, url string) error {
-	doc, err := page.GetDocument(url)
+// Result describes the outcome of scraping a single URL.
+type Result struct {
+	Page     string        `json:"page"`
+	Url      string        `json:"url"`
+	Title    string        `json:"title"`
+	Path     string        `json:"path"`
+	Images   int           `json:"images"`
+	Failed   int           `json:"failed"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	Skipped  bool          `json:"skipped"`
+	Uploaded string        `json:"uploaded,omitempty"`
+	Errors   []string      `json:"errors"`
+	Started  time.Time     `json:"started"`
+	// Attempts counts the document fetches the page needed.
+	Attempts int    `json:"attempts"`
+	Files    []File `json:"files,omitempty"`
+	// Sample is the image limit of a --sample run.
+	Sample int `json:"sample,omitempty"`
+	// Blocked counts the srcs dropped by the blocklist.
+	Blocked int `json:"blocked,omitempty"`
+	// OffSite counts the URLs skipped for a host outside allowed_hosts.
+	OffSite int `json:"off_site,omitempty"`
+	// Placeholders counts the images flagged by placeholder_threshold.
+	Placeholders int `json:"placeholders,omitempty"`
+	// Unchanged is set when the page was skipped as unchanged since its
+	// last scrape.
+	Unchanged bool `json:"unchanged,omitempty"`
+	// Duplicates counts the images repeating an earlier one, Pruned lists
+	// those left out under prune_duplicates.
+	Duplicates int      `json:"duplicates,omitempty"`
+	Pruned     []Pruned `json:"pruned,omitempty"`
+	// SkippedImages counts the images skipped by image_statuses by their
+	// status.
+	SkippedImages map[int]int `json:"skipped_images,omitempty"`
+	// SkippedTypes counts the images skipped by allow_types by their type.
+	SkippedTypes map[string]int `json:"skipped_types,omitempty"`
+	// ExpectedCount is the image count the page shows and FoundCount the
+	// number of images found, when expected_count_selector is set.
+	ExpectedCount int `json:"expected_count,omitempty"`
+	FoundCount    int `json:"found_count,omitempty"`
+	// Stale is set when the page was scraped from its cached document.
+	Stale *Stale `json:"stale,omitempty"`
+	// Canonical is the <link rel="canonical"> of the page, when it names
+	// another URL than Url.
+	Canonical string `json:"canonical,omitempty"`
+}
+
+// sanitize makes s safe to use as part of a file name.
+func sanitize(s string) string {
+	s = strings.Replace(s, "/", "_", -1)
+	s = strings.Replace(s, " ", "_", -1)
+	return sanitizePlatform(s)
+}
+
+// outputPath is where the archive of result is saved, named by the page's
+// filename template.
+func (s *Scraper) outputPath(page *Page, result *Result) string {
+	tmpl := page.filename
+	if tmpl == nil {
+		tmpl = s.Config.filename
+	}
+	name := result.Title
+	if tmpl != nil {
+		name = tmpl.Expand(templateVars(result))
+	}
+	if 0 < s.Sample {
+		name += ".sample"
+	}
+	return archivePath(name)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Scraper holds the settings shared by every scrape of a run.
+type Scraper struct {
+	Config *Config
+	// SkipExisting skips pages whose archive has already been saved.
+	SkipExisting bool
+	// Stats collects per-download timings when non-nil.
+	Stats *Stats
+	// Report collects every page result for --report when non-nil.
+	Report *Report
+	// Auto guesses the image selector even for pages that configure one.
+	Auto bool
+	// Select asks which of the matched images to download.
+	Select bool
+	// Estimate prints the size of the matched images instead of
+	// downloading them.
+	Estimate bool
+	// Update adds the images missing from an existing archive instead of
+	// replacing it.
+	Update bool
+	// Repair is the archive being repaired, which replaces the usual
+	// output path.
+	Repair string
+	// Resume is the journal of a scrape a stopped run left, finished
+	// from its plan instead of the page.
+	Resume *journal
+	// Sample downloads only the first Sample images of each page into a
+	// separate .sample.zip archive when positive.
+	Sample int
+	// StrictLimits fails pages over their confirm_over_* limits when
+	// nobody can be asked.
+	StrictLimits bool
+	// Unattended never prompts, as in watch and serve.
+	Unattended bool
+	// NoBlocklist ignores blocked_hosts and block_ad_hosts.
+	NoBlocklist bool
+	// Dump logs or records every HTTP exchange when set.
+	Dump *Dump
+	// Cassette records the HTTP exchanges of the run, or replays them.
+	Cassette *Cassette
+	// Storage receives the saved archives, the local filesystem when nil.
+	Storage Storage
+	// Seed makes the randomness of pacing repeatable when not 0.
+	Seed int64
+	// FreshSession starts the cookie_store empty.
+	FreshSession bool
+	// Conditional fetches documents conditionally on their validators
+	// when last saved, and skips the pages unchanged since.
+	Conditional bool
+	// Events, when set, receives every Event of every scrape. It is called
+	// from the downloads of a page concurrently.
+	Events func(Event)
+
+	mu         sync.Mutex
+	transports map[transportOptions]*http.Transport
+	jars       map[*Page]http.CookieJar
+	circuits   circuits
+	proxies    map[string]*proxyPool
+	blobs      *Store
+	cookies    *cookieStore
+	pages      *pageStates
+	sizes      map[string]int64
+	fetch      *fetchers
+}
+
+// scrape downloads every image of url into an archive. progress may be nil.
+func (s *Scraper) scrape(ctx context.Context, page *Page, url string, progress *Progress) (*Result, error) {
+	start := time.Now()
+	result := &Result{Page: page.Name, Url: url, Started: start}
+	if progress == nil {
+		progress = &Progress{}
+	}
+	pageCtx, finish := activity.startPage(ctx, url, progress)
+	defer finish()
+	pageCtx = withEvents(pageCtx, &eventSink{url: url, progress: progress, handler: s.Events})
+	emit(pageCtx, PageStarted{Page: page.Name, Url: url})
+	if timeout := s.pageTimeout(page); 0 < timeout {
+		var cancel context.CancelFunc
+		pageCtx, cancel = context.WithTimeout(pageCtx, timeout)
+		defer cancel()
+	}
+	pageCtx = withTimeouts(pageCtx, s.timeouts(page))
+	pageCtx = withFetchers(pageCtx, s.fetchers())
+	fetch := s.conditional(url)
+	pageCtx = withConditional(pageCtx, fetch)
+	err := s.run(pageCtx, page, url, result)
+	// A page with failed images is scraped again in full next time.
+	if err == nil && result.Failed == 0 && !s.Estimate && s.Sample == 0 {
+		if err := s.recordConditional(fetch); err != nil {
+			logln(ctx, "WARNING: page state:", err)
+		}
+	}
+	if timedOut(pageCtx, err) && ctx.Err() == nil {
+		err = fmt.Errorf("page_timeout of %v exceeded: %w", s.pageTimeout(page), err)
+	}
+	result.Duration = time.Since(start)
 	if err != nil {
-		return err
+		result.Errors = append(result.Errors, err.Error())
+	}
+	metrics.ObservePage(err)
+	if err := s.saveCookies(); err != nil {
+		logln(ctx, "WARNING: cookie_store:", err)
 	}
+	s.Report.Add(result, err)
+	if !s.Estimate {
+		if err := s.recordHistory(result, err); err != nil {
+			logln(ctx, "WARNING: history:", err)
+		}
+	}
+	s.sendWebhook(ctx, page, result, err)
+	if s.Config.Notify || page.Notify {
+		notifyResult(result, err)
+	}
+	return result, err
+}
 
-	title, err := page.GetTitle(doc)
+func (s *Scraper) run(ctx context.Context, page *Page, url string, result *Result) error {
+	if err := s.checkStorage(page); err != nil {
+		return err
+	}
+	ctx = s.withSpool(ctx)
+	allowlist := s.hostAllowlist(page, url)
+	ctx = withAllowlist(ctx, allowlist)
+	defer func() { result.OffSite = allowlist.Skipped() }()
+	client, err := s.client(page)
 	if err != nil {
 		return err
 	}
-	srcs := page.GetImageSrcs(doc)
-	images := downloadImages(srcs)
 
-	zip, err := createZip(images)
+	if argv := s.hook(page.PreScrapeCommand, s.Config.PreScrapeCommand); argv != nil {
+		err := runHook(ctx, "pre_scrape_command", argv, hookVars(page, url, result))
+		if err != nil {
+			return err
+		}
+	}
+
+	var html *sources
+	if s.Config.SaveHtml || page.SaveHtml {
+		html = &sources{}
+		ctx = withSources(ctx, html)
+	}
+
+	braces, err := parseBraces(url)
 	if err != nil {
 		return err
 	}
+	var api *apiResult
+	if s.Resume == nil && braces == nil && page.ApiUrl != "" {
+		api, err = fetchApi(ctx, page, client, url)
+		if err != nil {
+			return err
+		}
+	}
+	var doc *goquery.Document
+	title := ""
+	if s.Resume != nil {
+		title = s.Resume.plan.Title
+	} else if braces != nil {
+		result.Attempts = 1
+		title = sanitize(or(page.Title, braces.title()))
+	} else if api != nil && api.Title != "" {
+		result.Attempts = 1
+		title = api.Title
+	} else {
+		doc, title, err = s.fetchPage(ctx, page, client, url, result)
+		if err == nil && (s.Config.StalePageFallback || page.StalePageFallback) {
+			s.cachePage(ctx, url, doc)
+		}
+		if errors.Is(err, errUnchanged) {
+			logln(ctx, "Unchanged, skip", url)
+			result.Skipped = true
+			result.Unchanged = true
+			return nil
+		}
+		if err != nil && s.documentAction(page, err) == statusSkip {
+			logln(ctx, "Skip", url+":", err)
+			result.Skipped = true
+			return nil
+		}
+		if err != nil && (s.Config.StalePageFallback || page.StalePageFallback) && ctx.Err() == nil {
+			doc, title, err = s.stalePage(ctx, page, url, result, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	title = s.processTitle(page, title)
+	result.Title = title
+	emit(ctx, TitleResolved{Url: url, Title: title})
+	// The canonical URL identifies the page; its images still resolve
+	// against the document fetched.
+	result.Canonical = s.canonical(ctx, page, url, doc)
+	if s.Resume != nil {
+		result.Canonical = s.Resume.plan.Canonical
+	}
+	if fetch := conditionalOf(ctx, url); fetch != nil {
+		fetch.canonical = result.Canonical
+	}
+	if s.Repair != "" {
+		result.Path = s.Repair
+	} else if s.Resume != nil {
+		result.Path = s.Resume.plan.Path
+	} else {
+		result.Path = s.uniquePath(ctx, s.encryptedPath(page, s.outputPath(page, result)), or(result.Canonical, url))
+	}
+
+	if s.SkipExisting && !s.Update && s.Sample == 0 && s.storage().Exists(result.Path) {
+		logln(ctx, "Skip", title, "already saved")
+		result.Skipped = true
+		return nil
+	}
+
+	var srcs []string
+	var parts map[string]string
+	var originals map[string]string
+	if s.Resume != nil {
+		// The journal has the srcs as they were planned.
+		srcs, parts, originals = s.Resume.plan.Srcs, s.Resume.plan.Parts, s.Resume.plan.Originals
+	} else if braces != nil {
+		srcs, err = s.braceSrcs(ctx, page, client, braces)
+		if err != nil {
+			return err
+		}
+	} else if api != nil {
+		logln(ctx, "Found", len(api.Srcs), "images at", api.Url)
+		srcs = api.Srcs
+	} else {
+		selector := page.ImageSelector
+		if page.ImageSource == imageSourceMeta {
+			// The meta tags are the selector.
+		} else if page.Extract == nil && (selector == "" || s.Auto) {
+			selector, err = s.detectImageSelector(doc)
+			if err != nil {
+				return err
+			}
+		}
 
-	_, e := save(title, zip)
+		srcs, err = s.collectSrcs(ctx, page, client, doc, selector)
+		if err != nil {
+			return err
+		}
+		if page.FollowIframes {
+			srcs = append(srcs, s.iframeSrcs(ctx, page, client, doc, selector, 1)...)
+		}
+		if page.MergeSelector != "" {
+			srcs, parts = s.mergeParts(ctx, page, client, doc, selector, srcs)
+		}
+		if len(srcs) == 0 && page.ImageSource != imageSourceMeta {
+			explainImageMiss(ctx, doc, selector)
+		}
+	}
+	if s.Resume == nil {
+		srcs = page.orderSrcs(srcs)
+		srcs, originals = page.rewriteSrcs(srcs)
+		for src, original := range originals {
+			if part, ok := parts[original]; ok {
+				parts[src] = part
+			}
+		}
+		if err := s.checkCount(ctx, page, doc, len(srcs), result); err != nil {
+			return err
+		}
+		srcs = s.blockSrcs(ctx, page, srcs, result)
+		if s.Select {
+			srcs = s.selectSrcs(ctx, srcs)
+		}
+		if 0 < s.Sample && s.Sample < len(srcs) {
+			logln(ctx, "Sample run, downloading", s.Sample, "of", len(srcs), "images")
+			srcs = srcs[:s.Sample]
+		}
+	}
+	ctx = withOriginals(ctx, originals)
+	result.Sample = s.Sample
+	if s.Estimate {
+		logln(ctx, "Estimate for", title+":", estimateSize(ctx, client, srcs).String())
+		result.Skipped = true
+		return nil
+	}
+	var update *update
+	download := srcs
+	if (s.Update || s.Repair != "") && exists(result.Path) {
+		if s.encryption(page).Encrypt != "" {
+			return errors.New("Encrypted archives cannot be updated or repaired")
+		}
+		update, err = planUpdate(ctx, result.Path, srcs)
+		if err != nil {
+			return err
+		}
+		logln(ctx, "Update", result.Path+":", len(update.kept), "archived,", len(update.download), "new")
+		if len(update.download) == 0 {
+			result.Skipped = true
+			return nil
+		}
+		download = update.download
+	} else if s.Resume != nil {
+		update = s.Resume.remaining(ctx, srcs)
+		logln(ctx, "Resume", result.Path+":", len(update.kept), "written,", len(update.download), "left")
+		download = update.download
+	}
+	if s.Resume == nil {
+		err = s.confirm(ctx, page, client, download)
+		if err != nil {
+			return err
+		}
+	}
+	j, err := s.journal(page, url, result, srcs, parts, originals)
+	if err != nil {
+		logln(ctx, "WARNING: journal:", err)
+	}
+	if j != nil {
+		defer j.close()
+	}
+	ctx = withJournal(ctx, j)
 
-	if e != nil {
+	images, errs := s.downloadImages(ctx, page, client, download)
+	defer closeImages(images)
+	for _, image := range images {
+		image.Part = parts[image.Src]
+	}
+	if update != nil {
+		update.renumber(images, errs)
+		images = append(images, update.kept...)
+	}
+	images, typeSkipped := s.filterTypes(ctx, page, result, images)
+	s.fixExtensions(ctx, page, images)
+	s.flagPlaceholders(ctx, page, result, images)
+	images = s.findDuplicates(ctx, page, result, images)
+	errs, skipped, err := s.triageImageErrors(page, result, errs)
+	if err != nil {
+		return err
+	}
+	skipped = append(skipped, typeSkipped...)
+	for _, e := range errs {
+		result.Errors = append(result.Errors, e.Error())
+	}
+	result.Failed = len(errs)
+	if or(page.EntryLayout, s.Config.EntryLayout) == entryLayoutPath {
+		index := page.EntryIndex
+		if index == nil {
+			index = s.Config.EntryIndex
+		}
+		layoutEntries(images, index != nil && *index)
+	}
+	if s.Config.TypeFolders || page.TypeFolders {
+		folderEntries(images)
+	}
+	result.Files = files(images, append(errs[:len(errs):len(errs)], skipped...))
+	if err := ctx.Err(); err != nil {
+		if timedOut(ctx, err) && (s.Config.SalvagePartial || page.SalvagePartial) && 0 < len(images) {
+			if err := salvage(ctx, s.storage(), result, images, s.archiveOptions(page, result)); err != nil {
+				logln(ctx, "Salvage failed:", err)
+			}
+		}
 		return err
 	}
 
-	return nil
-}
+	result.Images = len(images)
+	for _, image := range images {
+		result.Bytes += int64(image.Bytes.Len())
+	}
 
-func cli(page Page, wg *sync.WaitGroup) error {
-	for {
-		fmt.Print("URL:")
-		var url string
+	// Downloads finish in any order; archives list the images as the
+	// page does.
+	images = sortedImages(images)
+	entries := images
+	if html != nil {
+		entries = append(entries[:len(entries):len(entries)], html.files()...)
+	}
+	thumbs := s.thumbnails(ctx, page, images)
+	defer closeImages(thumbs)
+	manifest, err := newManifest(result, images, html, s.Config.AsciiNames || page.AsciiNames, s.encryption(page).Encrypt, s.effective(page, false))
+	if err != nil {
+		return err
+	}
+	entries = append(entries[:len(entries):len(entries)], thumbs...)
+	entries = append(entries, manifest)
+	zip, err := createZip(ctx, entries, result.Started, s.archiveOptions(page, result))
+	if err != nil {
+		return err
+	}
+	defer zip.Close()
 
-		_, err := fmt.Scanln(&url)
+	restore := func(bool) {}
+	if update != nil && s.storesLocally() && exists(result.Path) {
+		restore, err = backup(ctx, result.Path)
 		if err != nil {
 			return err
 		}
-		if len(url) < 0 {
-			break
+	}
+	_, err = save(ctx, s.storage(), result.Path, zip)
+	restore(err == nil)
+	if err != nil {
+		return err
+	}
+	if j != nil {
+		if err := j.commit(); err != nil {
+			logln(ctx, "WARNING: journal:", err)
 		}
-		fmt.Println("→", url)
+	}
+	emit(ctx, ArchiveWritten{Url: url, Path: result.Path, Images: result.Images, Bytes: result.Bytes})
+	if s.Config.ArchiveMtime || page.ArchiveMtime {
+		setArchiveMtime(ctx, result.Path, images)
+	}
 
-		wg.Add(1)
-		go func(page *Page, url string) {
-			err := scrape(page, url)
-			if err != nil {
-				log.Fatal(err)
-			}
-			wg.Done()
-		}(&page, url)
+	if argv := s.hook(page.PostSaveCommand, s.Config.PostSaveCommand); argv != nil {
+		err := runHook(ctx, "post_save_command", argv, hookVars(page, url, result))
+		if err != nil {
+			return err
+		}
+	}
+
+	if upload := s.upload(page); upload != nil {
+		location, err := uploadArchive(ctx, upload, result)
+		if err != nil {
+			return err
+		}
+		result.Uploaded = location
 	}
 
 	return nil
 }
 
-func main() {
-	var config Config
-	_, err := toml.DecodeFile("config.toml", &config)
-	if err != nil {
-		log.Fatal(err)
+func interactive(config *Config, args []string) error {
+	flags := newFlagSet("scrape-go")
+	metricsListen := flags.String("metrics-listen", "", "serve /metrics and /debug/vars on this address")
+	verbose := flags.Bool("verbose", false, "print per-host download statistics at the end")
+	flags.BoolVar(&debugEnabled, "debug", false, "log debug details")
+	flags.BoolVar(&debugSelectors, "debug-selectors", false, "log what pages look like when the title or image selector matches nothing")
+	statsJson := flags.String("stats-json", "", "write per-image download records to this file")
+	urlFile := flags.String("url-file", "", "scrape the URLs listed in this file, one per line, and exit")
+	auto := flags.Bool("auto", false, "guess the image selector instead of using image_selector")
+	report := flags.String("report", "", "write a JSON report of every page and image to this file")
+	interactiveSelect := flags.Bool("interactive-select", false, "choose which of the matched images to download")
+	update := flags.Bool("update", false, "add only the new images of a page to its existing archive")
+	estimate := flags.Bool("estimate", false, "print the number and total size of the matched images without downloading them")
+	sample := flags.Int("sample", 0, "download only the first `n` images of each page into <title>.sample.zip")
+	strictLimits := flags.Bool("strict-limits", false, "fail pages over confirm_over_images or confirm_over_bytes when stdin is not a terminal")
+	noBlocklist := flags.Bool("no-blocklist", false, "download images on blocked_hosts too")
+	deadline := flags.Duration("deadline", 0, "with --url-file, give up on the URLs left after this long")
+	seed := flags.Int64("seed", 0, "seed the randomness of pacing, for repeatable runs")
+	freshSession := flags.Bool("fresh-session", false, "ignore the cookies of cookie_store for this run")
+	forceRefresh := flags.Bool("force-refresh", false, "with --update, scrape pages even when unchanged since their last scrape")
+	dump := dumpFlags(flags)
+	cassette := cassetteFlags(flags)
+	promptFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := checkPromptFlags(); err != nil {
+		return err
+	}
+	if err := config.readPassword(); err != nil {
+		return usageError(err)
+	}
+	if err := cassette.load(); err != nil {
+		return usageError(err)
+	}
+
+	if *metricsListen != "" {
+		go serveMetrics(*metricsListen)
 	}
-	var wg sync.WaitGroup
-	for _, page := range config.Pages {
+
+	scraper := &Scraper{Config: config, Auto: *auto, Select: *interactiveSelect, Sample: *sample, Estimate: *estimate, Update: *update, StrictLimits: *strictLimits, NoBlocklist: *noBlocklist, Dump: dump, Cassette: cassette, Seed: *seed, FreshSession: *freshSession, Conditional: *update && !*forceRefresh}
+	if *verbose || *statsJson != "" {
+		scraper.Stats = &Stats{}
+	}
+	if *report != "" {
+		scraper.Report = &Report{}
+	}
+	if *urlFile != "" {
+		err := batch(scraper, *urlFile, *deadline)
+		scraper.reportCircuits()
+		scraper.reportThrottles()
+		if err := scraper.Stats.Report(*verbose, *statsJson); err != nil {
+			log.Println("Stats:", err)
+		}
+		if err := scraper.Report.Write(*report, exitCode(err)); err != nil {
+			log.Println("Report:", err)
+		}
+		if err := dump.WriteHar(); err != nil {
+			log.Println("HAR:", err)
+		}
+		if err := cassette.Save(); err != nil {
+			log.Println("Cassette:", err)
+		}
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	session := &session{ctx: ctx, scraper: scraper}
+	for i := range config.Pages {
+		if ctx.Err() != nil {
+			break
+		}
+		page := &config.Pages[i]
+		if page.SitemapUrl != "" || 0 < page.CrawlDepth {
+			session.discover(page)
+			continue
+		}
 		err := exec.Command(
 			"open",
 			"-n",
@@ -253,9 +1243,82 @@ func main() {
 			page.Url,
 		).Run()
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		if !cli(session, page) {
+			break
 		}
-		cli(page, &wg)
 	}
-	wg.Wait()
+	err := session.Wait()
+	scraper.reportCircuits()
+	scraper.reportThrottles()
+	if err := scraper.Stats.Report(*verbose, *statsJson); err != nil {
+		log.Println("Stats:", err)
+	}
+	if err := scraper.Report.Write(*report, exitCode(err)); err != nil {
+		log.Println("Report:", err)
+	}
+	if err := dump.WriteHar(); err != nil {
+		log.Println("HAR:", err)
+	}
+	if err := cassette.Save(); err != nil {
+		log.Println("Cassette:", err)
+	}
+	return err
+}
+
+func main() {
+	args := os.Args[1:]
+	command := ""
+	if 0 < len(args) && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	// bench brings a config of its own.
+	var config Config
+	if command != "bench" {
+		if _, err := toml.DecodeFile("config.toml", &config); err != nil {
+			log.Println(err)
+			os.Exit(exitUsage)
+		}
+		if err := config.Validate(); err != nil {
+			log.Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	dumpActivityOnSignal()
+
+	var err error
+	switch command {
+	case "":
+		err = interactive(&config, args)
+	case "watch":
+		err = watch(&config, args)
+	case "serve":
+		err = serve(&config, args)
+	case "repair":
+		err = repair(&config, args)
+	case "store":
+		err = storeCommand(&config, args)
+	case "plan":
+		err = plan(&config, args)
+	case "check":
+		err = check(&config, args)
+	case "config":
+		err = configCommand(&config, args)
+	case "history":
+		err = history(&config, args)
+	case "resume":
+		err = resume(&config, args)
+	case "bench":
+		err = bench(args)
+	default:
+		err = usageError(errors.New("Unknown command " + command))
+	}
+	spools.removeAll()
+	code := exitCode(err)
+	if code != exitOK {
+		log.Println(err)
+	}
+	os.Exit(code)
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// zipUTF8Flag is the general purpose bit marking an entry name as UTF-8.
+const zipUTF8Flag = 0x800
+
+type manifestImage struct {
+	Name string `json:"name"`
+	// AsciiName is Name transliterated to ASCII, with ascii_names.
+	AsciiName string `json:"ascii_name,omitempty"`
+	Url       string `json:"url"`
+	Sha256    string `json:"sha256,omitempty"`
+	// LastModified is kept here at full resolution; zip entry times are
+	// only good to two seconds.
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	// Wayback is the capture downloaded in place of the dead Url.
+	Wayback *Snapshot `json:"wayback,omitempty"`
+	// Thumbnail is the entry name of the thumbnail, with thumbnails.
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// Part is the page of a merged gallery the image was found on.
+	Part string `json:"part,omitempty"`
+	// DuplicateOf is the name of the earlier image this one repeats.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// OriginalName and DeclaredType are the name and Content-Type the
+	// image came with, when fix_extensions renamed it.
+	OriginalName string `json:"original_name,omitempty"`
+	DeclaredType string `json:"declared_type,omitempty"`
+}
+
+// manifest is the manifest.json of every archive.
+type manifest struct {
+	Page    string          `json:"page,omitempty"`
+	Url     string          `json:"url"`
+	Title   string          `json:"title"`
+	Scraped time.Time       `json:"scraped"`
+	Images  []manifestImage `json:"images"`
+	Sources []*source       `json:"sources,omitempty"`
+	// FetchedUrl is the URL the page was fetched from, when Url is its
+	// canonical URL instead.
+	FetchedUrl string `json:"fetched_url,omitempty"`
+	// Pruned are the duplicates left out under prune_duplicates.
+	Pruned []Pruned `json:"pruned,omitempty"`
+	// ExpectedCount and FoundCount are those of the result.
+	ExpectedCount int `json:"expected_count,omitempty"`
+	FoundCount    int `json:"found_count,omitempty"`
+	// Stale is set when the archive was scraped from a cached document.
+	Stale *Stale `json:"stale,omitempty"`
+	// Encryption is the encrypt setting the archive was saved with.
+	Encryption string `json:"encryption,omitempty"`
+	// Settings are the settings in effect for the page but those unset,
+	// with secrets redacted.
+	Settings settings `json:"settings,omitempty"`
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// sortedImages returns images in page order.
+func sortedImages(images []*Image) []*Image {
+	sorted := append([]*Image(nil), images...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Index < sorted[j].Index })
+	return sorted
+}
+
+func newManifest(result *Result, images []*Image, html *sources, ascii bool, encryption string, effective settings) (*Image, error) {
+	m := manifest{
+		Page:          result.Page,
+		Url:           or(result.Canonical, result.Url),
+		Title:         result.Title,
+		Scraped:       result.Started.UTC(),
+		ExpectedCount: result.ExpectedCount,
+		FoundCount:    result.FoundCount,
+		Encryption:    encryption,
+		Pruned:        result.Pruned,
+		Stale:         result.Stale,
+		Settings:      effective,
+	}
+	if result.Canonical != "" {
+		m.FetchedUrl = result.Url
+	}
+	for _, image := range sortedImages(images) {
+		entry := manifestImage{Name: norm.NFC.String(image.Name), Url: displaySrc(image.Src), Sha256: image.Bytes.Sha256(), Wayback: image.Snapshot, Thumbnail: norm.NFC.String(image.Thumbnail), Part: image.Part, DuplicateOf: norm.NFC.String(image.DuplicateOf), OriginalName: norm.NFC.String(image.OriginalName), DeclaredType: image.DeclaredType}
+		if ascii {
+			entry.AsciiName = asciiName(image.Name)
+		}
+		if !image.Modified.IsZero() {
+			modified := image.Modified
+			entry.LastModified = &modified
+		}
+		m.Images = append(m.Images, entry)
+	}
+	if html != nil {
+		m.Sources = html.list()
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Name: "manifest.json", Bytes: newBody(b), Modified: result.Started}, nil
+}
+
+// setArchiveMtime dates path by the newest Last-Modified of images.
+func setArchiveMtime(ctx context.Context, path string, images []*Image) {
+	var newest time.Time
+	for _, image := range images {
+		if image.Modified.After(newest) {
+			newest = image.Modified
+		}
+	}
+	if newest.IsZero() {
+		return
+	}
+	if err := os.Chtimes(path, time.Now(), newest); err != nil {
+		logln(ctx, "WARNING:", err)
+	}
+}
+
+// asciiName transliterates name to ASCII by dropping accents and
+// replacing what is left outside ASCII with "_".
+func asciiName(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+func (p *Page) mediaAttr() string {
+	if p.MediaAttr != "" {
+		return p.MediaAttr
+	}
+	return "src"
+}
+
+func attrSrcs(sel *goquery.Selection, attr string) []string {
+	var srcs []string
+	sel.Each(func(i int, el *goquery.Selection) {
+		if src, ok := el.Attr(attr); ok {
+			srcs = append(srcs, src)
+		}
+	})
+	return srcs
+}
+
+// mediaSrcs returns the srcs of the image and media matches in document
+// order, so clips stay where they appeared between the images.
+func (p *Page) mediaSrcs(doc *goquery.Document, selector string) []string {
+	attrs := make(map[*html.Node]string)
+	find(doc, p.MediaSelector).Each(func(i int, el *goquery.Selection) {
+		attrs[el.Nodes[0]] = p.mediaAttr()
+	})
+	// An element matched by both selectors counts as an image.
+	find(doc, selector).Each(func(i int, el *goquery.Selection) {
+		attrs[el.Nodes[0]] = "src"
+	})
+
+	var srcs []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if attr, ok := attrs[n]; ok {
+			for _, a := range n.Attr {
+				if a.Namespace == "" && a.Key == attr {
+					srcs = append(srcs, a.Val)
+					break
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc.Nodes[0])
+	return srcs
+}
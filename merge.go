@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"strings"
+)
+
+// mergeParts appends to srcs, the images of doc, those of the parts of a
+// gallery split across pages: the documents of the links merge_selector
+// matches in doc, in link order. Images already found are not added
+// again. parts maps every src to the page it was found on.
+func (s *Scraper) mergeParts(ctx context.Context, page *Page, client *http.Client, doc *goquery.Document, selector string, srcs []string) (merged []string, parts map[string]string) {
+	first := doc.Url.String()
+	parts = make(map[string]string, len(srcs))
+	for _, src := range srcs {
+		if _, ok := parts[src]; !ok {
+			parts[src] = first
+		}
+	}
+
+	var links []string
+	find(doc, page.MergeSelector).Each(func(_ int, a *goquery.Selection) {
+		if href, ok := a.Attr("href"); ok {
+			links = append(links, strings.TrimSpace(href))
+		}
+	})
+	seen := map[string]bool{s.Config.urlKey(first): true}
+	for _, link := range resolveSrcs(doc.Url, links) {
+		key := s.Config.urlKey(link)
+		if seen[key] || !strings.HasPrefix(link, "http") {
+			continue
+		}
+		seen[key] = true
+		if ctx.Err() != nil {
+			break
+		}
+		if allowlist := allowlistOf(ctx); !allowlist.allows(link) {
+			allowlist.skip(ctx, "part", link)
+			continue
+		}
+		logln(ctx, "Merge part", link)
+		partDoc, err := page.GetDocument(ctx, client, link)
+		if err != nil {
+			logln(ctx, "WARNING: part", link, err)
+			continue
+		}
+		found, err := s.collectSrcs(ctx, page, client, partDoc, selector)
+		if err != nil {
+			logln(ctx, "WARNING: part", link, err)
+		}
+		if page.FollowIframes {
+			found = append(found, s.iframeSrcs(ctx, page, client, partDoc, selector, 1)...)
+		}
+		added := 0
+		for _, src := range found {
+			if _, ok := parts[src]; ok {
+				continue
+			}
+			parts[src] = link
+			srcs = append(srcs, src)
+			added++
+		}
+		logln(ctx, "Part", link+":", added, "new of", len(found), "images")
+	}
+	return srcs, parts
+}
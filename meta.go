@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+)
+
+const (
+	imageSourceHtml = "html"
+	imageSourceMeta = "meta"
+)
+
+// metaImageSelectors are tried in order; the first with content wins.
+var metaImageSelectors = []string{
+	`meta[property="og:image"]`,
+	`meta[property="og:image:secure_url"]`,
+	`meta[name="twitter:image"]`,
+	`meta[property="twitter:image"]`,
+}
+
+// metaContent returns the content of the first of selectors that has one.
+func metaContent(doc *goquery.Document, selectors ...string) string {
+	for _, selector := range selectors {
+		content, _ := doc.Find(selector).First().Attr("content")
+		if content = strings.TrimSpace(content); content != "" {
+			return content
+		}
+	}
+	return ""
+}
+
+func metaImageSrcs(doc *goquery.Document) ([]string, error) {
+	src := metaContent(doc, metaImageSelectors...)
+	if src == "" {
+		return nil, errors.New("No og:image or twitter:image meta tag found")
+	}
+	return []string{src}, nil
+}
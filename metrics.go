@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// metricsRegistry holds the process-wide counters exposed at /metrics and
+// /debug/vars. It is always updated; it is only served when asked for.
+type metricsRegistry struct {
+	mu               sync.Mutex
+	pagesScraped     int64
+	pagesFailed      int64
+	imagesDownloaded int64
+	imagesFailed     int64
+	bytes            int64
+	failures         map[string]int64
+	buckets          []int64
+	durationCount    int64
+	durationSum      float64
+}
+
+var metrics = &metricsRegistry{
+	failures: make(map[string]int64),
+	buckets:  make([]int64, len(durationBuckets)),
+}
+
+func init() {
+	expvar.Publish("scrape", expvar.Func(func() interface{} {
+		return metrics.snapshot()
+	}))
+}
+
+// failureReason buckets err into a small, stable set of label values.
+func failureReason(err error) string {
+	var netErr net.Error
+	var status *StatusError
+	var challenge *ChallengeError
+	var gate *GateError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	case downloadTimeout(err):
+		return "timeout"
+	case errors.Is(err, errStalled):
+		return "stalled"
+	case errors.Is(err, errHostDown):
+		return "host_down"
+	case errors.As(err, &challenge):
+		return "challenge"
+	case errors.As(err, &gate):
+		return "gated"
+	case errors.Is(err, errNoTitle):
+		return "title"
+	case errors.As(err, &status):
+		return "http_" + strconv.Itoa(status.Code)
+	default:
+		return "other"
+	}
+}
+
+func (m *metricsRegistry) ObserveDownload(d time.Duration, image *Image, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.imagesFailed++
+		m.failures[failureReason(err)]++
+	} else {
+		m.imagesDownloaded++
+		m.bytes += int64(image.Bytes.Len())
+	}
+	seconds := d.Seconds()
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			m.buckets[i]++
+		}
+	}
+	m.durationCount++
+	m.durationSum += seconds
+}
+
+func (m *metricsRegistry) ObservePage(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.pagesFailed++
+		m.failures[failureReason(err)]++
+	} else {
+		m.pagesScraped++
+	}
+}
+
+func (m *metricsRegistry) snapshot() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failures := make(map[string]int64, len(m.failures))
+	for k, v := range m.failures {
+		failures[k] = v
+	}
+	return map[string]interface{}{
+		"pages_scraped":     m.pagesScraped,
+		"pages_failed":      m.pagesFailed,
+		"images_downloaded": m.imagesDownloaded,
+		"images_failed":     m.imagesFailed,
+		"bytes":             m.bytes,
+		"failures":          failures,
+		"download_seconds": map[string]interface{}{
+			"count": m.durationCount,
+			"sum":   m.durationSum,
+		},
+	}
+}
+
+// WritePrometheus writes the registry in the Prometheus text format.
+func (m *metricsRegistry) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counter := func(name string, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("scrape_pages_scraped_total", "Pages scraped successfully.", m.pagesScraped)
+	counter("scrape_pages_failed_total", "Pages that failed.", m.pagesFailed)
+	counter("scrape_images_downloaded_total", "Images downloaded.", m.imagesDownloaded)
+	counter("scrape_images_failed_total", "Images that failed to download.", m.imagesFailed)
+	counter("scrape_bytes_total", "Image bytes downloaded.", m.bytes)
+
+	fmt.Fprintln(w, "# HELP scrape_failures_total Failures by reason.")
+	fmt.Fprintln(w, "# TYPE scrape_failures_total counter")
+	reasons := make([]string, 0, len(m.failures))
+	for reason := range m.failures {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "scrape_failures_total{reason=%q} %d\n", reason, m.failures[reason])
+	}
+
+	fmt.Fprintln(w, "# HELP scrape_download_duration_seconds Image download durations.")
+	fmt.Fprintln(w, "# TYPE scrape_download_duration_seconds histogram")
+	for i, le := range durationBuckets {
+		fmt.Fprintf(w, "scrape_download_duration_seconds_bucket{le=\"%g\"} %d\n", le, m.buckets[i])
+	}
+	fmt.Fprintf(w, "scrape_download_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(w, "scrape_download_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(w, "scrape_download_duration_seconds_count %d\n", m.durationCount)
+}
+
+func handleMetrics(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.WritePrometheus(w)
+	})
+	mux.Handle("GET /debug/vars", expvar.Handler())
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	handleMetrics(mux)
+	log.Println("Metrics on", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		log.Println("Metrics listener stopped:", err)
+	}
+}
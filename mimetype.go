@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types sorts mixed archives by what their entries are, going by the
+// sniffed bytes rather than the URL or Content-Type. AllowTypes,
+// patterns such as "image/png" or "image/*", skips every other type;
+// TypeFolders puts images in images/, videos in video/ and the rest in
+// audio/ or other/. FixExtensions, on by default, renames the entries
+// whose extension is not that of their bytes.
+type Types struct {
+	AllowTypes    []string `toml:"allow_types"`
+	TypeFolders   bool     `toml:"type_folders"`
+	FixExtensions *bool    `toml:"fix_extensions"`
+}
+
+func (t Types) validate() error {
+	for _, pattern := range t.AllowTypes {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.Contains(pattern, "/") {
+			return fmt.Errorf("allow_types: invalid type %q", pattern)
+		}
+	}
+	return nil
+}
+
+// sniffType is the media type of image as http.DetectContentType sees
+// its first bytes.
+func sniffType(image *Image) string {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(image.Bytes.Reader(), head)
+	mediatype, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediatype
+}
+
+func allowedType(patterns []string, mediatype string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, mediatype); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTypes drops the images of page whose type allow_types does not
+// list, closing them, and returns them as skipped errors.
+func (s *Scraper) filterTypes(ctx context.Context, page *Page, result *Result, images []*Image) ([]*Image, []error) {
+	allow := page.AllowTypes
+	if len(allow) == 0 {
+		allow = s.Config.AllowTypes
+	}
+	if len(allow) == 0 {
+		return images, nil
+	}
+	var kept []*Image
+	var skipped []error
+	for _, image := range images {
+		mediatype := sniffType(image)
+		if allowedType(allow, mediatype) {
+			kept = append(kept, image)
+			continue
+		}
+		if result.SkippedTypes == nil {
+			result.SkippedTypes = map[string]int{}
+		}
+		result.SkippedTypes[mediatype]++
+		skipped = append(skipped, &imageError{Index: image.Index, Src: image.Src, Err: errors.New("type " + mediatype + " not allowed"), Skipped: true})
+		image.Bytes.Close()
+	}
+	if 0 < len(skipped) {
+		logln(ctx, "Skipped", len(skipped), "images by type:", skippedTypes(result.SkippedTypes))
+	}
+	return kept, skipped
+}
+
+// fixExtensions gives the images of page the extension of their sniffed
+// type when theirs is another's, as for WebP served as a .jpg with
+// Content-Type image/jpeg, keeping the name and type they came with.
+func (s *Scraper) fixExtensions(ctx context.Context, page *Page, images []*Image) {
+	fix := page.FixExtensions
+	if fix == nil {
+		fix = s.Config.FixExtensions
+	}
+	if fix != nil && !*fix {
+		return
+	}
+	for _, image := range images {
+		mediatype := sniffType(image)
+		ext := extensionForType(mediatype)
+		if ext == ".bin" || mediatype == "application/octet-stream" || strings.HasPrefix(mediatype, "text/") {
+			continue
+		}
+		current := path.Ext(image.Name)
+		if typeByExtension(current) == mediatype {
+			continue
+		}
+		name := strings.TrimSuffix(image.Name, current) + ext
+		logln(ctx, "Rename", image.Name, "to", name+", its bytes are", mediatype, "not", or(image.ContentType, typeByExtension(current)))
+		image.OriginalName, image.DeclaredType = image.Name, image.ContentType
+		image.Name = name
+	}
+}
+
+// typeByExtension is the media type of ext without parameters, "" when
+// unknown.
+func typeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	for mediatype, e := range imageExtensions {
+		if e == ext {
+			return mediatype
+		}
+	}
+	mediatype, _, err := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if err != nil {
+		return ""
+	}
+	return mediatype
+}
+
+// skippedTypes formats counts of skipped images by type, as in
+// "image/gif×2, video/mp4×1".
+func skippedTypes(counts map[string]int) string {
+	types := make([]string, 0, len(counts))
+	for mediatype := range counts {
+		types = append(types, mediatype)
+	}
+	sort.Strings(types)
+	parts := make([]string, len(types))
+	for i, mediatype := range types {
+		parts[i] = mediatype + "×" + strconv.Itoa(counts[mediatype])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// typeFolder is the folder of entries of mediatype under type_folders.
+func typeFolder(mediatype string) string {
+	switch strings.SplitN(mediatype, "/", 2)[0] {
+	case "image":
+		return "images"
+	case "video":
+		return "video"
+	case "audio":
+		return "audio"
+	}
+	return "other"
+}
+
+// folderEntries moves images into the folders of their types. Names keep
+// their page order numbering, so each folder lists in page order too.
+func folderEntries(images []*Image) {
+	for _, image := range images {
+		image.Name = typeFolder(sniffType(image)) + "/" + image.Name
+	}
+}
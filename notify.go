@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; unit <= m; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// quoteAppleScript quotes s as an AppleScript string literal.
+func quoteAppleScript(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// quotePowerShell quotes s as a single-quoted PowerShell string literal.
+func quotePowerShell(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func notificationCommand(title string, message string) (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + quoteAppleScript(message) +
+			" with title " + quoteAppleScript(title)
+		return exec.Command("osascript", "-e", script), nil
+	case "windows":
+		script := `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null;` +
+			`$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02);` +
+			`$texts = $xml.GetElementsByTagName('text');` +
+			`$texts.Item(0).AppendChild($xml.CreateTextNode(` + quotePowerShell(title) + `)) > $null;` +
+			`$texts.Item(1).AppendChild($xml.CreateTextNode(` + quotePowerShell(message) + `)) > $null;` +
+			`[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('scrape-go').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+		return exec.Command("powershell", "-NoProfile", "-Command", script), nil
+	default:
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return nil, errors.New("notify-send not found")
+		}
+		return exec.Command("notify-send", title, message), nil
+	}
+}
+
+// notify pops a desktop notification, falling back to the log when the
+// platform has no way to show one. It never fails.
+func notify(title string, message string) {
+	cmd, err := notificationCommand(title, message)
+	if err == nil {
+		err = cmd.Run()
+	}
+	if err != nil {
+		log.Println("WARNING: notification failed:", err)
+		log.Println(title+":", message)
+	}
+}
+
+func notifyResult(result *Result, err error) {
+	name := result.Url
+	if result.Path != "" {
+		name = filepath.Base(result.Path)
+	}
+	switch {
+	case err != nil:
+		notify("scrape-go failed", fmt.Sprintf("Failed %s — %v", name, err))
+	case result.Skipped:
+		notify("scrape-go", fmt.Sprintf("Skipped %s — already saved", name))
+	default:
+		notify("scrape-go", fmt.Sprintf("Saved %s — %d images, %s", name, result.Images, formatBytes(result.Bytes)))
+	}
+}
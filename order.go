@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const (
+	orderDom       = "dom"
+	orderUrlNumber = "url_number"
+)
+
+// lastNumber is the default token of order_by "url_number", the last run
+// of digits in the file name of a src.
+var lastNumber = regexp.MustCompile(`[0-9]+`)
+
+// Order sorts the images of a page. OrderBy "dom", the default, keeps the
+// order of the page; "url_number" sorts srcs by the last number in their
+// file name, or by the first group of OrderPattern, or its whole match,
+// when set. Ties and srcs without a number keep page order, the latter
+// after the rest.
+type Order struct {
+	OrderBy      string `toml:"order_by"`
+	OrderPattern string `toml:"order_pattern"`
+
+	orderPattern *regexp.Regexp
+}
+
+func (o *Order) compile() error {
+	switch o.OrderBy {
+	case "", orderDom, orderUrlNumber:
+	default:
+		return errors.New("order_by must be " + orderDom + " or " + orderUrlNumber)
+	}
+	if o.OrderPattern != "" {
+		re, err := regexp.Compile(o.OrderPattern)
+		if err != nil {
+			return fmt.Errorf("order_pattern: %v", err)
+		}
+		o.orderPattern = re
+	}
+	return nil
+}
+
+// urlNumber is the number srcs are ordered by, false when src has none.
+func (o *Order) urlNumber(src string) (uint64, bool) {
+	var token string
+	if o.orderPattern != nil {
+		match := o.orderPattern.FindStringSubmatch(src)
+		switch {
+		case match == nil:
+			return 0, false
+		case 1 < len(match):
+			token = match[1]
+		default:
+			token = match[0]
+		}
+	} else {
+		name := src
+		if u, err := url.Parse(src); err == nil {
+			name = path.Base(u.Path)
+		}
+		numbers := lastNumber.FindAllString(name, -1)
+		if len(numbers) == 0 {
+			return 0, false
+		}
+		token = numbers[len(numbers)-1]
+	}
+	n, err := strconv.ParseUint(token, 10, 64)
+	return n, err == nil
+}
+
+// orderSrcs returns srcs in the order of order_by.
+func (o *Order) orderSrcs(srcs []string) []string {
+	if o.OrderBy != orderUrlNumber {
+		return srcs
+	}
+	type numbered struct {
+		src    string
+		number uint64
+		ok     bool
+	}
+	sorted := make([]numbered, len(srcs))
+	for i, src := range srcs {
+		n, ok := o.urlNumber(src)
+		sorted[i] = numbered{src, n, ok}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].ok != sorted[j].ok {
+			return sorted[i].ok
+		}
+		return sorted[i].number < sorted[j].number
+	})
+	ordered := make([]string, len(srcs))
+	for i, n := range sorted {
+		ordered[i] = n.src
+	}
+	return ordered
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	pacingFixed = "fixed"
+	pacingHuman = "human"
+)
+
+const (
+	defaultPacingMin         = 1 * time.Second
+	defaultPacingMax         = 4 * time.Second
+	defaultPacingPause       = 20 * time.Second
+	defaultPacingPauseChance = 0.05
+	defaultPacingWindow      = 4
+)
+
+// Pacing spaces out the requests of a page, whose images are then
+// downloaded one at a time. PacingMode "fixed" waits RequestDelay between
+// requests, and is implied by RequestDelay alone. "human" waits a random time between PacingMin and PacingMax
+// (1s and 4s by default), with a chance of PacingPauseChance (0.05) of a
+// PacingPause (20s) on top, and downloads the images shuffled within
+// windows of PacingWindow (4). Archives keep page order either way.
+type Pacing struct {
+	PacingMode        string   `toml:"pacing"`
+	RequestDelay      Duration `toml:"request_delay"`
+	PacingMin         Duration `toml:"pacing_min"`
+	PacingMax         Duration `toml:"pacing_max"`
+	PacingPause       Duration `toml:"pacing_pause"`
+	PacingPauseChance float64  `toml:"pacing_pause_chance"`
+	PacingWindow      int      `toml:"pacing_window"`
+}
+
+func (p Pacing) validate() error {
+	switch p.PacingMode {
+	case "", pacingFixed, pacingHuman:
+	default:
+		return errors.New("pacing must be " + pacingFixed + " or " + pacingHuman)
+	}
+	if p.PacingMax.Duration != 0 && p.PacingMax.Duration < p.PacingMin.Duration {
+		return errors.New("pacing_max is below pacing_min")
+	}
+	if p.PacingPauseChance < 0 || 1 < p.PacingPauseChance {
+		return errors.New("pacing_pause_chance must be between 0 and 1")
+	}
+	return nil
+}
+
+// pacing is the effective Pacing of page, with defaults filled in. Its
+// PacingMode is empty when requests go out as fast as they come.
+func (s *Scraper) pacing(page *Page) Pacing {
+	global := s.Config.Pacing
+	p := Pacing{PacingMode: or(page.PacingMode, global.PacingMode)}
+	duration := func(page, global Duration, fallback time.Duration) Duration {
+		switch {
+		case page.Duration != 0:
+			return page
+		case global.Duration != 0:
+			return global
+		}
+		return Duration{fallback}
+	}
+	p.RequestDelay = duration(page.RequestDelay, global.RequestDelay, 0)
+	p.PacingMin = duration(page.PacingMin, global.PacingMin, defaultPacingMin)
+	p.PacingMax = duration(page.PacingMax, global.PacingMax, defaultPacingMax)
+	p.PacingPause = duration(page.PacingPause, global.PacingPause, defaultPacingPause)
+	p.PacingPauseChance = page.PacingPauseChance
+	if p.PacingPauseChance == 0 {
+		p.PacingPauseChance = global.PacingPauseChance
+	}
+	if p.PacingPauseChance == 0 {
+		p.PacingPauseChance = defaultPacingPauseChance
+	}
+	p.PacingWindow = page.PacingWindow
+	if p.PacingWindow == 0 {
+		p.PacingWindow = global.PacingWindow
+	}
+	if p.PacingWindow == 0 {
+		p.PacingWindow = defaultPacingWindow
+	}
+	if p.PacingMode == "" && p.RequestDelay.Duration != 0 {
+		p.PacingMode = pacingFixed
+	}
+	if p.PacingMax.Duration < p.PacingMin.Duration {
+		p.PacingMax = p.PacingMin
+	}
+	return p
+}
+
+// random returns a source of randomness for key. With --seed the same
+// key gets the same sequence on every run.
+func (s *Scraper) random(key string) *rand.Rand {
+	seed := time.Now().UnixNano()
+	if s.Seed != 0 {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		seed = s.Seed ^ int64(h.Sum64())
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// pacer holds each request of a client back until its turn.
+type pacer struct {
+	pacing Pacing
+
+	mu   sync.Mutex
+	rnd  *rand.Rand
+	next time.Time
+}
+
+func (p *pacer) delay() time.Duration {
+	if p.pacing.PacingMode == pacingFixed {
+		return p.pacing.RequestDelay.Duration
+	}
+	min, max := p.pacing.PacingMin.Duration, p.pacing.PacingMax.Duration
+	d := min + time.Duration(p.rnd.Int63n(int64(max-min)+1))
+	if p.rnd.Float64() < p.pacing.PacingPauseChance {
+		d += jitter(p.pacing.PacingPause.Duration, 0.5)
+	}
+	return d
+}
+
+func (p *pacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	delay := p.next.Sub(now)
+	p.next = p.next.Add(p.delay())
+	p.mu.Unlock()
+	if 0 < delay {
+		debugln("Pacing", delay)
+	}
+	return sleep(ctx, delay)
+}
+
+type pacingTransport struct {
+	base  http.RoundTripper
+	pacer *pacer
+}
+
+func (t *pacingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.pacer.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// pacingTransport wraps base with a pacer of its own when page is paced.
+func (s *Scraper) pacingTransport(page *Page, base http.RoundTripper) http.RoundTripper {
+	pacing := s.pacing(page)
+	if pacing.PacingMode == "" {
+		return base
+	}
+	return &pacingTransport{base: base, pacer: &pacer{pacing: pacing, rnd: s.random(page.Name + " requests")}}
+}
+
+// downloadOrder is the order in which the n images of page are
+// downloaded: shuffled within windows for human pacing, as in the page
+// otherwise.
+func (s *Scraper) downloadOrder(page *Page, n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	pacing := s.pacing(page)
+	if pacing.PacingMode != pacingHuman {
+		return order
+	}
+	rnd := s.random(page.Name + " order")
+	for start := 0; start < n; start += pacing.PacingWindow {
+		end := start + pacing.PacingWindow
+		if n < end {
+			end = n
+		}
+		window := order[start:end]
+		rnd.Shuffle(len(window), func(i, j int) { window[i], window[j] = window[j], window[i] })
+	}
+	return order
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// errEmpty fails downloads that end without a byte, as when the server
+// closes the connection right away. They are retried like stalls.
+var errEmpty = errors.New("Empty response body")
+
+// flagPlaceholders counts in result the images of page whose bytes at
+// least placeholder_threshold images share, the "image removed" picture
+// hosts serve in place of the ones they took down. They are kept, only
+// reported.
+func (s *Scraper) flagPlaceholders(ctx context.Context, page *Page, result *Result, images []*Image) {
+	threshold := page.PlaceholderThreshold
+	if threshold == 0 {
+		threshold = s.Config.PlaceholderThreshold
+	}
+	if threshold < 2 {
+		return
+	}
+	bySum := map[string][]*Image{}
+	var sums []string
+	for _, image := range images {
+		sum := image.Bytes.Sha256()
+		if bySum[sum] == nil {
+			sums = append(sums, sum)
+		}
+		bySum[sum] = append(bySum[sum], image)
+	}
+	for _, sum := range sums {
+		same := bySum[sum]
+		if len(same) < threshold {
+			continue
+		}
+		result.Placeholders += len(same)
+		logln(ctx, "WARNING:", len(same), "images are the same", formatBytes(int64(same[0].Bytes.Len())), "file, likely a placeholder, such as", displaySrc(same[0].Src))
+	}
+}
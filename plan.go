@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// planned is the archive a URL would be saved to.
+type planned struct {
+	Page   string
+	Url    string
+	Path   string
+	Status string
+}
+
+// plan prints the archive every URL of the URL file, the arguments and
+// the sitemaps of the config would be saved to, fetching documents but
+// no images. It fails when two URLs, or a URL and a saved archive of
+// another one, would take the same path.
+func plan(config *Config, args []string) error {
+	flags := newFlagSet("plan")
+	urlFile := flags.String("url-file", "", "plan the URLs listed in this file, one per line")
+	flags.BoolVar(&debugEnabled, "debug", false, "log debug details")
+	flags.BoolVar(&debugSelectors, "debug-selectors", false, "log what pages look like when the title or image selector matches nothing")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	scraper := &Scraper{Config: config, Unattended: true}
+
+	type target struct {
+		page *Page
+		url  string
+	}
+	var targets []target
+	var plans []*planned
+	var urls []string
+	if *urlFile != "" {
+		var err error
+		urls, err = readUrlFile(*urlFile)
+		if err != nil {
+			return usageError(err)
+		}
+	}
+	urls = append(urls, flags.Args()...)
+	for _, url := range config.dedupeUrls(urls) {
+		page, err := config.MatchPage(url)
+		if err != nil {
+			plans = append(plans, &planned{Url: url, Status: err.Error()})
+			continue
+		}
+		targets = append(targets, target{page, url})
+	}
+	for i := range config.Pages {
+		page := &config.Pages[i]
+		switch {
+		case page.SitemapUrl != "":
+			client, err := scraper.client(page)
+			if err != nil {
+				return err
+			}
+			entries, err := fetchSitemap(ctx, client, page.SitemapUrl, maxSitemapDepth)
+			if err != nil {
+				plans = append(plans, &planned{Page: page.label(), Url: page.SitemapUrl, Status: err.Error()})
+				continue
+			}
+			for _, entry := range entries {
+				if loc := strings.TrimSpace(entry.Loc); loc != "" && page.match(loc) {
+					targets = append(targets, target{page, loc})
+				}
+			}
+		case 0 < page.CrawlDepth:
+			plans = append(plans, &planned{Page: page.label(), Url: page.Url, Status: "not planned, found by crawling"})
+		}
+	}
+
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p := &planned{Page: t.page.label(), Url: t.url}
+		path, err := scraper.planPath(ctx, t.page, t.url)
+		if err != nil {
+			p.Status = err.Error()
+		}
+		p.Path = path
+		plans = append(plans, p)
+	}
+
+	collisions := 0
+	byPath := map[string]*planned{}
+	for _, p := range plans {
+		if p.Path == "" {
+			continue
+		}
+		if first, ok := byPath[p.Path]; ok {
+			if config.urlKey(first.Url) != config.urlKey(p.Url) {
+				p.Status = "COLLISION with " + first.Url
+				collisions++
+			}
+			continue
+		}
+		byPath[p.Path] = p
+		if !scraper.storage().Exists(p.Path) {
+			continue
+		}
+		saved := archiveUrl(p.Path)
+		if saved != "" && config.urlKey(saved) != config.urlKey(p.Url) {
+			p.Status = "COLLISION with saved " + saved
+			collisions++
+		} else {
+			p.Status = "exists"
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PAGE\tURL\tPATH\tSTATUS")
+	for _, p := range plans {
+		fmt.Fprintln(w, p.Page+"\t"+p.Url+"\t"+p.Path+"\t"+p.Status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	log.Println(len(plans), "URLs,", collisions, "collisions")
+	if 0 < collisions {
+		return errors.New(strconv.Itoa(collisions) + " archive paths collide")
+	}
+	return nil
+}
+
+// planPath is the path run would save url to, before the " (2)" of
+// uniquePath, found the way run finds the title.
+func (s *Scraper) planPath(ctx context.Context, page *Page, url string) (string, error) {
+	client, err := s.client(page)
+	if err != nil {
+		return "", err
+	}
+	result := &Result{Page: page.Name, Url: url, Started: time.Now()}
+	braces, err := parseBraces(url)
+	if err != nil {
+		return "", err
+	}
+	var title string
+	switch {
+	case braces != nil:
+		title = sanitize(or(page.Title, braces.title()))
+	case page.ApiUrl != "":
+		var api *apiResult
+		api, err = fetchApi(ctx, page, client, url)
+		if err != nil {
+			return "", err
+		}
+		title = api.Title
+		if title == "" {
+			_, title, err = s.fetchPage(ctx, page, client, url, result)
+		}
+	default:
+		_, title, err = s.fetchPage(ctx, page, client, url, result)
+	}
+	if err != nil {
+		return "", err
+	}
+	result.Title = s.processTitle(page, title)
+	return s.encryptedPath(page, s.outputPath(page, result)), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const preferLargest = "largest"
+
+// defaultPreferAttrs are the attributes prefer "largest" compares, best
+// guess first.
+var defaultPreferAttrs = []string{"data-zoom-image", "data-src", "src"}
+
+// preferAttrs is the prefer_attrs of p, or defaultPreferAttrs.
+func (p *Page) preferAttrs() []string {
+	if 0 < len(p.PreferAttrs) {
+		return p.PreferAttrs
+	}
+	return defaultPreferAttrs
+}
+
+// largestSrcs returns, for every element selector matches in doc, the
+// largest of the URLs in its prefer_attrs by Content-Length. Elements
+// whose candidates report no size keep the first in attribute order.
+func (s *Scraper) largestSrcs(ctx context.Context, page *Page, client *http.Client, doc *goquery.Document, selector string) []string {
+	var candidates [][]string
+	find(doc, selector).Each(func(_ int, el *goquery.Selection) {
+		var urls []string
+		for _, attr := range page.preferAttrs() {
+			if value, ok := el.Attr(attr); ok {
+				urls = append(urls, value)
+			}
+		}
+		candidates = append(candidates, dedupeStrings(resolveSrcs(doc.Url, urls)))
+	})
+
+	// Shared candidates are asked for once, all of them at once.
+	asked := map[string]bool{}
+	var wg sync.WaitGroup
+	for _, urls := range candidates {
+		if len(urls) < 2 {
+			continue
+		}
+		for _, src := range urls {
+			if asked[src] {
+				continue
+			}
+			asked[src] = true
+			wg.Add(1)
+			go func(src string) {
+				defer wg.Done()
+				s.sizeOf(ctx, client, src)
+			}(src)
+		}
+	}
+	wg.Wait()
+
+	srcs := make([]string, 0, len(candidates))
+	for _, urls := range candidates {
+		if len(urls) == 0 {
+			continue
+		}
+		best, bestSize := urls[0], int64(-1)
+		if 1 < len(urls) {
+			for _, src := range urls {
+				if size := s.sizeOf(ctx, client, src); bestSize < size {
+					best, bestSize = src, size
+				}
+			}
+			debugln("Prefer", best, "of", strings.Join(urls, " "))
+		}
+		srcs = append(srcs, best)
+	}
+	return srcs
+}
+
+// sizeOf is the contentLength of src, asked once per run.
+func (s *Scraper) sizeOf(ctx context.Context, client *http.Client, src string) int64 {
+	if isDataUri(src) {
+		return int64(len(src))
+	}
+	s.mu.Lock()
+	size, ok := s.sizes[src]
+	s.mu.Unlock()
+	if ok {
+		return size
+	}
+	size = contentLength(ctx, client, src)
+	if ctx.Err() != nil {
+		return size
+	}
+	s.mu.Lock()
+	if s.sizes == nil {
+		s.sizes = make(map[string]int64)
+	}
+	s.sizes[src] = size
+	s.mu.Unlock()
+	return size
+}
+
+// dedupeStrings drops the repeats of values, keeping the first.
+func dedupeStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	kept := values[:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
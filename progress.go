@@ -0,0 +1,31 @@
+package main
+
+import "sync/atomic"
+
+// Progress counts the images of a running scrape. All methods are safe for
+// concurrent use and do nothing on a nil *Progress.
+type Progress struct {
+	total  int64
+	done   int64
+	failed int64
+	bytes  int64
+}
+
+type ProgressSnapshot struct {
+	Total  int64 `json:"total"`
+	Done   int64 `json:"done"`
+	Failed int64 `json:"failed"`
+	Bytes  int64 `json:"bytes"`
+}
+
+func (p *Progress) Snapshot() ProgressSnapshot {
+	if p == nil {
+		return ProgressSnapshot{}
+	}
+	return ProgressSnapshot{
+		Total:  atomic.LoadInt64(&p.total),
+		Done:   atomic.LoadInt64(&p.done),
+		Failed: atomic.LoadInt64(&p.failed),
+		Bytes:  atomic.LoadInt64(&p.bytes),
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+var (
+	// assumeYes answers every prompt with its default, as --yes does.
+	assumeYes bool
+	// forcePrompts asks even when stdin is not a terminal, as
+	// --interactive does for expect scripts.
+	forcePrompts bool
+)
+
+// promptFlags adds --yes, --non-interactive and --interactive to flags.
+func promptFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&assumeYes, "yes", false, "never prompt, take the default answer of every question")
+	flags.BoolVar(&assumeYes, "non-interactive", false, "same as --yes")
+	flags.BoolVar(&forcePrompts, "interactive", false, "prompt even when stdin is not a terminal")
+}
+
+func checkPromptFlags() error {
+	if assumeYes && forcePrompts {
+		return usageError(errors.New("--yes and --interactive contradict each other"))
+	}
+	return nil
+}
+
+// promptsEnabled reports whether questions are asked at all: on a
+// terminal unless --yes, and always with --interactive.
+func promptsEnabled() bool {
+	if assumeYes {
+		return false
+	}
+	return forcePrompts || stdinIsTerminal()
+}
+
+// canPrompt reports whether s may ask on stdin.
+func (s *Scraper) canPrompt() bool {
+	return !s.Unattended && promptsEnabled()
+}
+
+// ask prints question and returns the answer read from stdin. When s may
+// not prompt, or stdin has nothing left, it logs that it took fallback
+// instead and asked is false.
+func (s *Scraper) ask(ctx context.Context, question, fallback string) (answer string, asked bool) {
+	if s.canPrompt() {
+		fmt.Print(question)
+		answer, err := readLine()
+		if err == nil {
+			return answer, true
+		}
+	}
+	logln(ctx, "Not prompting, answering", strconv.Quote(fallback), "to:", question)
+	return fallback, false
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	proxyRoundRobin = "round-robin"
+	proxyRandom     = "random"
+
+	defaultProxyMaxFailures = 3
+	proxyCooloff            = time.Minute
+)
+
+func (t *Transport) validateProxies() error {
+	switch t.ProxyRotation {
+	case "", proxyRoundRobin, proxyRandom:
+	default:
+		return errors.New("proxy_rotation must be " + proxyRoundRobin + " or " + proxyRandom)
+	}
+	for _, proxy := range t.Proxies {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return errors.New("Unsupported proxy " + proxy)
+		}
+	}
+	return nil
+}
+
+type proxyEntry struct {
+	url      *url.URL
+	failures int
+	// benched is when the proxy was taken out of rotation.
+	benched time.Time
+}
+
+// proxyPool hands out the proxies of one proxies list, leaving out the
+// ones that failed max times in a row for proxyCooloff.
+type proxyPool struct {
+	random bool
+	max    int
+
+	mu      sync.Mutex
+	proxies []*proxyEntry
+	next    int
+}
+
+func newProxyPool(proxies []string, rotation string, max int) *proxyPool {
+	if max == 0 {
+		max = defaultProxyMaxFailures
+	}
+	pool := &proxyPool{random: rotation == proxyRandom, max: max}
+	for _, proxy := range proxies {
+		u, _ := url.Parse(proxy) // checked by Transport.validate
+		pool.proxies = append(pool.proxies, &proxyEntry{url: u})
+	}
+	return pool
+}
+
+func (p *proxyPool) pick() *proxyEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var available []*proxyEntry
+	for _, e := range p.proxies {
+		if e.failures < p.max || proxyCooloff <= time.Since(e.benched) {
+			available = append(available, e)
+		}
+	}
+	if len(available) == 0 {
+		// Every proxy is benched; trying one beats failing everything.
+		available = p.proxies
+	}
+	if p.random {
+		return available[rand.Intn(len(available))]
+	}
+	e := available[p.next%len(available)]
+	p.next++
+	return e
+}
+
+func (p *proxyPool) record(e *proxyEntry, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if e.failures == p.max {
+		debugln("Proxy", e.url.Redacted(), "out of rotation after", e.failures, "failures")
+	}
+	if p.max <= e.failures {
+		e.benched = time.Now()
+	}
+}
+
+type proxyKey struct{}
+
+// proxyFromContext is the Proxy of every transport: the proxy picked by
+// proxyTransport, or the environment's.
+func proxyFromContext(req *http.Request) (*url.URL, error) {
+	if u, ok := req.Context().Value(proxyKey{}).(*url.URL); ok {
+		return u, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// proxyTransport sends each request through a proxy of pool. A sticky
+// transport keeps the first proxy it picked, which lasts one page since
+// every scrape gets its own client.
+type proxyTransport struct {
+	base   http.RoundTripper
+	pool   *proxyPool
+	sticky bool
+
+	mu     sync.Mutex
+	picked *proxyEntry
+}
+
+func (t *proxyTransport) pick() *proxyEntry {
+	if !t.sticky {
+		return t.pool.pick()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.picked == nil {
+		t.picked = t.pool.pick()
+	}
+	return t.picked
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxy := t.pick()
+	debugln("Proxy", proxy.url.Redacted(), "for", req.URL)
+	req = req.WithContext(context.WithValue(req.Context(), proxyKey{}, proxy.url))
+	res, err := t.base.RoundTrip(req)
+	if !errors.Is(err, context.Canceled) {
+		t.pool.record(proxy, err)
+	}
+	return res, err
+}
+
+// proxyTransport wraps base with the proxies of page, if any. The caller
+// must hold s.mu.
+func (s *Scraper) proxyTransport(page *Page, base http.RoundTripper) http.RoundTripper {
+	t := page.Transport
+	if len(t.Proxies) == 0 {
+		t = s.Config.Transport
+	}
+	if len(t.Proxies) == 0 {
+		return base
+	}
+	key := strings.Join(t.Proxies, " ")
+	pool, ok := s.proxies[key]
+	if !ok {
+		pool = newProxyPool(t.Proxies, or(page.ProxyRotation, s.Config.ProxyRotation), t.ProxyMaxFailures)
+		if s.proxies == nil {
+			s.proxies = make(map[string]*proxyPool)
+		}
+		s.proxies[key] = pool
+	}
+	return &proxyTransport{base: base, pool: pool, sticky: page.ProxySticky || s.Config.ProxySticky}
+}
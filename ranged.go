@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const defaultRangedChunks = 4
+
+// Ranged splits downloads of files over RangedDownloadOver bytes into
+// RangedChunks concurrent range requests.
+type Ranged struct {
+	RangedDownloadOver int64 `toml:"ranged_download_over"`
+	RangedChunks       int   `toml:"ranged_chunks"`
+}
+
+// download fetches src, in ranges when it is large enough and its host
+// accepts them, and with a plain GET otherwise.
+func (s *Scraper) download(ctx context.Context, client *http.Client, src string) (*Image, error) {
+	over := s.Config.RangedDownloadOver
+	if over <= 0 || isDataUri(src) {
+		return downloadImage(ctx, client, src)
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", src, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return downloadImage(ctx, client, src)
+	}
+	res.Body.Close()
+	if 400 <= res.StatusCode || res.ContentLength <= over || res.Header.Get("Accept-Ranges") != "bytes" {
+		return downloadImage(ctx, client, src)
+	}
+
+	setDownloadTotal(ctx, res.ContentLength)
+	chunks := s.Config.RangedChunks
+	if chunks <= 0 {
+		chunks = defaultRangedChunks
+	}
+	buf, err := downloadRanges(ctx, client, src, res.ContentLength, chunks)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logln(ctx, "Ranged download of", src, "failed, falling back to one request:", err)
+		return downloadImage(ctx, client, src)
+	}
+	return newImage(src, res.Header, buf), nil
+}
+
+// downloadRanges fetches size bytes of src in chunks requests written into
+// a temp file at their offsets, then reads the stitched file back.
+func downloadRanges(ctx context.Context, client *http.Client, src string, size int64, chunks int) (*Body, error) {
+	opts, _ := ctx.Value(spoolKey{}).(spoolOptions)
+	f, err := spools.create(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer spools.remove(f)
+	if err := f.Truncate(size); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, chunks)
+	step := (size + int64(chunks) - 1) / int64(chunks)
+	for start := int64(0); start < size; start += step {
+		end := start + step - 1
+		if size <= end {
+			end = size - 1
+		}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			if err := downloadRange(ctx, client, src, f, start, end, size); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	body := spoolBody(ctx)
+	if _, err := io.Copy(body, io.NewSectionReader(f, 0, size)); err != nil {
+		body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+func downloadRange(ctx context.Context, client *http.Client, src string, f *os.File, start, end, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+	res, err := doTimed(client, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	want := fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+	if res.StatusCode != http.StatusPartialContent || res.Header.Get("Content-Range") != want {
+		return errors.New("Range " + want + " answered with " + res.Status + " " + res.Header.Get("Content-Range"))
+	}
+	n, err := io.Copy(io.NewOffsetWriter(f, start), countBody(ctx, res.Body))
+	if err != nil {
+		return err
+	}
+	if n != end-start+1 {
+		return errors.New("Short range " + want)
+	}
+	return nil
+}
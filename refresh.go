@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"net/url"
+	"strings"
+)
+
+// metaRefresh returns the target of a <meta http-equiv="refresh"> in doc,
+// resolved against the document URL, or nil when there is none.
+func metaRefresh(doc *goquery.Document) *url.URL {
+	var target *url.URL
+	doc.Find("meta[http-equiv][content]").EachWithBreak(func(i int, el *goquery.Selection) bool {
+		equiv, _ := el.Attr("http-equiv")
+		if !strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+			return true
+		}
+		content, _ := el.Attr("content")
+		semicolon := strings.IndexAny(content, ";,")
+		if semicolon < 0 {
+			return true
+		}
+		rest := strings.TrimSpace(content[semicolon+1:])
+		if len(rest) < 4 || !strings.EqualFold(rest[:4], "url=") {
+			return true
+		}
+		raw := strings.Trim(strings.TrimSpace(rest[4:]), `'"`)
+		u, err := doc.Url.Parse(raw)
+		if err != nil || raw == "" {
+			return true
+		}
+		target = u
+		return false
+	})
+	return target
+}
+
+func (p *Page) allowRefresh(from *url.URL, to *url.URL) bool {
+	if to.Scheme != "http" && to.Scheme != "https" {
+		return false
+	}
+	if strings.EqualFold(from.Hostname(), to.Hostname()) {
+		return true
+	}
+	for _, host := range p.MetaRefreshHosts {
+		if strings.EqualFold(host, to.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
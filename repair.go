@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// repair re-scrapes the source URL recorded in an archive's manifest and
+// rewrites the archive with its missing or corrupt entries downloaded again.
+func repair(config *Config, args []string) error {
+	flags := newFlagSet("repair")
+	flags.BoolVar(&debugEnabled, "debug", false, "log debug details")
+	flags.BoolVar(&debugSelectors, "debug-selectors", false, "log what pages look like when the title or image selector matches nothing")
+	promptFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := checkPromptFlags(); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError(errors.New("Usage: scrape-go repair <archive>"))
+	}
+	path := flags.Arg(0)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	_, m, err := readArchive(ctx, path)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return errors.New(path + " has no manifest.json to repair from")
+	}
+	page := config.FindPage(m.Page)
+	if page == nil {
+		page, err = config.MatchPage(m.Url)
+		if err != nil {
+			return err
+		}
+	}
+
+	scraper := &Scraper{Config: config, Repair: path}
+	result, err := scraper.scrape(ctx, page, m.Url, nil)
+	if err != nil {
+		return err
+	}
+	if result.Skipped {
+		log.Println("Nothing to repair in", path)
+	} else {
+		log.Println("Repaired", path+":", result.Images, "images,", result.Failed, "failed")
+	}
+	if 0 < result.Failed {
+		return &exitError{Code: exitPartial, Err: errors.New("Some images could not be repaired")}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cliJob is a scrape started from the prompt of cli.
+type cliJob struct {
+	ID       int
+	Url      string
+	progress *Progress
+	cancel   context.CancelFunc
+	// done is closed once the job has finished and reported.
+	done chan struct{}
+
+	mu     sync.Mutex
+	status string
+	err    error
+}
+
+func (j *cliJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case err == nil:
+		j.status = jobDone
+	case j.status == jobCancelled:
+	default:
+		j.status = jobFailed
+	}
+	j.err = err
+}
+
+func (j *cliJob) String() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p := j.progress.Snapshot()
+	s := fmt.Sprintf("%3d %-9s %d/%d images, %d failed, %s  %s",
+		j.ID, j.status, p.Done, p.Total, p.Failed, formatBytes(p.Bytes), j.Url)
+	if j.err != nil && j.status != jobCancelled {
+		s += "\n    " + j.err.Error()
+	}
+	return s
+}
+
+// session holds the jobs of every cli prompt of a run. Its jobs stop with
+// ctx, as the prompt does.
+type session struct {
+	ctx     context.Context
+	scraper *Scraper
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	jobs    []*cliJob
+	summary cycleSummary
+}
+
+func (s *session) start(page *Page, url string) *cliJob {
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.mu.Lock()
+	ctx = withJob(ctx, strconv.Itoa(len(s.jobs)+1))
+	job := &cliJob{
+		ID:       len(s.jobs) + 1,
+		Url:      url,
+		progress: &Progress{},
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		status:   jobRunning,
+	}
+	s.jobs = append(s.jobs, job)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(job.done)
+		defer cancel()
+		result, err := s.scraper.scrape(ctx, page, url, job.progress)
+		job.finish(err)
+		s.mu.Lock()
+		s.summary.Add(result, err)
+		s.mu.Unlock()
+		if err != nil {
+			fmt.Println("\nJob", job.ID, "failed:", err)
+		} else {
+			fmt.Println("\nJob", job.ID, "saved", result.Path)
+		}
+	}()
+	return job
+}
+
+// discover scrapes the sitemap of page, or crawls from it, before the
+// prompt moves on.
+func (s *session) discover(page *Page) {
+	add := func(result *Result, err error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.summary.Add(result, err)
+	}
+	if page.SitemapUrl != "" {
+		s.scraper.scrapeSitemap(s.ctx, page, add)
+	} else {
+		s.scraper.crawl(s.ctx, page, add)
+	}
+}
+
+func (s *session) job(id string) *cliJob {
+	n, err := strconv.Atoi(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || n < 1 || len(s.jobs) < n {
+		return nil
+	}
+	return s.jobs[n-1]
+}
+
+func (s *session) status() {
+	s.mu.Lock()
+	jobs := append([]*cliJob(nil), s.jobs...)
+	s.mu.Unlock()
+	if len(jobs) == 0 {
+		fmt.Println("No jobs")
+	}
+	for _, job := range jobs {
+		fmt.Println(job)
+	}
+	activity.Write(os.Stdout)
+}
+
+// Wait waits for every job and reports the failed ones like batch does,
+// or the interruption of the session.
+func (s *session) Wait() error {
+	s.wg.Wait()
+	if errors.Is(s.ctx.Err(), context.Canceled) {
+		return s.ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.summary.Err()
+}
+
+// readLineContext is readLine giving up when ctx is done. The line being
+// read then goes unread.
+func readLineContext(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	type read struct {
+		line string
+		err  error
+	}
+	lines := make(chan read, 1)
+	go func() {
+		line, err := readLine()
+		lines <- read{line, err}
+	}()
+	select {
+	case r := <-lines:
+		return r.line, r.err
+	case <-ctx.Done():
+		fmt.Println()
+		return "", ctx.Err()
+	}
+}
+
+// cli reads URLs of page from stdin and scrapes each in the background.
+// An empty line moves on to the next page; quit, EOF or the end of the
+// session's ctx ends the session, which cli reports by returning false.
+func cli(s *session, page *Page) bool {
+	for {
+		fmt.Print("URL:")
+		line, err := readLineContext(s.ctx)
+		if err != nil {
+			return false
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			return true
+		case fields[0] == "quit":
+			return false
+		case fields[0] == "status":
+			s.status()
+		case fields[0] == "cancel":
+			if len(fields) != 2 {
+				fmt.Println("Usage: cancel N")
+				break
+			}
+			job := s.job(fields[1])
+			if job == nil {
+				fmt.Println("No job", fields[1])
+				break
+			}
+			job.mu.Lock()
+			if job.status == jobRunning {
+				job.status = jobCancelled
+			}
+			job.mu.Unlock()
+			job.cancel()
+		default:
+			url := fields[0]
+			fmt.Println("→", url)
+			job := s.start(page, url)
+			if s.scraper.prompts(page) {
+				// Prompts of the page need stdin until it is done.
+				<-job.done
+			} else {
+				fmt.Println("Job", job.ID, "started")
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// An interrupted session stops its prompt and its jobs, and ends the run
+// as interrupted.
+func TestSessionInterrupted(t *testing.T) {
+	scraper, page := replayScraper(t, "gallery")
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &session{ctx: ctx, scraper: scraper}
+	cancel()
+
+	job := session.start(page, "https://gallery.example/g/42")
+	if cli(session, page) {
+		t.Error("the prompt went on to the next page")
+	}
+	err := session.Wait()
+	if code := exitCode(err); code != exitInterrupted {
+		t.Errorf("exit code %d (%v), want %d", code, err, exitInterrupted)
+	}
+	if job.status != jobFailed || job.err == nil {
+		t.Errorf("job %s: %v", job.status, job.err)
+	}
+}
+
+// A job is done on its own, while the jobs started before it still run.
+func TestJobDone(t *testing.T) {
+	quiet(t)
+	inTempDir(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	config := &Config{Pages: []Page{{Name: "gallery", TitleSelector: "h1", ImageSelector: "img"}}}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	session := &session{ctx: context.Background(), scraper: &Scraper{Config: config, Unattended: true}}
+	defer session.Wait()
+	defer close(release)
+
+	slow := session.start(&config.Pages[0], server.URL+"/slow")
+	fast := session.start(&config.Pages[0], server.URL+"/fast")
+	select {
+	case <-fast.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second job never finished")
+	}
+	select {
+	case <-slow.done:
+		t.Error("the first job finished before its page was served")
+	default:
+	}
+}
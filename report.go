@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+)
+
+// File is the outcome of downloading one image of a page.
+type File struct {
+	Index int    `json:"index"`
+	Url   string `json:"url"`
+	Name  string `json:"name,omitempty"`
+	Bytes int    `json:"bytes"`
+	Error string `json:"error,omitempty"`
+	// Skipped is set for images not tried because their host was down,
+	// or skipped for their status by image_statuses.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// imageError is a failed download of the image at Index.
+type imageError struct {
+	Index int
+	Src   string
+	Err   error
+	// Skipped drops the image from the archive without failing it.
+	Skipped bool
+}
+
+func (e *imageError) Error() string {
+	return displaySrc(e.Src) + ": " + e.Err.Error()
+}
+
+func (e *imageError) Unwrap() error {
+	return e.Err
+}
+
+// files lists the downloaded and failed images in page order.
+func files(images []*Image, errs []error) []File {
+	files := make([]File, 0, len(images)+len(errs))
+	for _, image := range images {
+		files = append(files, File{
+			Index: image.Index,
+			Url:   displaySrc(image.Src),
+			Name:  image.Name,
+			Bytes: image.Bytes.Len(),
+		})
+	}
+	for _, err := range errs {
+		if e, ok := err.(*imageError); ok {
+			files = append(files, File{
+				Index:   e.Index,
+				Url:     displaySrc(e.Src),
+				Error:   e.Err.Error(),
+				Skipped: e.Skipped || errors.Is(e.Err, errHostDown),
+			})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Index < files[j].Index })
+	return files
+}
+
+type reportPage struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Reason buckets Error as the failures of /metrics do.
+	Reason string `json:"reason,omitempty"`
+	*Result
+}
+
+// Report collects the result of every page for --report.
+type Report struct {
+	mu    sync.Mutex
+	pages []reportPage
+}
+
+func (r *Report) Add(result *Result, err error) {
+	if r == nil {
+		return
+	}
+	page := reportPage{Status: "success", Result: result}
+	switch {
+	case err != nil:
+		page.Status = "failure"
+		page.Error = err.Error()
+		page.Reason = failureReason(err)
+	case result.Skipped:
+		page.Status = "skipped"
+	case 0 < result.Failed:
+		page.Status = "partial"
+	}
+	r.mu.Lock()
+	r.pages = append(r.pages, page)
+	r.mu.Unlock()
+}
+
+func (r *Report) Write(path string, code int) error {
+	if r == nil || path == "" {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		ExitCode int          `json:"exit_code"`
+		Pages    []reportPage `json:"pages"`
+	}{code, r.pages})
+}
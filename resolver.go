@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// Resolver changes how the hosts of every page are resolved. Dns is the
+// DNS server asked instead of the system's, as "1.1.1.1" or
+// "1.1.1.1:53". Hosts pins hostnames to IPs before any lookup, as curl
+// --resolve does; TLS still verifies the hostname.
+type Resolver struct {
+	Dns   string            `toml:"dns"`
+	Hosts map[string]string `toml:"hosts"`
+}
+
+func (r *Resolver) validate() error {
+	if r.Dns != "" {
+		if _, _, err := net.SplitHostPort(r.Dns); err != nil {
+			r.Dns = net.JoinHostPort(r.Dns, "53")
+		}
+		if _, _, err := net.SplitHostPort(r.Dns); err != nil {
+			return errors.New("dns: " + err.Error())
+		}
+	}
+	hosts := make(map[string]string, len(r.Hosts))
+	for host, ip := range r.Hosts {
+		if net.ParseIP(ip) == nil {
+			return errors.New("hosts: " + host + " maps to " + ip + ", which is not an IP")
+		}
+		hosts[strings.ToLower(host)] = ip
+	}
+	r.Hosts = hosts
+	return nil
+}
+
+// dialContext dials through Hosts and Dns, or is nil when neither is
+// set.
+func (r Resolver) dialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if r.Dns == "" && len(r.Hosts) == 0 {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if r.Dns != "" {
+		dns := r.Dns
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, network, dns)
+			},
+		}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		switch ip, ok := r.Hosts[strings.ToLower(host)]; {
+		case ok:
+			debugln("Resolve", host, "to", ip, "from hosts")
+			addr = net.JoinHostPort(ip, port)
+		case net.ParseIP(host) != nil:
+		case r.Dns != "":
+			debugln("Resolve", host, "with", r.Dns)
+		default:
+			debugln("Resolve", host, "with the system resolver")
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"net"
+	"net/http"
+	"time"
+)
+
+const defaultPageRetryDelay = 10 * time.Second
+
+var errNoTitle = errors.New("Failed to get title")
+
+// StatusError is an HTTP response with a 4xx or 5xx status.
+type StatusError struct {
+	Url    string
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return e.Status + " " + e.Url
+}
+
+// transient reports whether retrying err later might succeed.
+func transient(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return 500 <= status.Code || status.Code == http.StatusTooManyRequests ||
+			status.Code == http.StatusRequestTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errNoTitle)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetchPage fetches the document and its title, retrying transient
+// failures page_retries times with exponential backoff.
+func (s *Scraper) fetchPage(ctx context.Context, page *Page, client *http.Client, url string, result *Result) (*goquery.Document, string, error) {
+	retries := 0
+	if page.PageRetries != nil {
+		retries = *page.PageRetries
+	} else if s.Config.PageRetries != nil {
+		retries = *s.Config.PageRetries
+	}
+	delay := page.PageRetryDelay.Duration
+	if delay == 0 {
+		delay = s.Config.PageRetryDelay.Duration
+	}
+	if delay == 0 {
+		delay = defaultPageRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		result.Attempts = attempt + 1
+		resetSources(ctx)
+		doc, err := page.GetDocument(ctx, client, url)
+		title := ""
+		if err == nil {
+			// A gated document will not change by asking again.
+			if err := page.gate(doc, url); err != nil {
+				return nil, "", err
+			}
+			title, err = page.GetTitle(doc)
+		}
+		if err == nil {
+			if 0 < attempt {
+				logln(ctx, "Fetched", url, "after", result.Attempts, "attempts")
+			}
+			return doc, title, nil
+		}
+		if doc != nil && errors.Is(err, errNoTitle) {
+			if captcha := captchaChallenge(doc); captcha != "" {
+				return nil, "", &ChallengeError{Url: url, Vendor: captcha}
+			}
+		}
+		retry := transient(err)
+		var status *StatusError
+		if errors.As(err, &status) {
+			retry = s.documentAction(page, err) == statusRetry
+		}
+		if retries <= attempt || !retry || !fits(ctx, delay<<attempt) {
+			if doc != nil && errors.Is(err, errNoTitle) {
+				explainTitleMiss(ctx, page, doc)
+			}
+			if doc != nil && errors.Is(err, errNoTitle) && !(s.Config.StrictTitle || page.StrictTitle) {
+				title = urlTitle(url)
+				logln(ctx, "No title found, naming", url, "as", title)
+				return doc, title, nil
+			}
+			return nil, "", err
+		}
+		logln(ctx, "Attempt", result.Attempts, "of", url, "failed:", err)
+		if err := sleep(ctx, delay<<attempt); err != nil {
+			return nil, "", err
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"github.com/BurntSushi/toml"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// retriesConfig is a config with a page of page_retries and
+// image_retries set as in pageKeys, under those of the config in keys.
+func retriesConfig(t *testing.T, keys string, pageKeys string) *Config {
+	t.Helper()
+	var config Config
+	doc := keys + "\n[[pages]]\nname = \"gallery\"\ntitle_selector = \"h1\"\nimage_selector = \"img\"\n" + pageKeys
+	if _, err := toml.Decode(doc, &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return &config
+}
+
+func TestImageRetries(t *testing.T) {
+	for _, test := range []struct {
+		keys, pageKeys string
+		want           int
+	}{
+		{"", "", defaultImageRetries},
+		{"image_retries = 5", "", 5},
+		{"image_retries = 0", "", 0},
+		{"image_retries = 5", "image_retries = 0", 0},
+		{"image_retries = 0", "image_retries = 3", 3},
+		{"[image_fetch]\nretries = 0", "", 0},
+	} {
+		config := retriesConfig(t, test.keys, test.pageKeys)
+		scraper := &Scraper{Config: config}
+		if got := scraper.imageRetries(&config.Pages[0]); got != test.want {
+			t.Errorf("%q, page %q: %d retries, want %d", test.keys, test.pageKeys, got, test.want)
+		}
+	}
+}
+
+func TestPageRetries(t *testing.T) {
+	quiet(t)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		keys, pageKeys string
+		want           int32
+	}{
+		{"", "", 1},
+		{"page_retries = 2\npage_retry_delay = \"1ms\"", "", 3},
+		{"page_retries = 2\npage_retry_delay = \"1ms\"", "page_retries = 0", 1},
+		{"page_retries = 0", "page_retries = 1\npage_retry_delay = \"1ms\"", 2},
+	} {
+		config := retriesConfig(t, test.keys, test.pageKeys)
+		scraper := &Scraper{Config: config}
+		atomic.StoreInt32(&requests, 0)
+		_, _, err := scraper.fetchPage(context.Background(), &config.Pages[0], server.Client(), server.URL+"/g/1", &Result{})
+		if err == nil {
+			t.Fatalf("%q, page %q: fetched a 503", test.keys, test.pageKeys)
+		}
+		if got := atomic.LoadInt32(&requests); got != test.want {
+			t.Errorf("%q, page %q: %d requests, want %d", test.keys, test.pageKeys, got, test.want)
+		}
+	}
+}
+
+// config resolve shows the 0 a page sets over the retries of the config.
+func TestEffectiveRetries(t *testing.T) {
+	config := retriesConfig(t, "page_retries = 2\nimage_retries = 5", "page_retries = 0\nimage_retries = 0")
+	scraper := &Scraper{Config: config}
+	settings := scraper.effective(&config.Pages[0], true)
+	for _, key := range []string{"page_retries", "image_retries"} {
+		if settings[key] != 0 {
+			t.Errorf("%s = %v, want 0", key, settings[key])
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// SrcRule replaces what the regexp Find matches in a resolved src with
+// Replace, in which $1 or ${name} stand for the groups of the match.
+type SrcRule struct {
+	Find    string
+	Replace string
+
+	find *regexp.Regexp
+}
+
+func compileSrcRules(rules []SrcRule) error {
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Find)
+		if err != nil {
+			return fmt.Errorf("src_rewrite: %v", err)
+		}
+		rules[i].find = re
+	}
+	return nil
+}
+
+// rewriteSrcs applies the src_rewrite of p to every src in order. The
+// srcs a rule changed map to what they were in originals.
+func (p *Page) rewriteSrcs(srcs []string) (rewritten []string, originals map[string]string) {
+	if len(p.SrcRewrite) == 0 {
+		return srcs, nil
+	}
+	rewritten = make([]string, len(srcs))
+	originals = map[string]string{}
+	for i, src := range srcs {
+		rewritten[i] = src
+		if isDataUri(src) {
+			continue
+		}
+		for _, rule := range p.SrcRewrite {
+			rewritten[i] = rule.find.ReplaceAllString(rewritten[i], rule.Replace)
+		}
+		if rewritten[i] != src {
+			originals[rewritten[i]] = src
+		}
+	}
+	return rewritten, originals
+}
+
+type originalsKey struct{}
+
+func withOriginals(ctx context.Context, originals map[string]string) context.Context {
+	return context.WithValue(ctx, originalsKey{}, originals)
+}
+
+// originalSrc is what src was before src_rewrite in the scrape of ctx,
+// empty when no rule changed it.
+func originalSrc(ctx context.Context, src string) string {
+	originals, _ := ctx.Value(originalsKey{}).(map[string]string)
+	return originals[src]
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPartSize = 16 << 20
+
+// s3Uploader talks to S3-compatible stores such as MinIO with path-style
+// requests signed with AWS Signature Version 4.
+type s3Uploader struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	token     string
+	partSize  int64
+	client    *http.Client
+}
+
+func newS3Uploader(u *Upload) (*s3Uploader, error) {
+	endpoint, err := url.Parse(u.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	accessEnv := or(u.AccessKeyEnv, "AWS_ACCESS_KEY_ID")
+	secretEnv := or(u.SecretKeyEnv, "AWS_SECRET_ACCESS_KEY")
+	s := &s3Uploader{
+		endpoint:  endpoint,
+		region:    or(u.Region, "us-east-1"),
+		bucket:    u.Bucket,
+		prefix:    u.Prefix,
+		accessKey: os.Getenv(accessEnv),
+		secretKey: os.Getenv(secretEnv),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		partSize:  u.PartSize,
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("s3 credentials missing: set " + accessEnv + " and " + secretEnv)
+	}
+	if s.partSize < 5<<20 {
+		s.partSize = defaultPartSize
+	}
+	return s, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// uriEncode encodes s as SigV4 requires: everything but unreserved
+// characters, and "/" too unless it separates path segments.
+func uriEncode(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && path:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func (s *s3Uploader) sign(req *http.Request, payloadHash string) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, true),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func (s *s3Uploader) objectUrl(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	// Send the path encoded exactly as it was signed.
+	u.RawPath = uriEncode(u.Path, true)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request and returns the response body, failing on any
+// non-2xx status.
+func (s *s3Uploader) do(ctx context.Context, method string, key string, query url.Values, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectUrl(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, sha256Hex(body))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode < 200 || 299 < res.StatusCode {
+		return nil, nil, errors.New("s3 " + method + " " + key + ": " + res.Status + " " + string(data))
+	}
+	return res, data, nil
+}
+
+func (s *s3Uploader) Upload(ctx context.Context, local string, name string) (string, error) {
+	key := strings.TrimPrefix(s.prefix+name, "/")
+	f, err := os.Open(local)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if info.Size() <= s.partSize {
+		body, err := io.ReadAll(f)
+		if err != nil {
+			return "", err
+		}
+		err = withRetries(ctx, "s3 put", func() error {
+			_, _, err := s.do(ctx, "PUT", key, nil, body)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	} else if err := s.multipart(ctx, f, key); err != nil {
+		return "", err
+	}
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (s *s3Uploader) multipart(ctx context.Context, f io.Reader, key string) error {
+	var initiated struct {
+		UploadId string
+	}
+	err := withRetries(ctx, "s3 create multipart upload", func() error {
+		_, data, err := s.do(ctx, "POST", key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		return xml.Unmarshal(data, &initiated)
+	})
+	if err != nil {
+		return err
+	}
+
+	abort := func(cause error) error {
+		s.do(context.Background(), "DELETE", key, url.Values{"uploadId": {initiated.UploadId}}, nil)
+		return cause
+	}
+
+	var parts []completedPart
+	buf := make([]byte, s.partSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+		part := buf[:n]
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {initiated.UploadId},
+		}
+		var etag string
+		err = withRetries(ctx, "s3 upload part "+strconv.Itoa(number), func() error {
+			res, _, err := s.do(ctx, "PUT", key, query, part)
+			if err == nil {
+				etag = res.Header.Get("ETag")
+			}
+			return err
+		})
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: etag})
+		if n < len(buf) {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return abort(err)
+	}
+	err = withRetries(ctx, "s3 complete multipart upload", func() error {
+		_, _, err := s.do(ctx, "POST", key, url.Values{"uploadId": {initiated.UploadId}}, body)
+		return err
+	})
+	if err != nil {
+		return abort(err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// stdinIsTerminal reports whether someone can answer a prompt. /dev/null
+// is a character device too, so this asks the terminal itself.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// readLine reads up to the next newline a byte at a time, so it does not
+// swallow input meant for the URL prompt of cli.
+func readLine() (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			if len(line) == 0 {
+				return "", err
+			}
+			break
+		}
+	}
+	return strings.TrimSpace(string(line)), nil
+}
+
+// parseRanges parses a 1-based selection like "1-20,25,30-" over n items
+// into 0-based indexes in ascending order. Empty input selects everything.
+func parseRanges(input string, n int) ([]int, error) {
+	selected := make([]bool, n)
+	if strings.TrimSpace(input) == "" {
+		for i := range selected {
+			selected[i] = true
+		}
+	}
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to := part, part
+		if i := strings.Index(part, "-"); 0 <= i {
+			from, to = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+			if from == "" {
+				from = "1"
+			}
+			if to == "" {
+				to = strconv.Itoa(n)
+			}
+		}
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, errors.New("Invalid range " + part)
+		}
+		end, err := strconv.Atoi(to)
+		if err != nil {
+			return nil, errors.New("Invalid range " + part)
+		}
+		if start < 1 || n < end || end < start {
+			return nil, errors.New("Range " + part + " is outside 1-" + strconv.Itoa(n))
+		}
+		for i := start; i <= end; i++ {
+			selected[i-1] = true
+		}
+	}
+
+	var indexes []int
+	for i, ok := range selected {
+		if ok {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// selectSrcs lets the user pick which of srcs to download. Without
+// prompts every src is kept.
+func (s *Scraper) selectSrcs(ctx context.Context, srcs []string) []string {
+	if len(srcs) == 0 {
+		return srcs
+	}
+	if s.canPrompt() {
+		for i, src := range srcs {
+			fmt.Printf("%4d %s\n", i+1, displaySrc(src))
+		}
+	}
+	for {
+		input, asked := s.ask(ctx, "Download (e.g. 1-20,25,30-, empty for all):", "")
+		if !asked {
+			return srcs
+		}
+		indexes, err := parseRanges(input, len(srcs))
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		chosen := make([]string, len(indexes))
+		for i, index := range indexes {
+			chosen[i] = srcs[index]
+		}
+		return chosen
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"github.com/PuerkitoBio/goquery"
+	"os"
+	"strings"
+)
+
+// debugSelectors is set by the --debug-selectors flag of the commands that
+// scrape.
+var debugSelectors bool
+
+const (
+	// maxTitleCandidates bounds the headings logged on a title miss.
+	maxTitleCandidates = 5
+	// maxSelectorDump bounds the body HTML saved on a selector miss.
+	maxSelectorDump = 2 << 10
+)
+
+// explainTitleMiss logs what doc has in place of a title for page: the
+// first title and heading texts.
+func explainTitleMiss(ctx context.Context, page *Page, doc *goquery.Document) {
+	if !debugSelectors {
+		return
+	}
+	logln(ctx, "Title selector", page.TitleSelector, "matched nothing in", doc.Url)
+	doc.Find("title, h1, h2").EachWithBreak(func(i int, el *goquery.Selection) bool {
+		logln(ctx, "  ", goquery.NodeName(el)+":", oneLine(strings.TrimSpace(el.Text())))
+		return i < maxTitleCandidates-1
+	})
+	dumpBody(ctx, doc)
+}
+
+// explainImageMiss logs how many elements relaxed variants of selector
+// match in doc, which had no images for it.
+func explainImageMiss(ctx context.Context, doc *goquery.Document, selector string) {
+	if !debugSelectors {
+		return
+	}
+	logln(ctx, "Image selector", selector, "matched nothing in", doc.Url)
+	for _, variant := range relaxedSelectors(selector) {
+		logln(ctx, "  ", find(doc, variant).Length(), "for", variant)
+	}
+	dumpBody(ctx, doc)
+}
+
+// relaxedSelectors are looser variants of a CSS selector: without the
+// last class, without the outermost step, with the last step alone, and
+// plain img. XPath selectors only get plain img.
+func relaxedSelectors(selector string) []string {
+	var variants []string
+	add := func(v string) {
+		v = strings.TrimSpace(v)
+		if v == "" || v == selector {
+			return
+		}
+		for _, seen := range variants {
+			if seen == v {
+				return
+			}
+		}
+		variants = append(variants, v)
+	}
+	if !isXPath(selector) && !strings.Contains(selector, ",") {
+		steps := strings.Fields(selector)
+		if 0 < len(steps) {
+			last := steps[len(steps)-1]
+			if strings.ContainsAny(last, "[:") {
+				// Dots in attributes and pseudo-classes are not classes.
+			} else if i := strings.LastIndex(last, "."); 0 < i {
+				add(strings.Join(append(steps[:len(steps)-1:len(steps)-1], last[:i]), " "))
+			} else if i == 0 {
+				add(strings.Join(append(steps[:len(steps)-1:len(steps)-1], "*"), " "))
+			}
+			add(strings.Join(steps[1:], " "))
+			add(last)
+		}
+	}
+	add("img")
+	return variants
+}
+
+// dumpBody saves the start of the body of doc to a temp file and logs
+// its path.
+func dumpBody(ctx context.Context, doc *goquery.Document) {
+	body, err := goquery.OuterHtml(doc.Find("body").First())
+	if err != nil || body == "" {
+		body, _ = doc.Html()
+	}
+	if maxSelectorDump < len(body) {
+		body = cutUtf8(body, maxSelectorDump)
+	}
+	f, err := os.CreateTemp("", "scrape-go-body-*.html")
+	if err != nil {
+		logln(ctx, "WARNING: selector dump:", err)
+		return
+	}
+	_, err = f.WriteString(body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logln(ctx, "WARNING: selector dump:", err)
+		return
+	}
+	logln(ctx, "   first", formatBytes(int64(len(body))), "of the body in", f.Name())
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+type Job struct {
+	ID       string
+	Page     *Page
+	Url      string
+	Created  time.Time
+	progress *Progress
+
+	mu     sync.Mutex
+	status string
+	result *Result
+}
+
+type jobView struct {
+	ID       string           `json:"id"`
+	Page     string           `json:"page"`
+	Url      string           `json:"url"`
+	Status   string           `json:"status"`
+	Created  time.Time        `json:"created"`
+	Progress ProgressSnapshot `json:"progress"`
+	Path     string           `json:"path,omitempty"`
+	Errors   []string         `json:"errors,omitempty"`
+}
+
+func (j *Job) setStatus(status string, result *Result) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	if result != nil {
+		j.result = result
+	}
+}
+
+func (j *Job) view() jobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	v := jobView{
+		ID:       j.ID,
+		Page:     j.Page.Name,
+		Url:      j.Url,
+		Status:   j.status,
+		Created:  j.Created,
+		Progress: j.progress.Snapshot(),
+	}
+	if j.result != nil {
+		v.Path = j.result.Path
+		v.Errors = j.result.Errors
+	}
+	return v
+}
+
+// jobQueue runs submitted jobs with at most cap(slots) scraping at once.
+type jobQueue struct {
+	scraper *Scraper
+	slots   chan struct{}
+	// waiting is cancelled on shutdown so queued jobs never start;
+	// running is cancelled only once the grace period is over.
+	waiting context.Context
+	running context.Context
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []*Job
+	next  int
+	wg    sync.WaitGroup
+}
+
+func (q *jobQueue) Submit(page *Page, url string) *Job {
+	q.mu.Lock()
+	q.next++
+	job := &Job{
+		ID:       strconv.Itoa(q.next),
+		Page:     page,
+		Url:      url,
+		Created:  time.Now(),
+		progress: &Progress{},
+		status:   jobQueued,
+	}
+	q.jobs[job.ID] = job
+	q.order = append(q.order, job)
+	q.mu.Unlock()
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		select {
+		case q.slots <- struct{}{}:
+		case <-q.waiting.Done():
+			job.setStatus(jobCancelled, nil)
+			return
+		}
+		defer func() { <-q.slots }()
+
+		job.setStatus(jobRunning, nil)
+		log.Println("Job", job.ID, "start", url)
+		result, err := q.scraper.scrape(withJob(q.running, job.ID), page, url, job.progress)
+		if err != nil {
+			log.Println("Job", job.ID, "failed", err)
+			job.setStatus(jobFailed, result)
+			return
+		}
+		log.Println("Job", job.ID, "done", result.Path)
+		job.setStatus(jobDone, result)
+	}()
+	return job
+}
+
+func (q *jobQueue) Get(id string) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.jobs[id]
+}
+
+func (q *jobQueue) List() []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*Job(nil), q.order...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func jobsHandler(config *Config, queue *jobQueue) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Page string `json:"page"`
+			Url  string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		page := config.FindPage(body.Page)
+		if page == nil {
+			writeError(w, http.StatusBadRequest, "unknown page "+body.Page)
+			return
+		}
+		if body.Url == "" {
+			writeError(w, http.StatusBadRequest, "url is required")
+			return
+		}
+		job := queue.Submit(page, body.Url)
+		writeJSON(w, http.StatusAccepted, job.view())
+	})
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		views := make([]jobView, 0)
+		for _, job := range queue.List() {
+			views = append(views, job.view())
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job := queue.Get(r.PathValue("id"))
+		if job == nil {
+			writeError(w, http.StatusNotFound, "no such job")
+			return
+		}
+		writeJSON(w, http.StatusOK, job.view())
+	})
+	handleMetrics(mux)
+	return mux
+}
+
+func serve(config *Config, args []string) error {
+	flags := newFlagSet("serve")
+	listen := flags.String("listen", ":8080", "address to listen on")
+	jobs := flags.Int("jobs", 2, "number of jobs scraping at once")
+	token := flags.String("token", os.Getenv("SCRAPE_GO_TOKEN"), "require this bearer token (default $SCRAPE_GO_TOKEN)")
+	grace := flags.Duration("shutdown-timeout", time.Minute, "time running jobs get to finish on shutdown")
+	freshSession := flags.Bool("fresh-session", false, "ignore the cookies of cookie_store for this run")
+	flags.BoolVar(&debugEnabled, "debug", false, "log debug details")
+	flags.BoolVar(&debugSelectors, "debug-selectors", false, "log what pages look like when the title or image selector matches nothing")
+	promptFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := checkPromptFlags(); err != nil {
+		return err
+	}
+	if err := config.readPassword(); err != nil {
+		return usageError(err)
+	}
+
+	if *jobs < 1 {
+		return usageError(errors.New("--jobs must be at least 1"))
+	}
+	if *token == "" {
+		log.Println("WARNING: no --token set, the API is open to anyone who can reach", *listen)
+	}
+
+	sig, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	running, cancelRunning := context.WithCancel(context.Background())
+	defer cancelRunning()
+
+	queue := &jobQueue{
+		scraper: &Scraper{Config: config, Unattended: true, FreshSession: *freshSession},
+		slots:   make(chan struct{}, *jobs),
+		waiting: sig,
+		running: running,
+		jobs:    make(map[string]*Job),
+	}
+	server := &http.Server{
+		Addr:    *listen,
+		Handler: requireToken(*token, jobsHandler(config, queue)),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Println("Listening on", *listen)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-sig.Done():
+	}
+
+	log.Println("Shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), *grace)
+	defer cancel()
+	server.Shutdown(shutdown)
+
+	finished := make(chan struct{})
+	go func() {
+		queue.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-shutdown.Done():
+		log.Println("Cancelling jobs still running after", *grace)
+		cancelRunning()
+		<-finished
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type sftpUploader struct {
+	addr   string
+	config *ssh.ClientConfig
+	// target is what each connection authenticates with; see sshAuth.
+	target *Upload
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
+
+// sshAuth authenticates with the key_file of u, or else with the ssh
+// agent, whose connection is returned to be closed once the SSH client
+// is done with it. The connection is nil for key files.
+func sshAuth(u *Upload) (ssh.AuthMethod, io.Closer, error) {
+	if u.KeyFile != "" {
+		key, err := os.ReadFile(expandHome(u.KeyFile))
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ssh.PublicKeys(signer), nil, nil
+	}
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("sftp needs key_file or a running ssh agent")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+}
+
+func newSftpUploader(u *Upload) (*sftpUploader, error) {
+	if u.KeyFile == "" && os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, errors.New("sftp needs key_file or a running ssh agent")
+	}
+	var hostKey ssh.HostKeyCallback
+	var err error
+	if u.InsecureIgnoreHostKey {
+		log.Println("WARNING: sftp host key verification is DISABLED for", u.Host)
+		hostKey = ssh.InsecureIgnoreHostKey()
+	} else {
+		hostKey, err = knownhosts.New(expandHome(or(u.KnownHosts, "~/.ssh/known_hosts")))
+		if err != nil {
+			return nil, err
+		}
+	}
+	port := u.Port
+	if port == 0 {
+		port = 22
+	}
+	return &sftpUploader{
+		addr: net.JoinHostPort(u.Host, strconv.Itoa(port)),
+		config: &ssh.ClientConfig{
+			User:            u.User,
+			HostKeyCallback: hostKey,
+			Timeout:         30 * time.Second,
+		},
+		target: u,
+	}, nil
+}
+
+// progressReader logs how much of a transfer is done at debug level.
+type progressReader struct {
+	r     io.Reader
+	name  string
+	total int64
+	done  int64
+	last  time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if time.Since(p.last) >= 2*time.Second || err == io.EOF {
+		p.last = time.Now()
+		debugln("sftp", p.name, formatBytes(p.done), "of", formatBytes(p.total))
+	}
+	return n, err
+}
+
+func (s *sftpUploader) Upload(ctx context.Context, local string, name string) (string, error) {
+	err := withRetries(ctx, "sftp upload", func() error {
+		return s.upload(local, name)
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sftp://" + s.config.User + "@" + s.addr + "/" + name, nil
+}
+
+// upload writes to a temporary name beside the destination and renames it
+// into place, so readers never see a partial archive.
+func (s *sftpUploader) upload(local string, name string) error {
+	auth, agentConn, err := sshAuth(s.target)
+	if err != nil {
+		return err
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+	config := *s.config
+	config.Auth = []ssh.AuthMethod{auth}
+	conn, err := ssh.Dial("tcp", s.addr, &config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if dir := path.Dir(name); dir != "." && dir != "/" {
+		if err := client.MkdirAll(dir); err != nil {
+			return err
+		}
+	}
+
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := path.Join(path.Dir(name), "."+path.Base(name)+".part")
+	dst, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, &progressReader{r: src, name: name, total: info.Size(), last: time.Now()})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return err
+	}
+
+	if err := client.PosixRename(tmp, name); err != nil {
+		// Servers without the posix-rename extension refuse to rename
+		// over an existing file.
+		client.Remove(name)
+		if err := client.Rename(tmp, name); err != nil {
+			client.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The connection to the ssh agent ends with the upload, failed or not.
+func TestSftpClosesAgent(t *testing.T) {
+	quiet(t)
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	agent, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip("no unix sockets:", err)
+	}
+	defer agent.Close()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	// Nothing listens at the SSH port any more.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := closed.Addr().(*net.TCPAddr)
+	closed.Close()
+
+	uploader, err := newSftpUploader(&Upload{Host: addr.IP.String(), Port: addr.Port, InsecureIgnoreHostKey: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploader.upload("archive.zip", "archive.zip"); err == nil {
+		t.Fatal("uploaded with no server")
+	}
+	conn, err := agent.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("agent connection still open: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// sitemapState remembers the lastmod of every sitemap URL scraped, so
+// later runs skip the URLs whose lastmod did not change.
+var sitemapState = filepath.Join(downloadsDir, ".sitemap-state.json")
+
+// maxSitemapDepth bounds how deep sitemap index files may nest.
+const maxSitemapDepth = 3
+
+// Sitemap scrapes every URL of the sitemap at SitemapUrl, a sitemap index
+// or a gzipped sitemap included, that matches SitemapInclude and not
+// SitemapExclude, one archive per URL.
+type Sitemap struct {
+	SitemapUrl     string `toml:"sitemap_url"`
+	SitemapInclude string `toml:"sitemap_include"`
+	SitemapExclude string `toml:"sitemap_exclude"`
+
+	sitemapInclude *regexp.Regexp
+	sitemapExclude *regexp.Regexp
+}
+
+func (m *Sitemap) compile() error {
+	if m.SitemapUrl == "" && (m.SitemapInclude != "" || m.SitemapExclude != "") {
+		return errors.New("sitemap_url is required by sitemap_include and sitemap_exclude")
+	}
+	var err error
+	if m.SitemapInclude != "" {
+		if m.sitemapInclude, err = regexp.Compile(m.SitemapInclude); err != nil {
+			return fmt.Errorf("sitemap_include: %v", err)
+		}
+	}
+	if m.SitemapExclude != "" {
+		if m.sitemapExclude, err = regexp.Compile(m.SitemapExclude); err != nil {
+			return fmt.Errorf("sitemap_exclude: %v", err)
+		}
+	}
+	return nil
+}
+
+func (m *Sitemap) match(url string) bool {
+	if m.sitemapInclude != nil && !m.sitemapInclude.MatchString(url) {
+		return false
+	}
+	return m.sitemapExclude == nil || !m.sitemapExclude.MatchString(url)
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod"`
+}
+
+// sitemapDocument is either a <urlset> or a <sitemapindex>.
+type sitemapDocument struct {
+	XMLName  xml.Name
+	Urls     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+// fetchSitemap returns the URLs of the sitemap at url, following sitemap
+// index files depth levels deep.
+func fetchSitemap(ctx context.Context, client *http.Client, url string, depth int) ([]sitemapEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	debugln(res.Proto, res.Status, url)
+	if 400 <= res.StatusCode {
+		return nil, &StatusError{Url: url, Code: res.StatusCode, Status: res.Status}
+	}
+
+	body := bufio.NewReader(res.Body)
+	var r io.Reader = body
+	if magic, err := body.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("sitemap %s: %v", url, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var doc sitemapDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("sitemap %s: %v", url, err)
+	}
+
+	switch doc.XMLName.Local {
+	case "urlset":
+		return doc.Urls, nil
+	case "sitemapindex":
+		if depth <= 0 {
+			return nil, errors.New("sitemap " + url + ": sitemap index files nested too deep")
+		}
+		var entries []sitemapEntry
+		for _, sitemap := range doc.Sitemaps {
+			found, err := fetchSitemap(ctx, client, strings.TrimSpace(sitemap.Loc), depth-1)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, found...)
+		}
+		return entries, nil
+	default:
+		return nil, errors.New("sitemap " + url + ": unexpected <" + doc.XMLName.Local + ">")
+	}
+}
+
+// lastmods maps each URL scraped from a sitemap, by key, to its lastmod
+// then.
+type lastmods struct {
+	mu   sync.Mutex
+	urls map[string]string
+	key  func(string) string
+}
+
+func loadLastmods(key func(string) string) *lastmods {
+	state := &lastmods{urls: map[string]string{}, key: key}
+	data, err := os.ReadFile(sitemapState)
+	if err == nil {
+		err = json.Unmarshal(data, &state.urls)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println("WARNING: sitemap state:", err)
+	}
+	return state
+}
+
+func (l *lastmods) unchanged(entry sitemapEntry) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// State from before URLs were keyed has them as they were.
+	lastmod, ok := l.urls[l.key(entry.Loc)]
+	if !ok {
+		lastmod = l.urls[entry.Loc]
+	}
+	return entry.Lastmod != "" && lastmod == entry.Lastmod
+}
+
+func (l *lastmods) record(entry sitemapEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.urls, entry.Loc)
+	l.urls[l.key(entry.Loc)] = entry.Lastmod
+}
+
+func (l *lastmods) save() error {
+	l.mu.Lock()
+	data, err := json.MarshalIndent(l.urls, "", "  ")
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(sitemapState), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(sitemapState, data, 0644)
+}
+
+// scrapeSitemap scrapes the URLs of the sitemap of page, handing every
+// outcome to add. URLs whose lastmod is the one of their last scrape are
+// skipped.
+func (s *Scraper) scrapeSitemap(ctx context.Context, page *Page, add func(*Result, error)) {
+	client, err := s.client(page)
+	if err == nil {
+		var entries []sitemapEntry
+		entries, err = fetchSitemap(ctx, client, page.SitemapUrl, maxSitemapDepth)
+		if err == nil {
+			s.scrapeEntries(ctx, page, entries, add)
+			return
+		}
+	}
+	log.Println("Failed", page.SitemapUrl, err)
+	result := &Result{Page: page.Name, Url: page.SitemapUrl}
+	s.Report.Add(result, err)
+	add(result, err)
+}
+
+func (s *Scraper) scrapeEntries(ctx context.Context, page *Page, entries []sitemapEntry, add func(*Result, error)) {
+	state := loadLastmods(s.Config.urlKey)
+	defer func() {
+		if err := state.save(); err != nil {
+			log.Println("WARNING: sitemap state:", err)
+		}
+	}()
+
+	var matched []sitemapEntry
+	for _, entry := range entries {
+		entry.Loc = strings.TrimSpace(entry.Loc)
+		entry.Lastmod = strings.TrimSpace(entry.Lastmod)
+		if entry.Loc != "" && page.match(entry.Loc) {
+			matched = append(matched, entry)
+		}
+	}
+	log.Println("Sitemap", page.SitemapUrl+":", len(matched), "of", len(entries), "URLs match")
+	for _, entry := range matched {
+		if ctx.Err() != nil {
+			return
+		}
+		if !s.Update && state.unchanged(entry) {
+			debugln("Skip", entry.Loc, "unchanged since", entry.Lastmod)
+			result := &Result{Page: page.Name, Url: entry.Loc, Skipped: true}
+			s.Report.Add(result, nil)
+			add(result, nil)
+			continue
+		}
+		log.Println("→", entry.Loc)
+		result, err := s.scrape(ctx, page, entry.Loc, nil)
+		if err != nil {
+			log.Println("Failed", entry.Loc, err)
+		} else if !s.Estimate && s.Sample == 0 && !result.Skipped && result.Failed == 0 {
+			// Like recordConditional, only a page saved in full is done.
+			state.record(entry)
+		}
+		add(result, err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// lastmodRecorded scrapes entry from the sitemap of page and tells
+// whether its lastmod was kept as scraped.
+func lastmodRecorded(t *testing.T, scraper *Scraper, page *Page, entry sitemapEntry) bool {
+	t.Helper()
+	scraper.scrapeEntries(context.Background(), page, []sitemapEntry{entry}, func(*Result, error) {})
+	return loadLastmods(scraper.Config.urlKey).unchanged(entry)
+}
+
+func TestSitemapLastmodSavedInFull(t *testing.T) {
+	entry := sitemapEntry{Loc: "https://gallery.example/g/42", Lastmod: "2024-03-09"}
+
+	scraper, page := replayScraper(t, "gallery")
+	scraper.Estimate = true
+	if lastmodRecorded(t, scraper, page, entry) {
+		t.Error("lastmod recorded by an estimate")
+	}
+	scraper.Estimate, scraper.Sample = false, 1
+	if lastmodRecorded(t, scraper, page, entry) {
+		t.Error("lastmod recorded by a sample")
+	}
+	scraper.Sample = 0
+	if !lastmodRecorded(t, scraper, page, entry) {
+		t.Error("lastmod not recorded by a scrape saved in full")
+	}
+}
+
+func TestSitemapLastmodFailedImages(t *testing.T) {
+	scraper, page := replayScraper(t, "errors")
+	entry := sitemapEntry{Loc: "https://gallery.example/g/7", Lastmod: "2024-03-09"}
+	if lastmodRecorded(t, scraper, page, entry) {
+		t.Error("lastmod recorded with images failed")
+	}
+}
+
+func TestSitemapLastmodSkipped(t *testing.T) {
+	scraper, page := replayScraper(t, "gallery")
+	scraper.SkipExisting = true
+	if _, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/42", nil); err != nil {
+		t.Fatal(err)
+	}
+	entry := sitemapEntry{Loc: "https://gallery.example/g/42", Lastmod: "2024-03-09"}
+	if lastmodRecorded(t, scraper, page, entry) {
+		t.Error("lastmod recorded for a page skipped as already saved")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// source is a document exactly as fetched, before parsing.
+type source struct {
+	Url     string    `json:"url"`
+	Fetched time.Time `json:"fetched"`
+	File    string    `json:"file"`
+	body    []byte
+}
+
+// sources records the documents fetched under a context, for save_html.
+type sources struct {
+	mu    sync.Mutex
+	pages []*source
+}
+
+type sourcesKey struct{}
+
+func withSources(ctx context.Context, s *sources) context.Context {
+	return context.WithValue(ctx, sourcesKey{}, s)
+}
+
+// recordSource keeps body if ctx records sources.
+func recordSource(ctx context.Context, url string, body []byte) {
+	s, ok := ctx.Value(sourcesKey{}).(*sources)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages = append(s.pages, &source{Url: url, Fetched: time.Now(), body: body})
+}
+
+// resetSources forgets the documents recorded under ctx, those of a
+// failed attempt.
+func resetSources(ctx context.Context) {
+	if s, ok := ctx.Value(sourcesKey{}).(*sources); ok {
+		s.mu.Lock()
+		s.pages = nil
+		s.mu.Unlock()
+	}
+}
+
+// list names the recorded documents source.html, or source-001.html and
+// on when there are several.
+func (s *sources) list() []*source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, page := range s.pages {
+		page.File = "source.html"
+		if 1 < len(s.pages) {
+			page.File = fmt.Sprintf("source-%03d.html", i+1)
+		}
+	}
+	return s.pages
+}
+
+func (s *sources) files() []*Image {
+	var files []*Image
+	for _, page := range s.list() {
+		files = append(files, &Image{Name: page.File, Bytes: newBody(page.body), Modified: page.Fetched})
+	}
+	return files
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+)
+
+// Body holds downloaded bytes in memory until they outgrow the spool_over
+// limit it was made with, and in a temp file from then on.
+type Body struct {
+	mem   *bytes.Buffer
+	file  *os.File
+	size  int64
+	limit int64
+	dir   string
+}
+
+// newBody holds b in memory.
+func newBody(b []byte) *Body {
+	return &Body{mem: bytes.NewBuffer(b), size: int64(len(b))}
+}
+
+type spoolKey struct{}
+
+type spoolOptions struct {
+	Limit int64
+	Dir   string
+}
+
+// withSpool makes the bodies of ctx spill to the spool_dir over spool_over.
+func (s *Scraper) withSpool(ctx context.Context) context.Context {
+	if s.Config.SpoolOver.Bytes <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, spoolKey{}, spoolOptions{Limit: s.Config.SpoolOver.Bytes, Dir: s.Config.SpoolDir})
+}
+
+// spoolBody is an empty body under the spool options of ctx.
+func spoolBody(ctx context.Context) *Body {
+	opts, _ := ctx.Value(spoolKey{}).(spoolOptions)
+	return &Body{mem: new(bytes.Buffer), limit: opts.Limit, dir: opts.Dir}
+}
+
+func (b *Body) Write(p []byte) (int, error) {
+	if b.file == nil && 0 < b.limit && b.limit < b.size+int64(len(p)) {
+		f, err := spools.create(b.dir)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := f.Write(b.mem.Bytes()); err != nil {
+			spools.remove(f)
+			return 0, err
+		}
+		b.file, b.mem = f, nil
+	}
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *Body) Len() int {
+	return int(b.size)
+}
+
+// Reader reads the body from the start, leaving it as it is.
+func (b *Body) Reader() io.Reader {
+	if b.file != nil {
+		return io.NewSectionReader(b.file, 0, b.size)
+	}
+	return bytes.NewReader(b.mem.Bytes())
+}
+
+func (b *Body) Sha256() string {
+	h := sha256.New()
+	io.Copy(h, b.Reader())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Close deletes the temp file of a spilled body.
+func (b *Body) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := spools.remove(b.file)
+	b.file = nil
+	return err
+}
+
+// closeImages deletes the temp files of images.
+func closeImages(images []*Image) {
+	for _, image := range images {
+		if image.Bytes != nil {
+			image.Bytes.Close()
+		}
+	}
+}
+
+// spools are the temp files of every spilled body, deleted at exit by
+// removeAll if not before.
+var spools = &spoolFiles{files: map[*os.File]bool{}}
+
+type spoolFiles struct {
+	mu    sync.Mutex
+	files map[*os.File]bool
+}
+
+func (s *spoolFiles) create(dir string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, "scrape-go-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.files[f] = true
+	s.mu.Unlock()
+	return f, nil
+}
+
+func (s *spoolFiles) remove(f *os.File) error {
+	s.mu.Lock()
+	delete(s.files, f)
+	s.mu.Unlock()
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (s *spoolFiles) removeAll() {
+	s.mu.Lock()
+	files := s.files
+	s.files = map[*os.File]bool{}
+	s.mu.Unlock()
+	for f := range files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pageCache keeps the last document fetched of every page scraped under
+// stale_page_fallback, one file per page URL by key.
+var pageCache = filepath.Join(downloadsDir, ".page-cache")
+
+// Stale is the cached document a page was scraped from, and why.
+type Stale struct {
+	// Fetched is when the cached document was fetched.
+	Fetched time.Time `json:"fetched"`
+	// Error is how fetching the document failed this time.
+	Error string `json:"error"`
+}
+
+// cachedPage is a document in pageCache.
+type cachedPage struct {
+	Url     string    `json:"url"`
+	Fetched time.Time `json:"fetched"`
+	Html    string    `json:"html"`
+}
+
+func (s *Scraper) pageCacheFile(url string) string {
+	return filepath.Join(pageCache, checksum([]byte(s.Config.urlKey(url)))+".json")
+}
+
+// cachePage keeps doc, the document of url, for stalePage.
+func (s *Scraper) cachePage(ctx context.Context, url string, doc *goquery.Document) {
+	html, err := goquery.OuterHtml(doc.Selection)
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(cachedPage{Url: doc.Url.String(), Fetched: time.Now().UTC(), Html: html})
+		if err == nil {
+			err = os.MkdirAll(pageCache, 0755)
+		}
+		if err == nil {
+			err = os.WriteFile(s.pageCacheFile(url), data, 0644)
+		}
+	}
+	if err != nil {
+		logln(ctx, "WARNING: stale_page_fallback:", err)
+	}
+}
+
+// stalePage returns the cached document of rawurl and its title in
+// place of the one fetching failed with fetchErr, and marks result
+// stale. It returns fetchErr when there is no cached document.
+func (s *Scraper) stalePage(ctx context.Context, page *Page, rawurl string, result *Result, fetchErr error) (*goquery.Document, string, error) {
+	data, err := os.ReadFile(s.pageCacheFile(rawurl))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logln(ctx, "WARNING: stale_page_fallback:", err)
+		}
+		return nil, "", fetchErr
+	}
+	var cached cachedPage
+	if err := json.Unmarshal(data, &cached); err != nil {
+		logln(ctx, "WARNING: stale_page_fallback:", err)
+		return nil, "", fetchErr
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader([]byte(cached.Html)))
+	if err != nil {
+		logln(ctx, "WARNING: stale_page_fallback:", err)
+		return nil, "", fetchErr
+	}
+	if doc.Url, err = url.Parse(cached.Url); err != nil {
+		return nil, "", fetchErr
+	}
+	title, err := page.GetTitle(doc)
+	if err != nil {
+		if s.Config.StrictTitle || page.StrictTitle {
+			return nil, "", fetchErr
+		}
+		title = urlTitle(rawurl)
+	}
+
+	logln(ctx, "WARNING: Fetching", rawurl, "failed:", fetchErr)
+	logln(ctx, "WARNING: Scraping the copy of", rawurl, "cached", cached.Fetched.Local().Format(time.RFC3339), "instead; its images may have changed since")
+	result.Stale = &Stale{Fetched: cached.Fetched, Error: fetchErr.Error()}
+	return doc, title, nil
+}
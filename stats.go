@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// downloadRecord is the timing of a single image download. Phase durations
+// are measured from Start and stay zero when the phase did not happen, e.g.
+// DNS and Connect on a reused connection.
+type downloadRecord struct {
+	Url     string        `json:"url"`
+	Host    string        `json:"host"`
+	Start   time.Time     `json:"start"`
+	DNS     time.Duration `json:"dns_ns"`
+	Connect time.Duration `json:"connect_ns"`
+	TLS     time.Duration `json:"tls_ns"`
+	TTFB    time.Duration `json:"ttfb_ns"`
+	Total   time.Duration `json:"total_ns"`
+	Bytes   int64         `json:"bytes"`
+	Error   string        `json:"error,omitempty"`
+
+	mu sync.Mutex
+}
+
+func traceDownload(ctx context.Context, r *downloadRecord) context.Context {
+	since := func(d *time.Duration) {
+		r.mu.Lock()
+		*d = time.Since(r.Start)
+		r.mu.Unlock()
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSDone:              func(httptrace.DNSDoneInfo) { since(&r.DNS) },
+		ConnectDone:          func(string, string, error) { since(&r.Connect) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { since(&r.TLS) },
+		GotFirstResponseByte: func() { since(&r.TTFB) },
+	})
+}
+
+func (r *downloadRecord) finish(image *Image, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Total = time.Since(r.Start)
+	if u, e := url.Parse(r.Url); e == nil {
+		r.Host = u.Host
+	}
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.Bytes = int64(image.Bytes.Len())
+	}
+}
+
+// Stats collects download records for the end-of-run summary. A nil *Stats
+// discards everything.
+type Stats struct {
+	mu      sync.Mutex
+	records []*downloadRecord
+}
+
+func (s *Stats) Add(r *downloadRecord) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.records = append(s.records, r)
+	s.mu.Unlock()
+}
+
+type hostStats struct {
+	Host     string
+	Requests int
+	Failures int
+	P50      time.Duration
+	P95      time.Duration
+	Bytes    int64
+	Busy     time.Duration
+}
+
+// percentile returns the p-th percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if len(sorted) <= i {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (s *Stats) byHost() []hostStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	durations := make(map[string][]time.Duration)
+	hosts := make(map[string]*hostStats)
+	for _, r := range s.records {
+		h, ok := hosts[r.Host]
+		if !ok {
+			h = &hostStats{Host: r.Host}
+			hosts[r.Host] = h
+		}
+		h.Requests++
+		if r.Error != "" {
+			h.Failures++
+		}
+		h.Bytes += r.Bytes
+		h.Busy += r.Total
+		durations[r.Host] = append(durations[r.Host], r.Total)
+	}
+
+	results := make([]hostStats, 0, len(hosts))
+	for host, h := range hosts {
+		ds := durations[host]
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		h.P50 = percentile(ds, 0.50)
+		h.P95 = percentile(ds, 0.95)
+		results = append(results, *h)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Host < results[j].Host })
+	return results
+}
+
+func (s *Stats) PrintSummary() {
+	fmt.Printf("%-32s %8s %8s %10s %10s %12s\n", "HOST", "REQUESTS", "FAILURES", "P50", "P95", "THROUGHPUT")
+	for _, h := range s.byHost() {
+		throughput := "-"
+		if 0 < h.Busy {
+			// Downloads overlap, so this is per-connection throughput.
+			throughput = formatBytes(int64(float64(h.Bytes)/h.Busy.Seconds())) + "/s"
+		}
+		fmt.Printf("%-32s %8d %8d %10s %10s %12s\n",
+			h.Host, h.Requests, h.Failures,
+			h.P50.Round(time.Millisecond), h.P95.Round(time.Millisecond), throughput)
+	}
+}
+
+func (s *Stats) WriteJSON(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s.records)
+}
+
+// Report prints the per-host summary and writes the raw records, as asked.
+func (s *Stats) Report(verbose bool, jsonPath string) error {
+	if s == nil {
+		return nil
+	}
+	if verbose {
+		s.PrintSummary()
+	}
+	if jsonPath != "" {
+		return s.WriteJSON(jsonPath)
+	}
+	return nil
+}
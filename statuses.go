@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statusSkip  = "skip"
+	statusRetry = "retry"
+	statusFail  = "fail"
+)
+
+const (
+	defaultImageRetries    = 2
+	defaultImageRetryDelay = time.Second
+)
+
+// statusCodes are HTTP statuses, each a code such as 404 or a class such
+// as "5xx".
+type statusCodes []string
+
+func (c *statusCodes) UnmarshalTOML(v interface{}) error {
+	values, ok := v.([]interface{})
+	if !ok {
+		return errors.New("expected an array of statuses")
+	}
+	for _, value := range values {
+		switch x := value.(type) {
+		case int64:
+			*c = append(*c, strconv.FormatInt(x, 10))
+		case string:
+			*c = append(*c, strings.ToLower(x))
+		default:
+			return fmt.Errorf("invalid status %v", value)
+		}
+	}
+	return nil
+}
+
+func (c statusCodes) validate() error {
+	for _, code := range c {
+		if len(code) == 3 && '1' <= code[0] && code[0] <= '5' && (code[1:] == "xx" || isDigits(code[1:])) {
+			continue
+		}
+		return errors.New("invalid status " + strconv.Quote(code))
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || '9' < c {
+			return false
+		}
+	}
+	return true
+}
+
+// StatusActions say what to do about responses with the listed statuses.
+// An exact code wins over a class.
+type StatusActions struct {
+	Skip  statusCodes
+	Retry statusCodes
+	Fail  statusCodes
+}
+
+func (a *StatusActions) validate() error {
+	if a == nil {
+		return nil
+	}
+	for _, codes := range []statusCodes{a.Skip, a.Retry, a.Fail} {
+		if err := codes.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// action is the action for code, or "" when no list has it.
+func (a *StatusActions) action(code int) string {
+	if a == nil {
+		return ""
+	}
+	exact := strconv.Itoa(code)
+	class := exact[:1] + "xx"
+	actions := []struct {
+		name  string
+		codes statusCodes
+	}{{statusFail, a.Fail}, {statusRetry, a.Retry}, {statusSkip, a.Skip}}
+	for _, want := range []string{exact, class} {
+		for _, action := range actions {
+			for _, c := range action.codes {
+				if c == want {
+					return action.name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// defaultStatuses retry server errors and rate limiting, for images and
+// documents alike.
+var defaultStatuses = &StatusActions{Retry: statusCodes{"5xx", "429", "408"}}
+
+// Statuses configure how error statuses are handled. Images with a status
+// in no list fail on their own and documents fail the page; ImageRetries
+// (default 2) bounds the retries of an image, 0 being none.
+type Statuses struct {
+	ImageStatuses    *StatusActions `toml:"image_statuses"`
+	DocumentStatuses *StatusActions `toml:"document_statuses"`
+	ImageRetries     *int           `toml:"image_retries"`
+}
+
+func (s Statuses) validate() error {
+	if err := s.ImageStatuses.validate(); err != nil {
+		return fmt.Errorf("image_statuses: %v", err)
+	}
+	if err := s.DocumentStatuses.validate(); err != nil {
+		return fmt.Errorf("document_statuses: %v", err)
+	}
+	return nil
+}
+
+// statusAction is what to do about err, a *StatusError or not, under the
+// page's actions, the global ones, then the defaults.
+func statusAction(err error, page, global *StatusActions) string {
+	var status *StatusError
+	if !errors.As(err, &status) {
+		return ""
+	}
+	if page == nil {
+		page = global
+	}
+	if action := page.action(status.Code); action != "" {
+		return action
+	}
+	return defaultStatuses.action(status.Code)
+}
+
+func (s *Scraper) imageAction(page *Page, err error) string {
+	return statusAction(err, page.ImageStatuses, s.Config.ImageStatuses)
+}
+
+func (s *Scraper) documentAction(page *Page, err error) string {
+	return statusAction(err, page.DocumentStatuses, s.Config.DocumentStatuses)
+}
+
+func (s *Scraper) imageRetries(page *Page) int {
+	retries := page.ImageRetries
+	if retries == nil {
+		retries = s.Config.ImageRetries
+	}
+	if retries == nil {
+		return defaultImageRetries
+	}
+	return *retries
+}
+
+// triageImageErrors separates the images skipped for their status from
+// the failed ones, counting them in result, and returns the first error
+// that fails the page.
+func (s *Scraper) triageImageErrors(page *Page, result *Result, errs []error) (failed, skipped []error, err error) {
+	for _, e := range errs {
+		var status *StatusError
+		if ie, ok := e.(*imageError); ok && ie.Skipped && errors.As(e, &status) {
+			if result.SkippedImages == nil {
+				result.SkippedImages = map[int]int{}
+			}
+			result.SkippedImages[status.Code]++
+			skipped = append(skipped, e)
+			continue
+		}
+		if s.imageAction(page, e) == statusFail {
+			return nil, nil, e
+		}
+		failed = append(failed, e)
+	}
+	return failed, skipped, nil
+}
+
+// skippedStatuses formats counts of skipped images by status, as in
+// "404×3, 410×1".
+func skippedStatuses(counts map[int]int) string {
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = strconv.Itoa(code) + "×" + strconv.Itoa(counts[code])
+	}
+	return strings.Join(parts, ", ")
+}
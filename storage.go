@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Storage is where save writes archives. Names are the paths outputPath
+// makes, with "/" or the platform's separator.
+type Storage interface {
+	// Create opens name for writing, making any directories it needs.
+	Create(name string) (io.WriteCloser, error)
+	Exists(name string) bool
+	// Rename moves from to to, replacing to, as the last step of save.
+	Rename(from string, to string) error
+}
+
+// localStorage is the local filesystem, the default Storage.
+type localStorage struct{}
+
+func (localStorage) Create(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(name)
+}
+
+func (localStorage) Exists(name string) bool {
+	return exists(name)
+}
+
+func (localStorage) Rename(from string, to string) error {
+	return os.Rename(from, to)
+}
+
+// storage is where s saves archives.
+func (s *Scraper) storage() Storage {
+	if s.Storage != nil {
+		return s.Storage
+	}
+	return localStorage{}
+}
+
+// storesLocally reports whether s saves archives to the local filesystem.
+func (s *Scraper) storesLocally() bool {
+	_, ok := s.storage().(localStorage)
+	return ok
+}
+
+// checkStorage rejects, when s saves archives elsewhere than the local
+// filesystem, what reads the archive of page back from it.
+func (s *Scraper) checkStorage(page *Page) error {
+	if s.storesLocally() {
+		return nil
+	}
+	switch {
+	case s.Update || s.Repair != "":
+		return errors.New("--update and repair need archives saved to the local disk")
+	case s.upload(page) != nil:
+		return errors.New("upload needs archives saved to the local disk")
+	case s.Config.ArchiveMtime || page.ArchiveMtime:
+		return errors.New("archive_mtime needs archives saved to the local disk")
+	case s.hook(page.PostSaveCommand, s.Config.PostSaveCommand) != nil:
+		return errors.New("post_save_command needs archives saved to the local disk")
+	}
+	return nil
+}
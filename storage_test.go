@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+// memoryStorage keeps archives in memory, by name.
+type memoryStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	// failRename fails every Rename, as a full disk would.
+	failRename bool
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{files: map[string][]byte{}}
+}
+
+// memoryFile lands in its storage on Close.
+type memoryFile struct {
+	bytes.Buffer
+	storage *memoryStorage
+	name    string
+}
+
+func (f *memoryFile) Close() error {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+	f.storage.files[f.name] = f.Bytes()
+	return nil
+}
+
+func (m *memoryStorage) Create(name string) (io.WriteCloser, error) {
+	return &memoryFile{storage: m, name: name}, nil
+}
+
+func (m *memoryStorage) Exists(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.files[name]
+	return ok
+}
+
+func (m *memoryStorage) Rename(from string, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failRename {
+		return errors.New("rename " + from + ": no space left on device")
+	}
+	b, ok := m.files[from]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, from)
+	m.files[to] = b
+	return nil
+}
+
+func TestSave(t *testing.T) {
+	quiet(t)
+	storage := newMemoryStorage()
+	zip := newBody([]byte("PK archive"))
+	n, err := save(context.Background(), storage, "downloads/title.zip", zip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len("PK archive")) {
+		t.Errorf("wrote %d bytes, want %d", n, len("PK archive"))
+	}
+	if got := string(storage.files["downloads/title.zip"]); got != "PK archive" {
+		t.Errorf("saved %q", got)
+	}
+	if storage.Exists("downloads/title.zip.part") {
+		t.Error("downloads/title.zip.part left behind")
+	}
+}
+
+// A failed save leaves only the .part, never half an archive under path.
+func TestSaveRenameFails(t *testing.T) {
+	quiet(t)
+	storage := newMemoryStorage()
+	storage.failRename = true
+	if _, err := save(context.Background(), storage, "downloads/title.zip", newBody([]byte("PK archive"))); err == nil {
+		t.Fatal("save succeeded")
+	}
+	if storage.Exists("downloads/title.zip") {
+		t.Error("downloads/title.zip saved")
+	}
+}
+
+func TestScrapeToStorage(t *testing.T) {
+	scraper, page := replayScraper(t, "gallery")
+	storage := newMemoryStorage()
+	scraper.Storage = storage
+	result, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(result.Path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%s written to the local disk: %v", result.Path, err)
+	}
+	b, ok := storage.files[result.Path]
+	if !ok {
+		t.Fatalf("%s not in storage, only %d files", result.Path, len(storage.files))
+	}
+	r := readZip(t, newBody(b))
+	if len(r.File) != 3+1 {
+		t.Errorf("%d entries, want 3 images and the manifest", len(r.File))
+	}
+
+	// SkipExisting asks the storage, not the disk.
+	scraper.SkipExisting = true
+	again, err := scraper.scrape(context.Background(), page, "https://gallery.example/g/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Skipped || again.Path != result.Path {
+		t.Errorf("scraped again to %s", again.Path)
+	}
+}
+
+// An archive in the storage, and not on the disk, is a collision.
+func TestUniquePathInStorage(t *testing.T) {
+	quiet(t)
+	inTempDir(t)
+	storage := newMemoryStorage()
+	storage.files["downloads/title.zip"] = []byte("PK archive")
+	scraper := &Scraper{Config: &Config{}, Storage: storage}
+	if got := scraper.uniquePath(context.Background(), "downloads/title.zip", "https://gallery.example/g/1"); got != "downloads/title (2).zip" {
+		t.Errorf("%s, want downloads/title (2).zip", got)
+	}
+}
+
+// What reads the saved archive back from the disk is rejected with
+// another storage.
+func TestCheckStorage(t *testing.T) {
+	page := &Page{}
+	for _, scraper := range []*Scraper{
+		{Config: &Config{}, Update: true},
+		{Config: &Config{}, Repair: "missing"},
+		{Config: &Config{Upload: &Upload{}}},
+		{Config: &Config{ArchiveMtime: true}},
+		{Config: &Config{PostSaveCommand: []string{"true"}}},
+	} {
+		if err := scraper.checkStorage(page); err != nil {
+			t.Errorf("%+v on the local disk: %v", scraper, err)
+		}
+		scraper.Storage = newMemoryStorage()
+		if err := scraper.checkStorage(page); err == nil {
+			t.Errorf("%+v accepted with another storage", scraper)
+		}
+	}
+	if err := (&Scraper{Config: &Config{}, Storage: newMemoryStorage()}).checkStorage(page); err != nil {
+		t.Error(err)
+	}
+}
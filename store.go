@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps every downloaded image once under <dir>/<sha256>, with an
+// index from source URL to blob so known URLs are not fetched again.
+type Store struct {
+	dir string
+
+	mu    sync.Mutex
+	index map[string]storeEntry
+	dirty bool
+}
+
+type storeEntry struct {
+	Sha256   string    `json:"sha256"`
+	Name     string    `json:"name"`
+	Modified time.Time `json:"modified,omitempty"`
+}
+
+func openStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	st := &Store{dir: dir, index: make(map[string]storeEntry)}
+	b, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, json.Unmarshal(b, &st.index)
+}
+
+// store returns the store of the config, opening it on first use, or nil
+// when no store is configured.
+func (s *Scraper) store() (*Store, error) {
+	if s.Config.Store == "" {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blobs == nil {
+		st, err := openStore(s.Config.Store)
+		if err != nil {
+			return nil, err
+		}
+		s.blobs = st
+	}
+	return s.blobs, nil
+}
+
+// Get reads the image downloaded from src before, or returns nil.
+func (st *Store) Get(src string) *Image {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	entry, ok := st.index[src]
+	st.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	b, err := os.ReadFile(filepath.Join(st.dir, entry.Sha256))
+	if err != nil || checksum(b) != entry.Sha256 {
+		return nil
+	}
+	return &Image{Name: entry.Name, Bytes: newBody(b), Modified: entry.Modified}
+}
+
+// Put stores image under its hash unless a blob with it is there already.
+func (st *Store) Put(src string, image *Image) error {
+	if st == nil {
+		return nil
+	}
+	sum := image.Bytes.Sha256()
+	path := filepath.Join(st.dir, sum)
+	if !exists(path) {
+		// Two downloads of the same bytes may race here; each writes its
+		// own temp file and the last rename wins.
+		tmp, err := os.CreateTemp(st.dir, sum+".*.tmp")
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tmp, image.Bytes.Reader())
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.index[src] = storeEntry{Sha256: sum, Name: image.Name, Modified: image.Modified}
+	st.dirty = true
+	return nil
+}
+
+// Flush writes the index if it changed.
+func (st *Store) Flush() error {
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.dirty {
+		return nil
+	}
+	b, err := json.MarshalIndent(st.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(st.dir, "index.json")
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	st.dirty = false
+	return nil
+}
+
+// gc removes the blobs that no archive manifest under archives refers
+// to, and the index entries of removed blobs.
+func (st *Store) gc(ctx context.Context, archives string) (int, error) {
+	referenced := make(map[string]bool)
+	err := filepath.Walk(archives, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".zip") {
+			return err
+		}
+		_, m, err := readArchive(ctx, path)
+		if err != nil {
+			log.Println("WARNING:", path, err)
+			return nil
+		}
+		if m == nil {
+			return nil
+		}
+		for _, image := range m.Images {
+			referenced[image.Sha256] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	blobs, err := os.ReadDir(st.dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, blob := range blobs {
+		name := blob.Name()
+		if name == "index.json" || referenced[name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(st.dir, name)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	st.mu.Lock()
+	for src, entry := range st.index {
+		if !referenced[entry.Sha256] {
+			delete(st.index, src)
+			st.dirty = true
+		}
+	}
+	st.mu.Unlock()
+	return removed, st.Flush()
+}
+
+// storeCommand runs the store subcommands, only gc so far.
+func storeCommand(config *Config, args []string) error {
+	if len(args) == 0 || args[0] != "gc" {
+		return usageError(errors.New("Usage: scrape-go store gc"))
+	}
+	flags := newFlagSet("store gc")
+	flags.BoolVar(&debugEnabled, "debug", false, "log debug details")
+	if err := parseFlags(flags, args[1:]); err != nil {
+		return err
+	}
+	if config.Store == "" {
+		return usageError(errors.New("No store configured"))
+	}
+	st, err := openStore(config.Store)
+	if err != nil {
+		return err
+	}
+	removed, err := st.gc(context.Background(), downloadsDir)
+	if err != nil {
+		return err
+	}
+	log.Println("Removed", removed, "unreferenced blobs from", config.Store)
+	return nil
+}
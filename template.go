@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// nameVariables are the variables of filename templates. {date} and
+// {datetime} take an optional Go time layout, as in {date:20060102}.
+var nameVariables = []string{"title", "page_name", "host", "date", "datetime"}
+
+var defaultLayouts = map[string]string{
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02T150405",
+}
+
+type templatePart struct {
+	Literal string
+	Name    string
+	Layout  string
+}
+
+// nameTemplate is a parsed template such as "{date}_{host}_{title}".
+// Expanded values go through sanitize; the literal text does not.
+type nameTemplate struct {
+	parts []templatePart
+}
+
+func parseNameTemplate(s string, variables []string) (*nameTemplate, error) {
+	known := make(map[string]bool, len(variables))
+	for _, v := range variables {
+		known[v] = true
+	}
+	tmpl := &nameTemplate{}
+	for s != "" {
+		open := strings.IndexByte(s, '{')
+		if open < 0 {
+			tmpl.parts = append(tmpl.parts, templatePart{Literal: s})
+			break
+		}
+		if 0 < open {
+			tmpl.parts = append(tmpl.parts, templatePart{Literal: s[:open]})
+		}
+		end := strings.IndexByte(s[open:], '}')
+		if end < 0 {
+			return nil, errors.New("unclosed { in " + s)
+		}
+		name := s[open+1 : open+end]
+		layout := ""
+		if i := strings.IndexByte(name, ':'); 0 <= i {
+			name, layout = name[:i], name[i+1:]
+			if _, ok := defaultLayouts[name]; !ok {
+				return nil, errors.New("{" + name + "} takes no format")
+			}
+			if layout == "" {
+				return nil, errors.New("empty format for {" + name + "}")
+			}
+		}
+		if !known[name] {
+			return nil, errors.New("unknown variable {" + name + "}, expected one of " + strings.Join(variables, ", "))
+		}
+		if layout == "" {
+			layout = defaultLayouts[name]
+		}
+		tmpl.parts = append(tmpl.parts, templatePart{Name: name, Layout: layout})
+		s = s[open+end+1:]
+	}
+	return tmpl, nil
+}
+
+// Expand fills in the template. "date" and "datetime" in vars hold the
+// time in RFC 3339 and are reformatted with each part's layout.
+func (t *nameTemplate) Expand(vars map[string]string) string {
+	return t.expand(vars, sanitize)
+}
+
+// expand is Expand with escape in place of sanitize.
+func (t *nameTemplate) expand(vars map[string]string, escape func(string) string) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		switch {
+		case part.Name == "":
+			b.WriteString(part.Literal)
+		case part.Layout != "":
+			when, err := time.Parse(time.RFC3339Nano, vars[part.Name])
+			if err != nil {
+				when = time.Now()
+			}
+			b.WriteString(escape(when.Format(part.Layout)))
+		default:
+			b.WriteString(escape(vars[part.Name]))
+		}
+	}
+	return b.String()
+}
+
+// templateVars are the filename variables of result.
+func templateVars(result *Result) map[string]string {
+	host := ""
+	if u, err := url.Parse(result.Url); err == nil {
+		host = u.Hostname()
+	}
+	started := result.Started.Format(time.RFC3339Nano)
+	return map[string]string{
+		"title":     result.Title,
+		"page_name": result.Page,
+		"host":      host,
+		"date":      started,
+		"datetime":  started,
+	}
+}
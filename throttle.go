@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultThrottleWindow        = 20
+	defaultThrottleErrorRate     = 0.2
+	defaultThrottleLatencyFactor = 3
+	defaultThrottleDelay         = 500 * time.Millisecond
+	defaultThrottleMaxDelay      = 30 * time.Second
+	// slowDownMaxBytes bounds the bodies looked through for slowDownMarkers;
+	// a whole page that mentions a rate limit is not asking to slow down.
+	slowDownMaxBytes = 16 << 10
+)
+
+// slowDownMarkers are found in the 200 pages some hosts serve instead of
+// a 429 when pushed too hard.
+var slowDownMarkers = [][]byte{
+	[]byte("too many requests"),
+	[]byte("slow down"),
+	[]byte("rate limit exceeded"),
+	[]byte("you are being rate limited"),
+	[]byte("request rate is too high"),
+}
+
+// Throttle adapts the rate of every host to how it copes. With
+// AdaptiveThrottle, once ThrottleWindow (20) requests to a host have
+// finished, the host is slowed down when more than ThrottleErrorRate
+// (0.2) of them failed, with 429s, 5xx, timeouts or "slow down" pages, or
+// when their median latency is over ThrottleLatencyFactor (3) times that
+// of its first window: its concurrency is halved and its delay doubled,
+// from ThrottleDelay (500ms) up to ThrottleMaxDelay (30s). Every healthy
+// window after that takes one step back.
+type Throttle struct {
+	AdaptiveThrottle      bool     `toml:"adaptive_throttle"`
+	ThrottleWindow        int      `toml:"throttle_window"`
+	ThrottleErrorRate     float64  `toml:"throttle_error_rate"`
+	ThrottleLatencyFactor float64  `toml:"throttle_latency_factor"`
+	ThrottleDelay         Duration `toml:"throttle_delay"`
+	ThrottleMaxDelay      Duration `toml:"throttle_max_delay"`
+}
+
+func (t Throttle) validate() error {
+	if t.ThrottleWindow < 0 || t.ThrottleDelay.Duration < 0 || t.ThrottleMaxDelay.Duration < 0 {
+		return errors.New("throttle_window, throttle_delay and throttle_max_delay must not be negative")
+	}
+	if t.ThrottleErrorRate < 0 || 1 < t.ThrottleErrorRate {
+		return errors.New("throttle_error_rate must be between 0 and 1")
+	}
+	if t.ThrottleLatencyFactor != 0 && t.ThrottleLatencyFactor <= 1 {
+		return errors.New("throttle_latency_factor must be over 1")
+	}
+	return nil
+}
+
+// hostThrottle is the state of one host under adaptive throttling. A
+// limit of 0 leaves its concurrency alone, and a delay of 0 its pace.
+type hostThrottle struct {
+	limit    int
+	delay    time.Duration
+	inflight int
+	// peak is the most requests in flight at once, which the limit starts
+	// from and recovers to.
+	peak int
+	next time.Time
+	// freed is closed, and replaced, whenever a request ends or the limit
+	// changes.
+	freed chan struct{}
+
+	latencies []time.Duration
+	failures  int
+	baseline  time.Duration
+	// slowed counts the slow-downs, for reportThrottles.
+	slowed int
+}
+
+// throttles are the hosts of a run under adaptive throttling. A nil
+// *throttles throttles nothing.
+type throttles struct {
+	Throttle
+
+	mu    sync.Mutex
+	hosts map[string]*hostThrottle
+}
+
+func newThrottles(t Throttle) *throttles {
+	if !t.AdaptiveThrottle {
+		return nil
+	}
+	if t.ThrottleWindow == 0 {
+		t.ThrottleWindow = defaultThrottleWindow
+	}
+	if t.ThrottleErrorRate == 0 {
+		t.ThrottleErrorRate = defaultThrottleErrorRate
+	}
+	if t.ThrottleLatencyFactor == 0 {
+		t.ThrottleLatencyFactor = defaultThrottleLatencyFactor
+	}
+	if t.ThrottleDelay.Duration == 0 {
+		t.ThrottleDelay.Duration = defaultThrottleDelay
+	}
+	if t.ThrottleMaxDelay.Duration == 0 {
+		t.ThrottleMaxDelay.Duration = defaultThrottleMaxDelay
+	}
+	return &throttles{Throttle: t, hosts: map[string]*hostThrottle{}}
+}
+
+func (t *throttles) host(host string) *hostThrottle {
+	h, ok := t.hosts[host]
+	if !ok {
+		h = &hostThrottle{freed: make(chan struct{})}
+		t.hosts[host] = h
+	}
+	return h
+}
+
+// acquire waits until host is below its limit and its delay is behind,
+// and returns the function ending the request.
+func (t *throttles) acquire(ctx context.Context, host string) (func(), error) {
+	if t == nil {
+		return func() {}, nil
+	}
+	t.mu.Lock()
+	h := t.host(host)
+	for h.limit != 0 && h.limit <= h.inflight {
+		freed := h.freed
+		t.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		t.mu.Lock()
+	}
+	h.inflight++
+	if h.peak < h.inflight {
+		h.peak = h.inflight
+	}
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(h.delay)
+	t.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			t.mu.Lock()
+			h.inflight--
+			h.free()
+			t.mu.Unlock()
+		})
+	}
+	if err := sleep(ctx, time.Until(start)); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+func (h *hostThrottle) free() {
+	close(h.freed)
+	h.freed = make(chan struct{})
+}
+
+// observe records a request to host that took latency, and failed when
+// failed, adjusting the host once its window is full.
+func (t *throttles) observe(host string, latency time.Duration, failed bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.host(host)
+	h.latencies = append(h.latencies, latency)
+	if failed {
+		h.failures++
+	}
+	if len(h.latencies) < t.ThrottleWindow {
+		return
+	}
+	latency = median(h.latencies)
+	rate := float64(h.failures) / float64(len(h.latencies))
+	h.latencies, h.failures = nil, 0
+	if h.baseline == 0 && rate <= t.ThrottleErrorRate {
+		h.baseline = latency
+		return
+	}
+
+	var reason string
+	switch {
+	case t.ThrottleErrorRate < rate:
+		reason = fmt.Sprintf("%.0f%% failed", rate*100)
+	case 0 < h.baseline && float64(h.baseline)*t.ThrottleLatencyFactor < float64(latency):
+		reason = "median latency " + latency.Round(time.Millisecond).String() + " against " + h.baseline.Round(time.Millisecond).String()
+	}
+	if reason != "" {
+		h.slowDown(t.Throttle)
+		log.Println("Host", host, "slowed down to", h.rate()+":", reason)
+		return
+	}
+	if h.limit != 0 || h.delay != 0 {
+		h.recover(t.Throttle)
+		log.Println("Host", host, "recovering to", h.rate())
+	}
+}
+
+// slowDown halves the concurrency of h and doubles its delay.
+func (h *hostThrottle) slowDown(t Throttle) {
+	limit := h.limit
+	if limit == 0 {
+		limit = h.peak
+	}
+	h.limit = limit / 2
+	if h.limit < 1 {
+		h.limit = 1
+	}
+	h.delay *= 2
+	if h.delay == 0 {
+		h.delay = t.ThrottleDelay.Duration
+	}
+	if t.ThrottleMaxDelay.Duration < h.delay {
+		h.delay = t.ThrottleMaxDelay.Duration
+	}
+	h.slowed++
+}
+
+// recover takes one step back from slowDown: one more request at once,
+// until the peak is reached, and half the delay, until below
+// throttle_delay.
+func (h *hostThrottle) recover(t Throttle) {
+	if h.limit != 0 {
+		h.limit++
+		if h.peak <= h.limit {
+			h.limit = 0
+		}
+		h.free()
+	}
+	h.delay /= 2
+	if h.delay < t.ThrottleDelay.Duration {
+		h.delay = 0
+	}
+}
+
+// rate describes the limit and delay of h.
+func (h *hostThrottle) rate() string {
+	at := "unlimited"
+	if h.limit != 0 {
+		at = fmt.Sprint(h.limit, " at once")
+	}
+	if h.delay == 0 {
+		return at
+	}
+	return at + ", " + h.delay.String() + " apart"
+}
+
+func median(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return percentile(sorted, 0.5)
+}
+
+// throttleFailure tells whether err is a host asking to be left alone
+// rather than a request that could never succeed.
+func throttleFailure(err error) bool {
+	return err != nil && !errors.Is(err, errUnchanged) && (transient(err) || errors.Is(err, errStalled) || errors.Is(err, errImageTimeout))
+}
+
+// slowDown tells whether a body served as contentType is a page asking to
+// slow down rather than what was asked for.
+func slowDown(contentType string, body io.Reader) bool {
+	mediatype, _, _ := mime.ParseMediaType(contentType)
+	if mediatype != "text/html" && mediatype != "text/plain" {
+		return false
+	}
+	b, err := io.ReadAll(io.LimitReader(body, slowDownMaxBytes+1))
+	if err != nil || slowDownMaxBytes < len(b) {
+		return false
+	}
+	b = bytes.ToLower(b)
+	for _, marker := range slowDownMarkers {
+		if bytes.Contains(b, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe records a request to rawurl under the throttles of f.
+func (f *fetchers) observe(rawurl string, latency time.Duration, failed bool) {
+	if u, err := url.Parse(rawurl); err == nil {
+		f.throttle.observe(u.Hostname(), latency, failed)
+	}
+}
+
+// observeDocument records the fetch of the document rawurl, started at
+// start, under the fetchers of ctx, if any.
+func observeDocument(ctx context.Context, rawurl string, start time.Time, failed bool) {
+	if f, ok := ctx.Value(fetchersKey{}).(*fetchers); ok {
+		f.observe(rawurl, time.Since(start), failed)
+	}
+}
+
+// reportThrottles logs the hosts slowed down since the last report, with
+// the rate they are at.
+func (s *Scraper) reportThrottles() {
+	t := s.fetchers().throttle
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hosts := make([]string, 0, len(t.hosts))
+	for host, h := range t.hosts {
+		if 0 < h.slowed {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		h := t.hosts[host]
+		log.Println("Host", host, "slowed down", h.slowed, "times, now at", h.rate())
+		h.slowed = 0
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"path"
+	"strings"
+)
+
+const (
+	defaultThumbnailSize = 320
+	// maxThumbnailPixels keeps giant images from being decoded whole.
+	maxThumbnailPixels = 64 << 20
+	thumbnailQuality   = 80
+)
+
+// ThumbnailOptions add a JPEG thumbnail of every image, at most ThumbnailSize
+// (320) pixels on its longer edge, under thumbs/ in the archive, named in
+// the manifest.
+type ThumbnailOptions struct {
+	Thumbnails    bool `toml:"thumbnails"`
+	ThumbnailSize int  `toml:"thumbnail_size"`
+}
+
+func (t ThumbnailOptions) validate() error {
+	if t.ThumbnailSize < 0 {
+		return errors.New("thumbnail_size must be positive")
+	}
+	return nil
+}
+
+// thumbnails returns the thumbnail entries of images for page, or nil
+// when it has none, and sets the Thumbnail of each image they are of.
+// Images that cannot be decoded are warned about and go without.
+func (s *Scraper) thumbnails(ctx context.Context, page *Page, images []*Image) []*Image {
+	if !s.Config.Thumbnails && !page.Thumbnails {
+		return nil
+	}
+	size := page.ThumbnailSize
+	if size == 0 {
+		size = s.Config.ThumbnailSize
+	}
+	if size == 0 {
+		size = defaultThumbnailSize
+	}
+	var thumbs []*Image
+	for _, image := range images {
+		if ctx.Err() != nil {
+			break
+		}
+		thumb, err := thumbnail(ctx, image, size)
+		if err != nil {
+			logln(ctx, "WARNING: no thumbnail of", image.Name+":", err)
+			continue
+		}
+		image.Thumbnail = thumb.Name
+		thumbs = append(thumbs, thumb)
+	}
+	return thumbs
+}
+
+// thumbnail scales image down to size pixels on its longer edge, or
+// keeps its size if smaller, and encodes it as JPEG.
+func thumbnail(ctx context.Context, img *Image, size int) (*Image, error) {
+	config, _, err := image.DecodeConfig(img.Bytes.Reader())
+	if err != nil {
+		return nil, err
+	}
+	if maxThumbnailPixels < config.Width*config.Height {
+		return nil, errors.New("too large to decode")
+	}
+	src, _, err := image.Decode(img.Bytes.Reader())
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return nil, errors.New("empty image")
+	}
+	if size < w || size < h {
+		if h < w {
+			w, h = size, h*size/w
+		} else {
+			w, h = w*size/h, size
+		}
+		if w == 0 {
+			w = 1
+		}
+		if h == 0 {
+			h = 1
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// Transparent areas turn white rather than black in the JPEG.
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	body := spoolBody(ctx)
+	if err := jpeg.Encode(body, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		body.Close()
+		return nil, err
+	}
+	name := "thumbs/" + strings.TrimSuffix(img.Name, path.Ext(img.Name)) + ".jpg"
+	return &Image{Name: name, Bytes: body, Index: img.Index, Modified: img.Modified}, nil
+}
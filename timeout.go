@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	errHeaderTimeout = errors.New("No response within header_timeout")
+	errIdleTimeout   = errors.New("No data within idle_timeout")
+)
+
+// Timeouts bound the requests of a page without capping slow but steady
+// downloads. An image fails when its response takes HeaderTimeout to
+// come, or when its body goes IdleTimeout without data, however long it
+// takes overall. DocumentTimeout bounds each document fetch as a whole.
+type Timeouts struct {
+	HeaderTimeout   Duration `toml:"header_timeout"`
+	IdleTimeout     Duration `toml:"idle_timeout"`
+	DocumentTimeout Duration `toml:"document_timeout"`
+}
+
+func (t Timeouts) validate() error {
+	if t.HeaderTimeout.Duration < 0 || t.IdleTimeout.Duration < 0 || t.DocumentTimeout.Duration < 0 {
+		return errors.New("header_timeout, idle_timeout and document_timeout must not be negative")
+	}
+	return nil
+}
+
+// timeouts is the effective Timeouts of page.
+func (s *Scraper) timeouts(page *Page) Timeouts {
+	duration := func(page, global Duration) Duration {
+		if page.Duration != 0 {
+			return page
+		}
+		return global
+	}
+	global := s.Config.Timeouts
+	return Timeouts{
+		HeaderTimeout:   duration(page.HeaderTimeout, global.HeaderTimeout),
+		IdleTimeout:     duration(page.IdleTimeout, global.IdleTimeout),
+		DocumentTimeout: duration(page.DocumentTimeout, global.DocumentTimeout),
+	}
+}
+
+type timeoutsKey struct{}
+
+func withTimeouts(ctx context.Context, t Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, t)
+}
+
+// timeoutsOf is the Timeouts of the scrape of ctx, none when unset.
+func timeoutsOf(ctx context.Context) Timeouts {
+	t, _ := ctx.Value(timeoutsKey{}).(Timeouts)
+	return t
+}
+
+// downloadTimeout reports whether err is a header, idle or image_fetch
+// timeout, which are retried like stalls.
+func downloadTimeout(err error) bool {
+	return errors.Is(err, errHeaderTimeout) || errors.Is(err, errIdleTimeout) || errors.Is(err, errImageTimeout)
+}
+
+// documentContext bounds a document fetch by the document_timeout of ctx.
+func documentContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := timeoutsOf(ctx).DocumentTimeout.Duration; 0 < timeout {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {}
+}
+
+// doTimed sends the image request req under the header_timeout of its
+// context, and returns the response with a body that fails once it goes
+// idle_timeout without data and holds its share of max_inflight_bytes.
+func doTimed(client *http.Client, req *http.Request) (*http.Response, error) {
+	t := timeoutsOf(req.Context())
+	if t.HeaderTimeout.Duration == 0 && t.IdleTimeout.Duration == 0 {
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		return budgeted(req.Context(), res)
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	var headers *time.Timer
+	if 0 < t.HeaderTimeout.Duration {
+		headers = time.AfterFunc(t.HeaderTimeout.Duration, func() { cancel(errHeaderTimeout) })
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if headers != nil {
+		headers.Stop()
+	}
+	if err != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, errHeaderTimeout) {
+			err = fmt.Errorf("%w (%v)", cause, t.HeaderTimeout)
+		}
+		cancel(nil)
+		return nil, err
+	}
+	res.Body = &idleBody{ReadCloser: res.Body, ctx: ctx, cancel: cancel, idle: t.IdleTimeout.Duration}
+	return budgeted(req.Context(), res)
+}
+
+// idleBody is a response body whose reads fail once it has gone idle
+// without data, timed by a timer every read resets. The timer starts on
+// the first read, so the wait for max_inflight_bytes does not count.
+type idleBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	idle   time.Duration
+	timer  *time.Timer
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if b.timer == nil && 0 < b.idle {
+		b.timer = time.AfterFunc(b.idle, func() { b.cancel(errIdleTimeout) })
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		if cause := context.Cause(b.ctx); errors.Is(cause, errIdleTimeout) {
+			return n, fmt.Errorf("%w (%v)", cause, b.idle)
+		}
+		return n, err
+	}
+	if b.timer != nil && 0 < n {
+		b.timer.Reset(b.idle)
+	}
+	return n, nil
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
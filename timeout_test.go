@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamingServer serves chunks of size bytes every interval, with the
+// Content-Length of all of them, and then stalls for stall.
+func streamingServer(chunks int, size int, interval time.Duration, stall time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(chunks*size))
+		chunk := make([]byte, size)
+		for i := 0; i < chunks; i++ {
+			if i == chunks-1 && 0 < stall {
+				select {
+				case <-time.After(stall):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(interval)
+		}
+	}))
+}
+
+func timedContext(idle time.Duration, inflight int64) context.Context {
+	ctx := withTimeouts(context.Background(), Timeouts{IdleTimeout: Duration{idle}})
+	return withFetchers(ctx, &fetchers{inflight: newByteBudget(inflight, 0)})
+}
+
+// A download waiting for max_inflight_bytes is not idle: only its reads
+// are timed by idle_timeout.
+func TestIdleTimeoutWaitsForBudget(t *testing.T) {
+	server := streamingServer(10, 100<<10, 40*time.Millisecond, 0)
+	defer server.Close()
+	ctx := timedContext(200*time.Millisecond, 1500<<10)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			image, err := downloadImage(ctx, server.Client(), server.URL+"/"+strconv.Itoa(i)+".jpg")
+			if err == nil && image.Bytes.Len() != 10*100<<10 {
+				err = errors.New("short body " + strconv.Itoa(image.Bytes.Len()))
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("download %d: %v", i, err)
+		}
+	}
+}
+
+func TestIdleTimeoutStalledBody(t *testing.T) {
+	server := streamingServer(3, 1<<10, 0, time.Second)
+	defer server.Close()
+	ctx := timedContext(200*time.Millisecond, 1500<<10)
+
+	_, err := downloadImage(ctx, server.Client(), server.URL+"/stalled.jpg")
+	if !errors.Is(err, errIdleTimeout) {
+		t.Fatalf("err = %v, want %v", err, errIdleTimeout)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TitleOptions post-process the title before it names anything.
+type TitleOptions struct {
+	// TitleMaxLength caps the title at this many bytes, which is what file
+	// systems limit, cutting at a rune boundary and appending a short hash
+	// of the full title so truncated titles stay unique.
+	TitleMaxLength int `toml:"title_max_length"`
+	// TitleSlug turns the title into a lowercase ASCII slug.
+	TitleSlug bool `toml:"title_slug"`
+	// TitleSlugUnicode keeps the letters and digits of every script in the
+	// slug, so Japanese or Cyrillic titles stay readable instead of
+	// becoming a hash.
+	TitleSlugUnicode bool `toml:"title_slug_unicode"`
+}
+
+// TitleRule replaces what the regexp Find matches in the raw title with
+// Replace, in which $1 or ${name} stand for the groups of the match.
+type TitleRule struct {
+	Find    string
+	Replace string
+
+	find *regexp.Regexp
+}
+
+func compileTitleRules(rules []TitleRule) error {
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Find)
+		if err != nil {
+			return fmt.Errorf("title_rules: %v", err)
+		}
+		rules[i].find = re
+	}
+	return nil
+}
+
+// applyTitleRules applies the title_rules of p to title in order, and
+// trims what they leave around it.
+func (p *Page) applyTitleRules(title string) string {
+	if len(p.TitleRules) == 0 {
+		return title
+	}
+	for _, rule := range p.TitleRules {
+		title = rule.find.ReplaceAllString(title, rule.Replace)
+	}
+	return strings.TrimSpace(title)
+}
+
+func titleHash(title string) string {
+	sum := sha1.Sum([]byte(title))
+	return hex.EncodeToString(sum[:4])
+}
+
+// slug keeps the ASCII letters and digits of title, with accents dropped,
+// and joins the runs in between with "-". Titles without any, like most
+// Japanese ones, become their hash. With keepScripts, letters and digits of
+// other scripts are kept too, with their marks: only the accents of ASCII
+// letters are dropped, so dakuten and the like survive.
+func slug(title string, keepScripts bool) string {
+	var b strings.Builder
+	dash := false
+	ascii := true
+	for _, r := range norm.NFD.String(title) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			if !ascii && !dash {
+				b.WriteRune(r)
+			}
+		case (r < utf8.RuneSelf || keepScripts) && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && 0 < b.Len() {
+				b.WriteByte('-')
+			}
+			dash = false
+			ascii = r < utf8.RuneSelf
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return titleHash(title)
+	}
+	return norm.NFC.String(b.String())
+}
+
+// truncate cuts title to at most max bytes, hash included.
+func truncate(title string, max int) string {
+	if len(title) <= max {
+		return title
+	}
+	suffix := "-" + titleHash(title)
+	cut := max - len(suffix)
+	if cut <= 0 {
+		return suffix[1:]
+	}
+	for !utf8.RuneStart(title[cut]) {
+		cut--
+	}
+	return title[:cut] + suffix
+}
+
+// processTitle normalizes title to NFC, so macOS and Linux agree on the
+// file name, then applies the title options of page.
+func (s *Scraper) processTitle(page *Page, title string) string {
+	title = norm.NFC.String(title)
+	if s.Config.TitleSlug || page.TitleSlug {
+		title = slug(title, s.Config.TitleSlugUnicode || page.TitleSlugUnicode)
+	}
+	max := page.TitleMaxLength
+	if max == 0 {
+		max = s.Config.TitleMaxLength
+	}
+	if 0 < max {
+		title = truncate(title, max)
+	}
+	return title
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// readArchive loads the entries of the archive at p, with its manifest if
+// it has one. Entries that fail to read, like truncated ones, are left
+// out.
+func readArchive(ctx context.Context, p string) (map[string]*Image, *manifest, error) {
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	entries := make(map[string]*Image)
+	var m *manifest
+	for _, f := range r.File {
+		buf := new(bytes.Buffer)
+		rc, err := f.Open()
+		if err == nil {
+			_, err = io.Copy(buf, rc)
+			rc.Close()
+		}
+		if err != nil {
+			logln(ctx, "Corrupt entry", f.Name+":", err)
+			continue
+		}
+		if f.Name == "manifest.json" {
+			m = &manifest{}
+			if err := json.Unmarshal(buf.Bytes(), m); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		entries[f.Name] = &Image{Name: f.Name, Bytes: newBody(buf.Bytes()), Modified: f.Modified}
+	}
+	return entries, m, nil
+}
+
+// baseName is the file name of an entry or src, without the index prefix
+// of the flat layout.
+func baseName(name string) string {
+	if u, err := url.Parse(name); err == nil && u.Path != "" {
+		name = u.Path
+	}
+	name = path.Base(name)
+	if i := strings.IndexByte(name, '-'); 0 < i {
+		if _, err := strconv.Atoi(name[:i]); err == nil {
+			return name[i+1:]
+		}
+	}
+	return name
+}
+
+// update is an archive being brought up to date with the srcs of a page.
+type update struct {
+	// kept are the archived images, numbered by their position in srcs
+	// or, when gone from the page, after all of them.
+	kept []*Image
+	// download are the srcs not archived yet, found at positions in srcs.
+	download  []string
+	positions []int
+}
+
+// planUpdate matches srcs against the archive at p by the source URLs of
+// its manifest or, without one, by entry name. Entries missing, unreadable
+// or not matching their manifest checksum are downloaded again.
+func planUpdate(ctx context.Context, p string, srcs []string) (*update, error) {
+	entries, m, err := readArchive(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	archived := make(map[string]*Image)
+	if m != nil {
+		for _, image := range m.Images {
+			entry, ok := entries[image.Name]
+			if !ok {
+				logln(ctx, "Missing entry", image.Name)
+			} else if image.Sha256 != "" && image.Sha256 != entry.Bytes.Sha256() {
+				logln(ctx, "Checksum mismatch", image.Name)
+			} else {
+				entry.Src = image.Url
+				if image.LastModified != nil {
+					entry.Modified = *image.LastModified
+				}
+				archived[image.Url] = entry
+			}
+		}
+	} else {
+		for name, entry := range entries {
+			archived[baseName(name)] = entry
+		}
+	}
+
+	u := &update{}
+	used := make(map[*Image]bool)
+	for i, src := range srcs {
+		key := src
+		if m == nil {
+			key = baseName(src)
+		}
+		entry, ok := archived[key]
+		if !ok || used[entry] {
+			u.download = append(u.download, src)
+			u.positions = append(u.positions, i)
+			continue
+		}
+		used[entry] = true
+		entry.Src = src
+		entry.Index = i
+		entry.Name = strconv.Itoa(i) + "-" + baseName(entry.Name)
+		u.kept = append(u.kept, entry)
+	}
+	var gone []*Image
+	for _, entry := range archived {
+		if !used[entry] {
+			gone = append(gone, entry)
+		}
+	}
+	// Flat entry names start with their old index.
+	sort.Slice(gone, func(i, j int) bool { return oldIndex(gone[i]) < oldIndex(gone[j]) })
+	for i, entry := range gone {
+		logln(ctx, "Kept", entry.Name, "gone from the page", displaySrc(entry.Src))
+		entry.Index = len(srcs) + i
+		entry.Name = strconv.Itoa(entry.Index) + "-" + baseName(entry.Name)
+		u.kept = append(u.kept, entry)
+	}
+	return u, nil
+}
+
+func oldIndex(image *Image) int {
+	n, err := strconv.Atoi(strings.SplitN(path.Base(image.Name), "-", 2)[0])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// renumber moves downloaded images and their errors to their positions in
+// the page's srcs.
+func (u *update) renumber(images []*Image, errs []error) {
+	for _, image := range images {
+		prefix := strconv.Itoa(image.Index) + "-"
+		image.Index = u.positions[image.Index]
+		image.Name = strconv.Itoa(image.Index) + "-" + strings.TrimPrefix(image.Name, prefix)
+	}
+	for _, err := range errs {
+		if e, ok := err.(*imageError); ok {
+			e.Index = u.positions[e.Index]
+		}
+	}
+}
+
+// backup moves the archive at p aside until the rewrite is saved; restore
+// puts it back if that failed and removes the backup otherwise.
+func backup(ctx context.Context, p string) (restore func(saved bool), err error) {
+	bak := p + ".bak"
+	if err := os.Rename(p, bak); err != nil {
+		return nil, err
+	}
+	return func(saved bool) {
+		if saved {
+			os.Remove(bak)
+			return
+		}
+		if err := os.Rename(bak, p); err != nil {
+			logln(ctx, "WARNING: could not restore", p, "from", bak+":", err)
+		}
+	}, nil
+}
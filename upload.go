@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const uploadRetries = 3
+
+// Upload configures where finished archives are copied after save.
+type Upload struct {
+	Type string
+	// KeepLocal keeps the saved archive after a successful upload. It
+	// defaults to true, so only an explicit keep_local = false deletes.
+	KeepLocal *bool `toml:"keep_local"`
+	// Path is the remote name of the archive, a template over the
+	// filename variables plus {filename}, the saved archive's base name.
+	Path string
+
+	// s3
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKeyEnv string `toml:"access_key_env"`
+	SecretKeyEnv string `toml:"secret_key_env"`
+	PartSize     int64  `toml:"part_size"`
+
+	// webdav; Username and Password go through os.ExpandEnv.
+	Url           string
+	Username      string
+	Password      string
+	SkipTLSVerify bool `toml:"skip_tls_verify"`
+
+	// sftp; without KeyFile the ssh agent at $SSH_AUTH_SOCK is used.
+	Host                  string
+	Port                  int
+	User                  string
+	KeyFile               string `toml:"key_file"`
+	KnownHosts            string `toml:"known_hosts"`
+	InsecureIgnoreHostKey bool   `toml:"insecure_ignore_host_key"`
+
+	path *nameTemplate
+}
+
+// Uploader copies a local file to name on a remote store and returns where
+// it ended up.
+type Uploader interface {
+	Upload(ctx context.Context, local string, name string) (string, error)
+}
+
+func (u *Upload) validate() error {
+	tmpl, err := parseNameTemplate(or(u.Path, "{filename}"), append(nameVariables, "filename"))
+	if err != nil {
+		return fmt.Errorf("path: %v", err)
+	}
+	u.path = tmpl
+
+	switch u.Type {
+	case "s3":
+		if u.Endpoint == "" || u.Bucket == "" {
+			return errors.New("s3 needs endpoint and bucket")
+		}
+	case "webdav":
+		if u.Url == "" {
+			return errors.New("webdav needs url")
+		}
+	case "sftp":
+		if u.Host == "" || u.User == "" {
+			return errors.New("sftp needs host and user")
+		}
+	default:
+		return errors.New("unknown type " + u.Type)
+	}
+	return nil
+}
+
+func (u *Upload) uploader() (Uploader, error) {
+	switch u.Type {
+	case "s3":
+		return newS3Uploader(u)
+	case "webdav":
+		return newWebdavUploader(u)
+	case "sftp":
+		return newSftpUploader(u)
+	}
+	return nil, errors.New("unknown upload type " + u.Type)
+}
+
+// upload returns the upload settings of page, falling back to the global.
+func (s *Scraper) upload(page *Page) *Upload {
+	if page.Upload != nil {
+		return page.Upload
+	}
+	return s.Config.Upload
+}
+
+// expandTemplate replaces each {name} in tmpl with vars[name].
+func expandTemplate(tmpl string, vars map[string]string) string {
+	pairs := make([]string, 0, 2*len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(tmpl)
+}
+
+func withRetries(ctx context.Context, what string, f func() error) error {
+	var err error
+	for attempt := 0; attempt <= uploadRetries; attempt++ {
+		if 0 < attempt {
+			logln(ctx, what, "attempt", attempt, "failed:", err)
+			select {
+			case <-time.After(time.Duration(attempt*attempt) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err = f(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// uploadArchive uploads the archive saved for result. The local copy is
+// only removed once the upload succeeded and keep_local is false.
+func uploadArchive(ctx context.Context, u *Upload, result *Result) (string, error) {
+	uploader, err := u.uploader()
+	if err != nil {
+		return "", err
+	}
+	vars := templateVars(result)
+	vars["filename"] = filepath.Base(result.Path)
+	name := u.path.Expand(vars)
+
+	logln(ctx, "Upload", result.Path, "to", u.Type)
+	location, err := uploader.Upload(ctx, result.Path, name)
+	if err != nil {
+		return "", errors.New("Upload failed, kept " + result.Path + ": " + err.Error())
+	}
+	logln(ctx, "Uploaded", location)
+
+	if u.KeepLocal != nil && !*u.KeepLocal {
+		if err := os.Remove(result.Path); err != nil {
+			logln(ctx, "WARNING:", err)
+		}
+	}
+	return location, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+const (
+	userAgentPerRequest = "request"
+	userAgentPerPage    = "page"
+)
+
+// userAgentPresets are the ua_preset shorthands for user_agents.
+var userAgentPresets = map[string][]string{
+	"chrome": {
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	},
+	"firefox": {
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+	},
+	"safari": {
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Mobile/15E148 Safari/604.1",
+	},
+}
+
+func init() {
+	var desktop []string
+	for _, name := range []string{"chrome", "firefox", "safari"} {
+		desktop = append(desktop, userAgentPresets[name][0])
+	}
+	userAgentPresets["desktop"] = desktop
+}
+
+func (t *Transport) validateUserAgents() error {
+	if _, ok := userAgentPresets[t.UAPreset]; t.UAPreset != "" && !ok {
+		return errors.New("Unknown ua_preset " + t.UAPreset)
+	}
+	switch t.UserAgentRotation {
+	case "", userAgentPerRequest, userAgentPerPage:
+		return nil
+	default:
+		return errors.New("user_agent_rotation must be " + userAgentPerRequest + " or " + userAgentPerPage)
+	}
+}
+
+// userAgents is the rotation list of t, or nil.
+func (t *Transport) userAgents() []string {
+	if 0 < len(t.UserAgents) {
+		return t.UserAgents
+	}
+	return userAgentPresets[t.UAPreset]
+}
+
+// userAgentTransport sets the User-Agent of every request it sends to one
+// of agents, or to the first one it picked when sticky.
+type userAgentTransport struct {
+	base   http.RoundTripper
+	agents []string
+	sticky bool
+
+	once   sync.Once
+	picked string
+}
+
+func (t *userAgentTransport) pick() string {
+	if !t.sticky {
+		return t.agents[rand.Intn(len(t.agents))]
+	}
+	t.once.Do(func() {
+		t.picked = t.agents[rand.Intn(len(t.agents))]
+	})
+	return t.picked
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.pick())
+	}
+	return t.base.RoundTrip(req)
+}
+
+// userAgentTransport wraps base with the user agent of page. An explicit
+// user_agent beats a rotation list on the same level, and the page's
+// settings beat the global ones.
+func (s *Scraper) userAgentTransport(page *Page, base http.RoundTripper) http.RoundTripper {
+	global := &s.Config.Transport
+	var agents []string
+	switch {
+	case page.UserAgent != "":
+		agents = []string{page.UserAgent}
+	case page.userAgents() != nil:
+		agents = page.userAgents()
+	case global.UserAgent != "":
+		agents = []string{global.UserAgent}
+	default:
+		agents = global.userAgents()
+	}
+	if len(agents) == 0 {
+		return base
+	}
+	rotation := or(page.UserAgentRotation, global.UserAgentRotation)
+	sticky := rotation == userAgentPerPage || page.ProxySticky || global.ProxySticky
+	return &userAgentTransport{base: base, agents: agents, sticky: sticky}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+type cycleSummary struct {
+	Scraped int
+	Skipped int
+	// Unchanged counts the skipped pages unchanged since the last scrape.
+	Unchanged int
+	Failed    int
+	Images    int
+	Bytes     int64
+	Uploaded  int
+	Retried   int
+	TimedOut  int
+	Blocked   int
+	OffSite   int
+	// Placeholders counts the images flagged by placeholder_threshold.
+	Placeholders int
+	// Duplicates counts the images repeating an earlier one of their page.
+	Duplicates int
+	// SkippedImages counts the images skipped for their status.
+	SkippedImages map[int]int
+}
+
+func (s *cycleSummary) Add(result *Result, err error) {
+	if 1 < result.Attempts {
+		s.Retried++
+	}
+	s.Blocked += result.Blocked
+	s.OffSite += result.OffSite
+	s.Placeholders += result.Placeholders
+	s.Duplicates += result.Duplicates
+	for code, n := range result.SkippedImages {
+		if s.SkippedImages == nil {
+			s.SkippedImages = map[int]int{}
+		}
+		s.SkippedImages[code] += n
+	}
+	switch {
+	case err != nil:
+		s.Failed++
+		if errors.Is(err, context.DeadlineExceeded) {
+			s.TimedOut++
+		}
+	case result.Skipped:
+		s.Skipped++
+		if result.Unchanged {
+			s.Unchanged++
+		}
+	default:
+		s.Scraped++
+		s.Images += result.Images
+		s.Bytes += result.Bytes
+		if result.Uploaded != "" {
+			s.Uploaded++
+		}
+	}
+}
+
+// Err reports failed pages with exitFailed when none succeeded and
+// exitPartial otherwise.
+func (s *cycleSummary) Err() error {
+	if s.Failed == 0 {
+		return nil
+	}
+	total := s.Scraped + s.Skipped + s.Failed
+	err := errors.New(strconv.Itoa(s.Failed) + " of " + strconv.Itoa(total) + " pages failed")
+	if s.Failed == total {
+		return &exitError{Code: exitFailed, Err: err}
+	}
+	return &exitError{Code: exitPartial, Err: err}
+}
+
+func (s *cycleSummary) String() string {
+	skipped := ""
+	if 0 < len(s.SkippedImages) {
+		skipped = ", images skipped by status " + skippedStatuses(s.SkippedImages)
+	}
+	return fmt.Sprint(
+		s.Scraped, " scraped, ",
+		s.Skipped, " skipped (", s.Unchanged, " unchanged), ",
+		s.Failed, " failed (", s.TimedOut, " timed out), ",
+		s.Images, " images, ",
+		formatBytes(s.Bytes), ", ",
+		s.Uploaded, " uploaded, ",
+		s.Retried, " needed retries, ",
+		s.Blocked, " images blocked, ",
+		s.OffSite, " off-site URLs skipped, ",
+		s.Placeholders, " likely placeholders, ",
+		s.Duplicates, " duplicates",
+		skipped,
+	)
+}
+
+func runCycle(ctx context.Context, scraper *Scraper) cycleSummary {
+	var summary cycleSummary
+	for i := range scraper.Config.Pages {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			left := len(scraper.Config.Pages) - i
+			log.Println("Deadline exceeded,", left, "pages not scraped")
+			summary.Failed += left
+			summary.TimedOut += left
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		page := &scraper.Config.Pages[i]
+		if page.SitemapUrl != "" {
+			scraper.scrapeSitemap(ctx, page, summary.Add)
+			continue
+		}
+		if 0 < page.CrawlDepth {
+			scraper.crawl(ctx, page, summary.Add)
+			continue
+		}
+		result, err := scraper.scrape(ctx, page, page.Url, nil)
+		if err != nil {
+			log.Println("Failed", page.Url, err)
+		}
+		summary.Add(result, err)
+	}
+	return summary
+}
+
+// jitter spreads d by up to ±fraction so several watchers started together
+// drift apart instead of hitting the same sites at the same moment.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	delta := (rand.Float64()*2 - 1) * fraction * float64(d)
+	return d + time.Duration(delta)
+}
+
+func watch(config *Config, args []string) error {
+	flags := newFlagSet("watch")
+	interval := flags.Duration("interval", 6*time.Hour, "time between checks")
+	spread := flags.Float64("jitter", 0.1, "random fraction of the interval added or removed per cycle")
+	once := flags.Bool("once", false, "run a single cycle and exit")
+	metricsListen := flags.String("metrics-listen", "", "serve /metrics and /debug/vars on this address")
+	verbose := flags.Bool("verbose", false, "print per-host download statistics after each cycle")
+	flags.BoolVar(&debugEnabled, "debug", false, "log debug details")
+	flags.BoolVar(&debugSelectors, "debug-selectors", false, "log what pages look like when the title or image selector matches nothing")
+	statsJson := flags.String("stats-json", "", "write per-image download records to this file after each cycle")
+	report := flags.String("report", "", "write a JSON report of the last cycle to this file")
+	strictLimits := flags.Bool("strict-limits", false, "fail pages over confirm_over_images or confirm_over_bytes")
+	deadline := flags.Duration("deadline", 0, "give up on the pages left once a cycle took this long")
+	noBlocklist := flags.Bool("no-blocklist", false, "download images on blocked_hosts too")
+	seed := flags.Int64("seed", 0, "seed the randomness of pacing, for repeatable runs")
+	freshSession := flags.Bool("fresh-session", false, "ignore the cookies of cookie_store for this run")
+	forceRefresh := flags.Bool("force-refresh", false, "scrape pages even when unchanged since their last scrape")
+	dump := dumpFlags(flags)
+	cassette := cassetteFlags(flags)
+	promptFlags(flags)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := checkPromptFlags(); err != nil {
+		return err
+	}
+	if err := config.readPassword(); err != nil {
+		return usageError(err)
+	}
+	if err := cassette.load(); err != nil {
+		return usageError(err)
+	}
+
+	if *metricsListen != "" {
+		go serveMetrics(*metricsListen)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	scraper := &Scraper{Config: config, SkipExisting: true, Unattended: true, StrictLimits: *strictLimits, NoBlocklist: *noBlocklist, Dump: dump, Cassette: cassette, Seed: *seed, FreshSession: *freshSession, Conditional: !*forceRefresh}
+	for cycle := 1; ; cycle++ {
+		log.Println("Cycle", cycle, "start")
+		if *verbose || *statsJson != "" {
+			scraper.Stats = &Stats{}
+		}
+		if *report != "" {
+			scraper.Report = &Report{}
+		}
+		dump.reset()
+		cycleCtx, cancel := ctx, context.CancelFunc(func() {})
+		if 0 < *deadline {
+			cycleCtx, cancel = context.WithTimeout(ctx, *deadline)
+		}
+		summary := runCycle(cycleCtx, scraper)
+		cancel()
+		log.Println("Cycle", cycle, "done:", summary.String())
+		scraper.reportCircuits()
+		scraper.reportThrottles()
+		if err := scraper.Stats.Report(*verbose, *statsJson); err != nil {
+			log.Println("Stats:", err)
+		}
+		if err := scraper.Report.Write(*report, exitCode(summary.Err())); err != nil {
+			log.Println("Report:", err)
+		}
+		if err := dump.WriteHar(); err != nil {
+			log.Println("HAR:", err)
+		}
+		if err := cassette.Save(); err != nil {
+			log.Println("Cassette:", err)
+		}
+		if ctx.Err() != nil {
+			log.Println("Stopped")
+			return &exitError{Code: exitInterrupted, Err: ctx.Err()}
+		}
+		if *once {
+			return summary.Err()
+		}
+
+		wait := jitter(*interval, *spread)
+		log.Println("Next check in", wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			log.Println("Stopped")
+			return &exitError{Code: exitInterrupted, Err: ctx.Err()}
+		}
+	}
+}
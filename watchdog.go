@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultProgressAfter = 10 * time.Second
+	progressInterval     = 5 * time.Second
+)
+
+var errStalled = errors.New("Download stalled")
+
+// Watchdog logs the progress of downloads running longer than
+// ProgressAfter (default 10s) or larger than ProgressOver, and warns of
+// those that read nothing for StallTimeout. StallRetry also retries them
+// as for a retried status.
+type Watchdog struct {
+	ProgressAfter Duration `toml:"progress_after"`
+	ProgressOver  Size     `toml:"progress_over"`
+	StallTimeout  Duration `toml:"stall_timeout"`
+	StallRetry    bool     `toml:"stall_retry"`
+}
+
+// watchDownload watches the download of ctx, image i, until ctx is done,
+// cancelling it with errStalled when it stalls under stall_retry.
+func (s *Scraper) watchDownload(ctx context.Context, i int, cancel context.CancelCauseFunc) {
+	d, ok := ctx.Value(activityKey{}).(*downloadActivity)
+	if !ok {
+		return
+	}
+	// Every attempt starts from nothing.
+	started := time.Now()
+	atomic.StoreInt64(&d.bytes, 0)
+	atomic.StoreInt64(&d.last, started.UnixNano())
+
+	w := s.Config.Watchdog
+	after := w.ProgressAfter.Duration
+	if after == 0 {
+		after = defaultProgressAfter
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		var lastBytes int64
+		lastLog := started
+		stalled := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				idle := now.Sub(time.Unix(0, atomic.LoadInt64(&d.last)))
+				if 0 < w.StallTimeout.Duration && w.StallTimeout.Duration <= idle {
+					if !stalled {
+						logln(ctx, "WARNING: no data for", idle.Round(time.Second), "[", i, "]", d.Url)
+						stalled = true
+					}
+					if w.StallRetry {
+						cancel(errStalled)
+						return
+					}
+				} else {
+					stalled = false
+				}
+
+				bytes := atomic.LoadInt64(&d.bytes)
+				large := 0 < w.ProgressOver.Bytes && w.ProgressOver.Bytes < atomic.LoadInt64(&d.total)
+				if (large || after <= now.Sub(started)) && progressInterval <= now.Sub(lastLog) {
+					speed := float64(bytes-lastBytes) / now.Sub(lastLog).Seconds()
+					logln(ctx, "PROGRESS", "[", i, "]", d.Url, d.size()+",", formatBytes(int64(speed))+"/s")
+					lastLog, lastBytes = now, bytes
+				}
+			}
+		}
+	}()
+}
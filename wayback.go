@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// waybackApi is the Wayback Machine availability API.
+var waybackApi = "https://archive.org/wayback/available"
+
+// waybackInterval spaces out requests to the Wayback Machine, across
+// every page of the run.
+const waybackInterval = 2 * time.Second
+
+// Snapshot is the Wayback Machine capture an image was downloaded from
+// in place of its dead src.
+type Snapshot struct {
+	Timestamp string `json:"timestamp"`
+	Url       string `json:"url"`
+}
+
+var wayback = &waybackThrottle{}
+
+type waybackThrottle struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the next Wayback request may go out.
+func (t *waybackThrottle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(waybackInterval)
+	t.mu.Unlock()
+	return sleep(ctx, delay)
+}
+
+// gone reports whether err is a 404 or 410 worth asking the Wayback
+// Machine about.
+func gone(err error) bool {
+	var status *StatusError
+	return errors.As(err, &status) && (status.Code == http.StatusNotFound || status.Code == http.StatusGone)
+}
+
+// fromWayback downloads the closest Wayback Machine capture of src. It
+// is best effort: any failure is reported and the caller keeps its own
+// error.
+func (s *Scraper) fromWayback(ctx context.Context, client *http.Client, src string) (*Image, error) {
+	if err := wayback.wait(ctx); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", waybackApi+"?url="+url.QueryEscape(src), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Wayback availability API: " + res.Status)
+	}
+	var available struct {
+		ArchivedSnapshots struct {
+			Closest *struct {
+				Available bool   `json:"available"`
+				Url       string `json:"url"`
+				Timestamp string `json:"timestamp"`
+				Status    string `json:"status"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&available); err != nil {
+		return nil, errors.New("Wayback availability API: " + err.Error())
+	}
+	closest := available.ArchivedSnapshots.Closest
+	if closest == nil || !closest.Available || closest.Status != "200" {
+		return nil, errors.New("No Wayback snapshot")
+	}
+
+	// id_ asks for the capture as it was, without the Wayback toolbar
+	// or rewritten links.
+	raw := strings.Replace(closest.Url, "/"+closest.Timestamp+"/", "/"+closest.Timestamp+"id_/", 1)
+	if err := wayback.wait(ctx); err != nil {
+		return nil, err
+	}
+	image, err := downloadImage(ctx, client, raw)
+	if err != nil {
+		return nil, err
+	}
+	image.Snapshot = &Snapshot{Timestamp: closest.Timestamp, Url: closest.Url}
+	return image, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type webdavUploader struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+func newWebdavUploader(u *Upload) (*webdavUploader, error) {
+	base, err := url.Parse(strings.TrimSuffix(u.Url, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if u.SkipTLSVerify {
+		log.Println("WARNING: TLS certificate verification is DISABLED for", base.Host)
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &webdavUploader{
+		base:     base,
+		username: os.ExpandEnv(u.Username),
+		password: os.ExpandEnv(u.Password),
+		client:   &http.Client{Transport: transport, Timeout: 30 * time.Minute},
+	}, nil
+}
+
+func (w *webdavUploader) request(ctx context.Context, method string, target *url.URL, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if w.username != "" || w.password != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res, nil
+}
+
+func (w *webdavUploader) resolve(name string) *url.URL {
+	return w.base.ResolveReference(&url.URL{Path: strings.TrimPrefix(name, "/")})
+}
+
+// mkcol creates every missing collection above name. 405 means the
+// collection already exists.
+func (w *webdavUploader) mkcol(ctx context.Context, name string) error {
+	segments := strings.Split(strings.Trim(name, "/"), "/")
+	dir := ""
+	for _, segment := range segments[:len(segments)-1] {
+		dir += segment + "/"
+		res, err := w.request(ctx, "MKCOL", w.resolve(dir), nil, 0)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusMethodNotAllowed {
+			return errors.New("webdav MKCOL " + dir + ": " + res.Status)
+		}
+	}
+	return nil
+}
+
+func (w *webdavUploader) Upload(ctx context.Context, local string, name string) (string, error) {
+	info, err := os.Stat(local)
+	if err != nil {
+		return "", err
+	}
+	target := w.resolve(name)
+
+	err = withRetries(ctx, "webdav upload", func() error {
+		if err := w.mkcol(ctx, name); err != nil {
+			return err
+		}
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		res, err := w.request(ctx, "PUT", target, f, info.Size())
+		if err != nil {
+			return err
+		}
+		if res.StatusCode < 200 || 299 < res.StatusCode {
+			return errors.New("webdav PUT: " + res.Status)
+		}
+
+		res, err = w.request(ctx, "HEAD", target, nil, 0)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			return errors.New("webdav HEAD: " + res.Status)
+		}
+		if res.ContentLength != info.Size() {
+			return errors.New("webdav size mismatch: uploaded " + strconv.FormatInt(info.Size(), 10) +
+				" bytes, server has " + strconv.FormatInt(res.ContentLength, 10))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return target.String(), nil
+}
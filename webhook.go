@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultWebhookRetries = 3
+	webhookTimeout        = 30 * time.Second
+)
+
+type webhookPayload struct {
+	Status   string   `json:"status"`
+	Page     string   `json:"page"`
+	Url      string   `json:"url"`
+	Title    string   `json:"title"`
+	Path     string   `json:"path"`
+	Images   int      `json:"images"`
+	Failed   int      `json:"failed"`
+	Bytes    int64    `json:"bytes"`
+	Duration float64  `json:"duration_seconds"`
+	Errors   []string `json:"errors"`
+}
+
+func newWebhookPayload(result *Result, err error) webhookPayload {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	} else if result.Skipped {
+		status = "skipped"
+	}
+	errs := result.Errors
+	if errs == nil {
+		errs = []string{}
+	}
+	return webhookPayload{
+		Status:   status,
+		Page:     result.Page,
+		Url:      result.Url,
+		Title:    result.Title,
+		Path:     result.Path,
+		Images:   result.Images,
+		Failed:   result.Failed,
+		Bytes:    result.Bytes,
+		Duration: result.Duration.Seconds(),
+		Errors:   errs,
+	}
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(ctx context.Context, url string, secret string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("X-Scrape-Go-Signature", sign(secret, body))
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || 299 < res.StatusCode {
+		return errors.New("webhook responded " + strconv.Itoa(res.StatusCode))
+	}
+	return nil
+}
+
+// sendWebhook reports result to the page's webhook, falling back to the
+// global one. Failures are only logged: a webhook must never fail a scrape.
+// It gives up when ctx is done.
+func (s *Scraper) sendWebhook(ctx context.Context, page *Page, result *Result, scrapeErr error) {
+	url, secret := page.WebhookUrl, page.WebhookSecret
+	if url == "" {
+		url = s.Config.WebhookUrl
+	}
+	if secret == "" {
+		secret = s.Config.WebhookSecret
+	}
+	if url == "" {
+		return
+	}
+	retries := defaultWebhookRetries
+	if s.Config.WebhookRetries != nil {
+		retries = *s.Config.WebhookRetries
+	}
+
+	body, err := json.Marshal(newWebhookPayload(result, scrapeErr))
+	if err != nil {
+		log.Println("Webhook", err)
+		return
+	}
+	for attempt := 0; attempt <= retries; attempt++ {
+		if 0 < attempt {
+			if err := sleep(ctx, time.Duration(attempt)*time.Second); err != nil {
+				break
+			}
+		}
+		err = postWebhook(ctx, url, secret, body)
+		if err == nil {
+			return
+		}
+		log.Println("Webhook attempt", attempt+1, "failed:", err)
+	}
+	log.Println("Webhook gave up on", url)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookRetries(t *testing.T) {
+	quiet(t)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		keys string
+		want int32
+	}{
+		{"webhook_retries = 0", 1},
+		{"webhook_retries = 1", 2},
+	} {
+		config := retriesConfig(t, "webhook_url = \""+server.URL+"\"\n"+test.keys, "")
+		scraper := &Scraper{Config: config}
+		atomic.StoreInt32(&requests, 0)
+		scraper.sendWebhook(context.Background(), &config.Pages[0], &Result{}, nil)
+		if got := atomic.LoadInt32(&requests); got != test.want {
+			t.Errorf("%q: %d requests, want %d", test.keys, got, test.want)
+		}
+	}
+}
+
+// An interrupted scrape does not wait out the retries of its webhook.
+func TestWebhookInterrupted(t *testing.T) {
+	quiet(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	config := retriesConfig(t, "webhook_url = \""+server.URL+"\"", "")
+	scraper := &Scraper{Config: config}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	scraper.sendWebhook(ctx, &config.Pages[0], &Result{}, nil)
+	if d := time.Since(start); time.Second < d {
+		t.Errorf("took %v", d)
+	}
+}
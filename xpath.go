@@ -0,0 +1,175 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// xpathPrefix marks a selector as an XPath expression rather than CSS.
+const xpathPrefix = "xpath:"
+
+func isXPath(selector string) bool {
+	return strings.HasPrefix(selector, xpathPrefix)
+}
+
+func compileSelector(selector string) error {
+	if isXPath(selector) {
+		_, err := xpath.Compile(strings.TrimPrefix(selector, xpathPrefix))
+		return err
+	}
+	return nil
+}
+
+// find evaluates selector, CSS or "xpath:"-prefixed XPath, against doc.
+// XPath results that are attributes or text select their element.
+func find(doc *goquery.Document, selector string) *goquery.Selection {
+	if !isXPath(selector) {
+		return doc.Find(selector)
+	}
+	expr, err := xpath.Compile(strings.TrimPrefix(selector, xpathPrefix))
+	if err != nil {
+		// Selectors are compiled by Config.Validate, so this is unreachable
+		// for configured pages.
+		return doc.Find("")
+	}
+	var nodes []*html.Node
+	seen := make(map[*html.Node]bool)
+	root := doc.Nodes[0]
+	iter := expr.Select(&htmlNavigator{root: root, curr: root, attr: -1})
+	for iter.MoveNext() {
+		node := iter.Current().(*htmlNavigator).curr
+		if node.Type == html.TextNode && node.Parent != nil {
+			node = node.Parent
+		}
+		if node.Type == html.ElementNode && !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return doc.FindNodes(nodes...)
+}
+
+// htmlNavigator implements xpath.NodeNavigator over a parsed HTML tree.
+type htmlNavigator struct {
+	root *html.Node
+	curr *html.Node
+	attr int
+}
+
+func (h *htmlNavigator) NodeType() xpath.NodeType {
+	switch h.curr.Type {
+	case html.CommentNode:
+		return xpath.CommentNode
+	case html.TextNode:
+		return xpath.TextNode
+	case html.ElementNode:
+		if h.attr != -1 {
+			return xpath.AttributeNode
+		}
+		return xpath.ElementNode
+	default:
+		return xpath.RootNode
+	}
+}
+
+func (h *htmlNavigator) LocalName() string {
+	if h.attr != -1 {
+		return h.curr.Attr[h.attr].Key
+	}
+	return h.curr.Data
+}
+
+func (h *htmlNavigator) Prefix() string {
+	return ""
+}
+
+func (h *htmlNavigator) Value() string {
+	switch h.curr.Type {
+	case html.CommentNode, html.TextNode:
+		return h.curr.Data
+	case html.ElementNode:
+		if h.attr != -1 {
+			return h.curr.Attr[h.attr].Val
+		}
+		return goquery.NewDocumentFromNode(h.curr).Text()
+	}
+	return ""
+}
+
+func (h *htmlNavigator) Copy() xpath.NodeNavigator {
+	n := *h
+	return &n
+}
+
+func (h *htmlNavigator) MoveToRoot() {
+	h.curr, h.attr = h.root, -1
+}
+
+func (h *htmlNavigator) MoveToParent() bool {
+	if h.attr != -1 {
+		h.attr = -1
+		return true
+	}
+	if h.curr.Parent == nil {
+		return false
+	}
+	h.curr = h.curr.Parent
+	return true
+}
+
+func (h *htmlNavigator) MoveToNextAttribute() bool {
+	if len(h.curr.Attr)-1 <= h.attr {
+		return false
+	}
+	h.attr++
+	return true
+}
+
+func (h *htmlNavigator) MoveToChild() bool {
+	if h.attr != -1 || h.curr.FirstChild == nil {
+		return false
+	}
+	h.curr = h.curr.FirstChild
+	return true
+}
+
+func (h *htmlNavigator) MoveToFirst() bool {
+	if h.attr != -1 || h.curr.PrevSibling == nil {
+		return false
+	}
+	for h.curr.PrevSibling != nil {
+		h.curr = h.curr.PrevSibling
+	}
+	return true
+}
+
+func (h *htmlNavigator) MoveToNext() bool {
+	if h.attr != -1 || h.curr.NextSibling == nil {
+		return false
+	}
+	h.curr = h.curr.NextSibling
+	return true
+}
+
+func (h *htmlNavigator) MoveToPrevious() bool {
+	if h.attr != -1 || h.curr.PrevSibling == nil {
+		return false
+	}
+	h.curr = h.curr.PrevSibling
+	return true
+}
+
+func (h *htmlNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	node, ok := other.(*htmlNavigator)
+	if !ok || node.root != h.root {
+		return false
+	}
+	h.curr, h.attr = node.curr, node.attr
+	return true
+}
+
+func (h *htmlNavigator) String() string {
+	return h.Value()
+}